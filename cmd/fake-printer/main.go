@@ -0,0 +1,40 @@
+// Command fake-printer listens like a network receipt printer and prints the tickets it receives
+// to stdout, so ticket printing can be tried without hardware.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"vortex.studio/account/internal/printing"
+)
+
+func main() {
+	addr := flag.String("addr", ":"+printing.DefaultPort, "address to listen on")
+	fail := flag.Bool("fail", false, "refuse every connection to test retries")
+	flag.Parse()
+
+	printer, err := printing.NewFakePrinter(*addr)
+	if err != nil {
+		log.Fatalf("Failed to start fake printer: %v", err)
+	}
+	defer printer.Close()
+	if err := printer.SetFailing(*fail); err != nil {
+		log.Fatalf("Failed to stop fake printer: %v", err)
+	}
+	printer.OnTicket = func(data []byte) {
+		fmt.Printf("----- ticket (%d bytes) -----\n%s\n", len(data), printing.Decode(data))
+	}
+	if *fail {
+		log.Printf("Fake printer refusing connections on %s", printer.Addr())
+	} else {
+		log.Printf("Fake printer listening on %s", printer.Addr())
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
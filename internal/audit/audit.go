@@ -0,0 +1,163 @@
+// Package audit describes the changes staff make so they can be kept in the audit log.
+//
+// Handlers describe a change through the request context: what they did, to what, and the
+// changed object before and after. Objects are snapshotted when they are passed in, so handlers
+// can keep modifying them afterwards.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"vortex.studio/account/internal/structs"
+)
+
+type contextKey struct{}
+
+// Recorder collects the description of a change made by a request.
+type Recorder struct {
+	tenantID  string
+	actorID   string
+	actor     string
+	action    string
+	target    string
+	before    map[string]string
+	after     map[string]string
+	discarded bool
+}
+
+// NewContext returns a context the change of a request is described on.
+func NewContext(ctx context.Context) (context.Context, *Recorder) {
+	rec := &Recorder{}
+	return context.WithValue(ctx, contextKey{}, rec), rec
+}
+
+func fromContext(ctx context.Context) *Recorder {
+	rec, _ := ctx.Value(contextKey{}).(*Recorder)
+	return rec
+}
+
+// Actor sets who made the change and in which tenant. Changes nobody is set as the actor of
+// aren't recorded.
+func Actor(ctx context.Context, tenantID, id, name string) {
+	if rec := fromContext(ctx); rec != nil {
+		rec.tenantID, rec.actorID, rec.actor = tenantID, id, name
+	}
+}
+
+// Describe names the change made by the request and what it was made to.
+func Describe(ctx context.Context, action, target string) {
+	if rec := fromContext(ctx); rec != nil {
+		rec.action, rec.target = action, target
+	}
+}
+
+// Before snapshots the changed object as it was before the change.
+func Before(ctx context.Context, v interface{}) {
+	if rec := fromContext(ctx); rec != nil {
+		rec.before = snapshot(v)
+	}
+}
+
+// After snapshots the changed object as it is after the change.
+func After(ctx context.Context, v interface{}) {
+	if rec := fromContext(ctx); rec != nil {
+		rec.after = snapshot(v)
+	}
+}
+
+// Discard drops the description, for requests that end up not changing anything without
+// failing.
+func Discard(ctx context.Context) {
+	if rec := fromContext(ctx); rec != nil {
+		rec.discarded = true
+	}
+}
+
+// Entry returns the audit entry of the change, false when the request didn't describe a
+// change by a known actor.
+func (r *Recorder) Entry() (*structs.AuditEntry, bool) {
+	if r.discarded || r.actor == "" {
+		return nil, false
+	}
+	return &structs.AuditEntry{
+		TenantID: r.tenantID,
+		ActorID:  r.actorID,
+		Actor:    r.actor,
+		Action:   r.action,
+		Target:   r.target,
+		Changes:  diff(r.before, r.after),
+	}, true
+}
+
+// Diff returns the fields that differ between two objects, either of which can be nil.
+func Diff(before, after interface{}) []structs.AuditChange {
+	return diff(snapshot(before), snapshot(after))
+}
+
+func diff(before, after map[string]string) []structs.AuditChange {
+	fields := map[string]bool{}
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	var changes []structs.AuditChange
+	for field := range fields {
+		if before[field] != after[field] {
+			changes = append(changes, structs.AuditChange{Field: field, Before: before[field], After: after[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// snapshot flattens the JSON form of an object into its fields, nested fields are named by their
+// path like "tables.2.label".
+func snapshot(v interface{}) map[string]string {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return map[string]string{"": fmt.Sprintf("%v", v)}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return map[string]string{"": string(data)}
+	}
+
+	fields := map[string]string{}
+	flatten(fields, "", value)
+	return fields
+}
+
+func flatten(fields map[string]string, path string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			flatten(fields, join(path, key), child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			flatten(fields, join(path, fmt.Sprint(i)), child)
+		}
+	case nil:
+	default:
+		fields[path] = fmt.Sprint(value)
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,201 @@
+// Package auth signs staff in with OpenID Connect against the Keycloak realm of their tenant.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// DefaultClientID is the OIDC client the service is registered as in every tenant realm.
+const DefaultClientID = "the-account"
+
+var ErrNotConfigured = errors.New("OIDC login is not configured")
+
+// Config is the OIDC client configuration shared by all tenant realms.
+type Config struct {
+	KeycloakURL  string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// ConfigFromEnv reads the client configuration from KEYCLOAK_URL, OIDC_CLIENT_ID and
+// OIDC_CLIENT_SECRET. Users are sent back to /auth/callback under publicBaseURL.
+func ConfigFromEnv(publicBaseURL string) Config {
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		clientID = DefaultClientID
+	}
+	return Config{
+		KeycloakURL:  strings.TrimSuffix(os.Getenv("KEYCLOAK_URL"), "/"),
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  strings.TrimSuffix(publicBaseURL, "/") + "/auth/callback",
+	}
+}
+
+// Configured reports whether login can be attempted with this configuration.
+func (c Config) Configured() bool {
+	return c.KeycloakURL != "" && c.ClientSecret != ""
+}
+
+// Identity is the signed in staff member, as stated by a verified ID token.
+type Identity struct {
+	Subject  string
+	Username string
+	Email    string
+	Name     string
+	Tenant   string
+	Roles    []string
+}
+
+// Authenticator runs the authorization code flow. Providers are discovered the first time a
+// realm is used and kept for later logins.
+type Authenticator struct {
+	config    Config
+	mu        sync.Mutex
+	providers map[string]*oidc.Provider
+}
+
+func NewAuthenticator(config Config) *Authenticator {
+	return &Authenticator{
+		config:    config,
+		providers: map[string]*oidc.Provider{},
+	}
+}
+
+// AuthCodeURL returns the URL of the realm login page. state and nonce must be kept by the
+// caller to check the callback with.
+func (a *Authenticator) AuthCodeURL(ctx context.Context, realm, state, nonce string) (string, error) {
+	provider, err := a.provider(ctx, realm)
+	if err != nil {
+		return "", err
+	}
+	return a.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Exchange trades the code of a callback for tokens and returns the identity of the verified
+// ID token.
+func (a *Authenticator) Exchange(ctx context.Context, realm, code, nonce string) (*Identity, error) {
+	provider, err := a.provider(ctx, realm)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := a.oauth2Config(provider).Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: a.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims struct {
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+		Name              string `json:"name"`
+		RealmAccess       struct {
+			Roles []string `json:"roles"`
+		} `json:"realm_access"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to read ID token claims: %w", err)
+	}
+
+	return &Identity{
+		Subject:  idToken.Subject,
+		Username: claims.PreferredUsername,
+		Email:    claims.Email,
+		Name:     claims.Name,
+		Tenant:   realm,
+		Roles:    staffRoles(claims.RealmAccess.Roles),
+	}, nil
+}
+
+// LogoutURL returns the URL that ends the Keycloak session of the realm and sends the user to
+// redirect, or an empty string when the realm doesn't support it.
+func (a *Authenticator) LogoutURL(ctx context.Context, realm, redirect string) (string, error) {
+	provider, err := a.provider(ctx, realm)
+	if err != nil {
+		return "", err
+	}
+
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&metadata); err != nil || metadata.EndSessionEndpoint == "" {
+		return "", err
+	}
+	query := url.Values{
+		"client_id":                {a.config.ClientID},
+		"post_logout_redirect_uri": {redirect},
+	}
+	return metadata.EndSessionEndpoint + "?" + query.Encode(), nil
+}
+
+// Issuer returns the issuer URL of a tenant realm.
+func (a *Authenticator) Issuer(realm string) string {
+	return fmt.Sprintf("%s/realms/%s", a.config.KeycloakURL, url.PathEscape(realm))
+}
+
+func (a *Authenticator) provider(ctx context.Context, realm string) (*oidc.Provider, error) {
+	if !a.config.Configured() {
+		return nil, ErrNotConfigured
+	}
+	if realm == "" {
+		return nil, errors.New("missing realm")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if provider, ok := a.providers[realm]; ok {
+		return provider, nil
+	}
+
+	// The provider keeps using its context to refresh the realm keys, so it must outlive the
+	// request it is discovered in
+	provider, err := oidc.NewProvider(context.WithoutCancel(ctx), a.Issuer(realm))
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover realm %s: %w", realm, err)
+	}
+	a.providers[realm] = provider
+	return provider, nil
+}
+
+func (a *Authenticator) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     a.config.ClientID,
+		ClientSecret: a.config.ClientSecret,
+		RedirectURL:  a.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+}
+
+// RandomString returns a URL safe random string, used for the state and nonce of a login.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
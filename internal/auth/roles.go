@@ -0,0 +1,87 @@
+package auth
+
+// Staff roles, defined as realm roles in every tenant realm.
+const (
+	RoleOwner   = "owner"
+	RoleManager = "manager"
+	RoleWaiter  = "waiter"
+	RoleKitchen = "kitchen"
+)
+
+// Roles lists the staff roles from most to least privileged.
+var Roles = []string{RoleOwner, RoleManager, RoleWaiter, RoleKitchen}
+
+// IsRole reports whether name is one of the staff roles.
+func IsRole(name string) bool {
+	for _, role := range Roles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Permission is an action on the admin side a role may be granted.
+type Permission string
+
+const (
+	// ViewDashboard is reading the admin dashboard and open sessions
+	ViewDashboard Permission = "view_dashboard"
+	// ManageTables is closing, moving and merging table sessions, seating the waitlist and
+	// handling reservations
+	ManageTables Permission = "manage_tables"
+	// PrintTickets is following and reprinting order tickets
+	PrintTickets Permission = "print_tickets"
+	// ManageVenues is creating venues and changing their menus, images and settings
+	ManageVenues Permission = "manage_venues"
+	// DeleteVenues is deleting venues
+	DeleteVenues Permission = "delete_venues"
+	// ViewReports is reading guest feedback and other reports
+	ViewReports Permission = "view_reports"
+	// ManageStaff is inviting staff, changing their roles and removing them
+	ManageStaff Permission = "manage_staff"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner:   {ViewDashboard, ManageTables, PrintTickets, ManageVenues, DeleteVenues, ViewReports, ManageStaff},
+	RoleManager: {ViewDashboard, ManageTables, PrintTickets, ManageVenues, ViewReports},
+	RoleWaiter:  {ViewDashboard, ManageTables},
+	RoleKitchen: {ViewDashboard, PrintTickets},
+}
+
+// Allowed reports whether any of the roles grants the permission.
+func Allowed(roles []string, permission Permission) bool {
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions granted by the roles, keyed by name for templates.
+func Permissions(roles []string) map[string]bool {
+	permissions := map[string]bool{}
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			permissions[string(granted)] = true
+		}
+	}
+	return permissions
+}
+
+// staffRoles keeps the staff roles out of all the realm roles of a user.
+func staffRoles(realmRoles []string) []string {
+	var roles []string
+	for _, role := range Roles {
+		for _, realmRole := range realmRoles {
+			if realmRole == role {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+	return roles
+}
@@ -2,29 +2,45 @@ package handlers
 
 import (
 	"errors"
+	"github.com/gorilla/mux"
 	"github.com/vorticist/logger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/blobstore"
+	"vortex.studio/account/internal/pubsub"
 	"vortex.studio/account/internal/repo"
 	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/utils"
 
 	menu "vortex.studio/account/internal/menu-analyzer"
 )
 
 type AdminHandler struct {
-	venueRepo  *repo.VenueRepository
-	tablesRepo *repo.ActiveTablesRepository
-	menuRepo   *repo.MenuRepository
+	venueRepo        *repo.VenueRepository
+	tablesRepo       *repo.ActiveTablesRepository
+	menuRepo         *repo.MenuRepository
+	reservationsRepo *repo.ReservationsRepository
+	images           blobstore.Store
+	auth             *auth.Authenticator
+	events           *sessionEvents
 }
 
-func NewAdminHandler(repository repo.VenueRepository, tablesRepo *repo.ActiveTablesRepository, menuRepo *repo.MenuRepository) *AdminHandler {
+func NewAdminHandler(repository repo.VenueRepository, tablesRepo *repo.ActiveTablesRepository, menuRepo *repo.MenuRepository, reservationsRepo *repo.ReservationsRepository, images blobstore.Store, authenticator *auth.Authenticator, bus pubsub.Bus) *AdminHandler {
 	return &AdminHandler{
-		venueRepo:  &repository,
-		tablesRepo: tablesRepo,
-		menuRepo:   menuRepo,
+		venueRepo:        &repository,
+		tablesRepo:       tablesRepo,
+		menuRepo:         menuRepo,
+		reservationsRepo: reservationsRepo,
+		images:           images,
+		auth:             authenticator,
+		events:           newSessionEvents(bus, &repository),
 	}
 }
 
@@ -32,33 +48,51 @@ func (h *AdminHandler) AccountHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
 
 	// Check if user is authenticated
-	auth, ok := session.Values["authenticated"].(bool)
-	if !ok || !auth {
-		tmpl := template.Must(template.ParseFiles("templates/login.html"))
-		tmpl.Execute(w, nil)
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		renderLogin(w, http.StatusOK, "", "")
+		return
+	}
+	// The dashboard isn't behind Require, disabled or demoted staff are caught here the same way
+	if !recheckSession(w, r, session) {
+		renderLogin(w, http.StatusOK, "", "")
 		return
 	}
+	if !auth.Allowed(sessionRoles(session), auth.ViewDashboard) {
+		http.Error(w, "Your account has no staff role, ask the owner of the business for access", http.StatusForbidden)
+		return
+	}
+	csrf := ensureCSRFToken(w, r, session)
 
-	venues, err := h.venueRepo.GetAllVenues(r.Context())
+	venues, err := h.venueRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
 	if err != nil {
 		logger.Errorf("error fetching venues: %v", err)
 		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
 		return
 	}
 
-	openSessions, err := h.tablesRepo.GetOpenSessions(r.Context())
+	openSessions, err := h.tablesRepo.GetOpenSessionsForVenues(r.Context(), venues)
 	if err != nil {
 		logger.Errorf("error fetching open sessions: %v", err)
 		http.Error(w, "Error fetching open sessions", http.StatusInternalServerError)
 		return
 	}
+	menus, err := h.tenantMenus(r.Context(), sessionTenant(session), venues)
+	if err != nil {
+		logger.Errorf("error fetching menus: %v", err)
+		http.Error(w, "Error fetching menus", http.StatusInternalServerError)
+		return
+	}
 
 	adminPage := structs.AdminPage{
 		Title:        "Table Codes",
 		Venues:       venues,
 		OpenSessions: openSessions,
+		Menus:        menus,
+		Can:          auth.Permissions(sessionRoles(session)),
+		CSRFToken:    csrf,
 	}
-	tmpl := template.Must(template.New("admin.html").Funcs(templateFuncs).ParseFiles("templates/admin.html"))
+	tmpl := template.Must(template.New("admin.html").Funcs(templateFuncs).ParseFiles("templates/admin.html", "templates/open-sessions.html", "templates/session-card.html", "templates/merge-targets.html", "templates/venue-list.html"))
 	err = tmpl.Execute(w, adminPage)
 	if err != nil {
 		logger.Errorf("error executing template: %v", err)
@@ -95,6 +129,8 @@ func (h *AdminHandler) AddTableHandler(w http.ResponseWriter, r *http.Request) {
 		TableCodes:  []structs.TableCode{},
 	}
 	generateTableCodes(&venue, tables)
+	// The tables are only previewed, nothing changes
+	audit.Discard(r.Context())
 
 	adminPage := structs.AdminPage{
 		Title:  "Table Codes",
@@ -107,33 +143,138 @@ func (h *AdminHandler) AddTableHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl.Execute(w, adminPage)
 }
 
+// LoginHandler starts the sign in of a staff member at the login page of their tenant realm.
 func (h *AdminHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
 
-	// Here you would validate credentials
-	// This is a simplified example
-	username := r.FormValue("username")
-	password := r.FormValue("password")
+	tenant := strings.TrimSpace(r.FormValue("tenant"))
+	if tenant == "" {
+		renderLogin(w, http.StatusBadRequest, tenant, "Enter your business to sign in")
+		return
+	}
+
+	state, err := auth.RandomString()
+	if err != nil {
+		logger.Errorf("error generating login state: %v", err)
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := auth.RandomString()
+	if err != nil {
+		logger.Errorf("error generating login nonce: %v", err)
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
 
-	logger.Infof("loginHandler username: %s, password: %s", username, password)
+	loginURL, err := h.auth.AuthCodeURL(r.Context(), tenant, state, nonce)
+	if errors.Is(err, auth.ErrNotConfigured) {
+		renderLogin(w, http.StatusServiceUnavailable, tenant, "Login is not available right now")
+		return
+	}
+	if err != nil {
+		logger.Errorf("error starting login for %v: %v", tenant, err)
+		renderLogin(w, http.StatusNotFound, tenant, "Unknown business")
+		return
+	}
 
-	if username == "admin" && password == "password" {
-		session.Values["authenticated"] = true
+	session.Values["login_state"] = state
+	session.Values["login_nonce"] = nonce
+	session.Values["login_tenant"] = tenant
+	if err := session.Save(r, w); err != nil {
+		logger.Errorf("error saving session: %v", err)
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, loginURL, http.StatusSeeOther)
+}
+
+// CallbackHandler completes a login once the realm redirects back with an authorization code.
+func (h *AdminHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	state, _ := session.Values["login_state"].(string)
+	nonce, _ := session.Values["login_nonce"].(string)
+	tenant, _ := session.Values["login_tenant"].(string)
+	delete(session.Values, "login_state")
+	delete(session.Values, "login_nonce")
+	delete(session.Values, "login_tenant")
+
+	if state == "" || r.FormValue("state") != state {
+		session.Save(r, w)
+		renderLogin(w, http.StatusBadRequest, tenant, "Your login expired, please try again")
+		return
+	}
+	if loginError := r.FormValue("error"); loginError != "" {
+		logger.Errorf("login for %v failed: %v %v", tenant, loginError, r.FormValue("error_description"))
 		session.Save(r, w)
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		renderLogin(w, http.StatusUnauthorized, tenant, "Login failed")
 		return
 	}
 
+	identity, err := h.auth.Exchange(r.Context(), tenant, r.FormValue("code"), nonce)
+	if err != nil {
+		logger.Errorf("error completing login for %v: %v", tenant, err)
+		session.Save(r, w)
+		renderLogin(w, http.StatusUnauthorized, tenant, "Login failed")
+		return
+	}
+
+	session.Values["authenticated"] = true
+	session.Values["user_id"] = identity.Subject
+	session.Values["user"] = identity.Username
+	session.Values["tenant"] = identity.Tenant
+	session.Values["roles"] = identity.Roles
+	session.Values[checkedAtSessionKey] = time.Now().Unix()
+	// a new token for every login, one planted before the login is worthless afterwards
+	csrf, err := auth.RandomString()
+	if err != nil {
+		logger.Errorf("error generating csrf token: %v", err)
+		http.Error(w, "Error completing login", http.StatusInternalServerError)
+		return
+	}
+	session.Values[csrfSessionKey] = csrf
+	if err := session.Save(r, w); err != nil {
+		logger.Errorf("error saving session: %v", err)
+		http.Error(w, "Error completing login", http.StatusInternalServerError)
+		return
+	}
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
+// LogoutHandler clears the session and ends the realm session too, so the next login asks for
+// credentials again.
 func (h *AdminHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
-	session.Values["authenticated"] = false
+	tenant := sessionTenant(session)
+	signOut(session)
 	session.Save(r, w)
+
+	if tenant != "" {
+		logoutURL, err := h.auth.LogoutURL(r.Context(), tenant, utils.PublicBaseURL()+"/admin")
+		if err != nil {
+			logger.Errorf("error building logout url for %v: %v", tenant, err)
+		}
+		if logoutURL != "" {
+			http.Redirect(w, r, logoutURL, http.StatusSeeOther)
+			return
+		}
+	}
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
+func renderLogin(w http.ResponseWriter, status int, tenant, message string) {
+	w.WriteHeader(status)
+	tmpl := template.Must(template.ParseFiles("templates/login.html"))
+	err := tmpl.Execute(w, structs.LoginPage{
+		Title:  "Login",
+		Tenant: tenant,
+		Error:  message,
+	})
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+	}
+}
+
 func (h *AdminHandler) VenueHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
 	auth, ok := session.Values["authenticated"].(bool)
@@ -161,62 +302,363 @@ func (h *AdminHandler) VenueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get file from form
-	file, _, err := r.FormFile("menuFile")
-	if err != nil {
-		logger.Errorf("error getting file from form: %v", err)
-		http.Error(w, "Error getting file from form", http.StatusBadRequest)
-		return
-	}
-	ar := menu.StartMenuFileAnalysis(file)
-	menuAnalysisResult := <-ar
-	if menuAnalysisResult.Err != nil {
-		logger.Errorf("error analyzing menu file: %v", menuAnalysisResult.Err)
-		http.Error(w, "Error analyzing menu file", http.StatusInternalServerError)
-		return
+	var taxRate float64
+	if taxRateStr := r.FormValue("taxRate"); taxRateStr != "" {
+		taxRate, err = strconv.ParseFloat(taxRateStr, 64)
+		if err != nil || taxRate < 0 {
+			http.Error(w, "Tax rate must be a valid percentage", http.StatusBadRequest)
+			return
+		}
 	}
 
 	venue := structs.Venue{
-		Name: name,
+		Name:     name,
+		Address:  r.FormValue("address"),
+		Phone:    r.FormValue("phone"),
+		TaxID:    r.FormValue("taxId"),
+		TaxRate:  taxRate,
+		TenantID: sessionTenant(session),
+	}
+
+	// Venues can use a menu of the business instead of having the same one analyzed again
+	var analysis *menu.AnalysisData
+	if menuID := r.FormValue("menuId"); menuID != "" {
+		sharedMenu, ok := h.getMenu(w, r, menuID)
+		if !ok {
+			return
+		}
+		venue.MenuID = sharedMenu.ID
+	} else {
+		// Get file from form
+		file, _, err := r.FormFile("menuFile")
+		if err != nil {
+			logger.Errorf("error getting file from form: %v", err)
+			http.Error(w, "Error getting file from form", http.StatusBadRequest)
+			return
+		}
+		ar := menu.StartMenuFileAnalysis(file)
+		menuAnalysisResult := <-ar
+		if menuAnalysisResult.Err != nil {
+			logger.Errorf("error analyzing menu file: %v", menuAnalysisResult.Err)
+			http.Error(w, "Error analyzing menu file", http.StatusInternalServerError)
+			return
+		}
+		analysis = menuAnalysisResult.Result
+		analysis.ID = primitive.NewObjectID()
+		analysis.TenantID = venue.TenantID
+		analysis.Name = venue.Name
+		venue.MenuID = analysis.ID
+	}
+
+	// The venue image is optional
+	imageFile, _, err := r.FormFile("venueImage")
+	if err == nil {
+		defer imageFile.Close()
+		venue.Image, venue.Thumbnail, err = storeImage(r.Context(), h.images, imageFile, "venues")
+		if err != nil {
+			logger.Errorf("error storing venue image: %v", err)
+			http.Error(w, "Error storing venue image", http.StatusBadRequest)
+			return
+		}
 	}
 
 	if numberOfTables > 0 {
 		generateTableCodes(&venue, numberOfTables)
+	}
 
+	// The menu is saved first so the venue never points at a menu that failed to save
+	venue.ID = primitive.NewObjectID()
+	if analysis != nil {
+		analysis.VenueId = venue.ID
+		if _, err := h.menuRepo.CreateMenu(analysis); err != nil {
+			logger.Errorf("error creating menu: %v", err)
+			http.Error(w, "Failed to create menu", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// Save the venue to the database
-	insertResult, err := h.venueRepo.CreateVenue(&venue)
-	if err != nil {
+	if _, err := h.venueRepo.CreateVenue(&venue); err != nil {
 		logger.Errorf("error creating venue: %v", err)
+		if analysis != nil {
+			if _, err := h.menuRepo.DeleteMenu(r.Context(), analysis.ID); err != nil {
+				logger.Errorf("error deleting menu of venue that failed to save: %v", err)
+			}
+		}
 		http.Error(w, "Failed to create venue", http.StatusInternalServerError)
 		return
 	}
-	venueID, ok := insertResult.InsertedID.(primitive.ObjectID)
+	audit.Describe(r.Context(), "venue.create", venueTarget(&venue))
+	audit.After(r.Context(), venue)
+
+	h.renderVenueList(w, r)
+}
+
+// DeleteVenueHandler deletes a venue along with its menu and images. Venues with open sessions
+// are kept until the sessions are closed.
+func (h *AdminHandler) DeleteVenueHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
 	if !ok {
-		logger.Errorf("error converting InsertedID to ObjectID")
-		http.Error(w, "Error creating venue", http.StatusInternalServerError)
 		return
 	}
-	menuAnalysisResult.Result.VenueId = venueID
-	_, err = h.menuRepo.CreateMenu(menuAnalysisResult.Result)
+
+	openSessions, err := h.tablesRepo.GetOpenSessionsForVenues(r.Context(), []structs.Venue{*venue})
 	if err != nil {
-		logger.Errorf("error creating menu: %v", err)
+		logger.Errorf("error fetching open sessions: %v", err)
+		http.Error(w, "Error fetching open sessions", http.StatusInternalServerError)
+		return
+	}
+	if len(openSessions) > 0 {
+		http.Error(w, "Close the open sessions of the venue before deleting it", http.StatusConflict)
 		return
 	}
 
-	venues, err := h.venueRepo.GetAllVenues(r.Context())
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+	menu, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+
+	if err := h.venueRepo.DeleteVenue(r.Context(), *venue); err != nil {
+		logger.Errorf("error deleting venue: %v", err)
+		http.Error(w, "Error deleting venue", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("venue %v deleted by %v", venue.ID.Hex(), sessionUser(session))
+	audit.Describe(r.Context(), "venue.delete", venueTarget(venue))
+	audit.Before(r.Context(), venue)
+
+	deleteImages(r.Context(), h.images, venue.Image, venue.Thumbnail)
+	// Menus other venues use are kept
+	if !menu.ID.IsZero() {
+		if inUse, err := h.venueRepo.CountVenuesWithMenu(r.Context(), menu.ID); err != nil {
+			logger.Errorf("error counting venues of menu: %v", err)
+		} else if inUse == 0 {
+			h.deleteMenu(r.Context(), menu)
+		}
+	}
+
+	h.renderVenueList(w, r)
+}
+
+func (h *AdminHandler) renderVenueList(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	venues, err := h.venueRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
 		logger.Errorf("error fetching venues: %v", err)
 		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
 		return
 	}
+	menus, err := h.tenantMenus(r.Context(), sessionTenant(session), venues)
+	if err != nil {
+		logger.Errorf("error fetching menus: %v", err)
+		http.Error(w, "Error fetching menus", http.StatusInternalServerError)
+		return
+	}
 	adminPage := structs.AdminPage{
-		Title:  "Table Codes",
-		Venues: venues,
+		Title:     "Table Codes",
+		Venues:    venues,
+		Menus:     menus,
+		Can:       auth.Permissions(sessionRoles(session)),
+		CSRFToken: csrfToken(session),
 	}
 
 	w.WriteHeader(http.StatusOK)
 	tmpl := template.Must(template.New("venue-list.html").Funcs(templateFuncs).ParseFiles("templates/venue-list.html"))
 	tmpl.Execute(w, adminPage)
 }
+
+func (h *AdminHandler) VenueMenuHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	menu, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+	venues, err := h.venueRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+
+	venueMenuPage := structs.VenueMenuPage{
+		Title:     venue.Name,
+		Venue:     *venue,
+		MenuName:  menu.Name,
+		Menu:      menu.Data,
+		CSRFToken: csrfToken(session),
+	}
+	for _, other := range venues {
+		if other.ID != venue.ID && !menu.ID.IsZero() && other.MenuID == menu.ID {
+			venueMenuPage.SharedWith = append(venueMenuPage.SharedWith, other.Name)
+		}
+	}
+	tmpl := template.Must(template.New("venue-menu.html").Funcs(templateFuncs).ParseFiles("templates/venue-menu.html", "templates/image-preview.html"))
+	err = tmpl.Execute(w, venueMenuPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *AdminHandler) VenueImageHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB limit
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		logger.Errorf("error getting file from form: %v", err)
+		http.Error(w, "Error getting file from form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	image, thumbnail, err := storeImage(r.Context(), h.images, file, "venues")
+	if err != nil {
+		logger.Errorf("error storing venue image: %v", err)
+		http.Error(w, "Error storing venue image", http.StatusBadRequest)
+		return
+	}
+
+	audit.Describe(r.Context(), "venue.image", venueTarget(venue))
+	audit.Before(r.Context(), venue)
+	oldImage, oldThumbnail := venue.Image, venue.Thumbnail
+	venue.Image, venue.Thumbnail = image, thumbnail
+	if _, err := h.venueRepo.UpdateVenue(r.Context(), venue); err != nil {
+		logger.Errorf("error updating venue: %v", err)
+		deleteImages(r.Context(), h.images, image, thumbnail)
+		http.Error(w, "Error updating venue", http.StatusInternalServerError)
+		return
+	}
+	audit.After(r.Context(), venue)
+	deleteImages(r.Context(), h.images, oldImage, oldThumbnail)
+
+	tmpl := template.Must(template.New("image-preview.html").Funcs(templateFuncs).ParseFiles("templates/image-preview.html"))
+	tmpl.Execute(w, thumbnail)
+}
+
+func (h *AdminHandler) MenuItemImageHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	categoryIndex, err := strconv.Atoi(mux.Vars(r)["category"])
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	itemIndex, err := strconv.Atoi(mux.Vars(r)["item"])
+	if err != nil {
+		http.Error(w, "Invalid item", http.StatusBadRequest)
+		return
+	}
+
+	menu, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+	item, ok := menuItem(w, menu, categoryIndex, itemIndex)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB limit
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		logger.Errorf("error getting file from form: %v", err)
+		http.Error(w, "Error getting file from form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	image, thumbnail, err := storeImage(r.Context(), h.images, file, "menu-items")
+	if err != nil {
+		logger.Errorf("error storing menu item image: %v", err)
+		http.Error(w, "Error storing menu item image", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.menuRepo.SetItemImage(r.Context(), menu.ID, categoryIndex, itemIndex, image, thumbnail); err != nil {
+		logger.Errorf("error updating menu item: %v", err)
+		deleteImages(r.Context(), h.images, image, thumbnail)
+		http.Error(w, "Error updating menu item", http.StatusInternalServerError)
+		return
+	}
+	deleteImages(r.Context(), h.images, item.Image, item.Thumbnail)
+	audit.Describe(r.Context(), "menu.item.image", venueTarget(venue)+" "+item.Name)
+	audit.Before(r.Context(), item)
+	item.Image, item.Thumbnail = image, thumbnail
+	audit.After(r.Context(), item)
+
+	tmpl := template.Must(template.New("image-preview.html").Funcs(templateFuncs).ParseFiles("templates/image-preview.html"))
+	tmpl.Execute(w, thumbnail)
+}
+
+// getVenue loads the venue referenced by the "id" route variable, writing the error response
+// itself when it can't.
+func (h *AdminHandler) getVenue(w http.ResponseWriter, r *http.Request) (*structs.Venue, bool) {
+	venueID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	venue, err := h.venueRepo.GetVenueById(r.Context(), venueID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, false
+	}
+	if session, _ := store.Get(r, "session-name"); !ownsVenue(session, venue) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return venue, true
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	// auditPageSize is how many entries the audit log page shows
+	auditPageSize = 200
+	// auditExportLimit is how many entries an export holds at most
+	auditExportLimit = 10000
+)
+
+type AuditHandler struct {
+	auditRepo *repo.AuditRepository
+}
+
+func NewAuditHandler(auditRepo *repo.AuditRepository) *AuditHandler {
+	return &AuditHandler{auditRepo: auditRepo}
+}
+
+// Audit returns the middleware keeping the audit log. State-changing requests get a context
+// handlers describe their change on, Require sets the signed in staff member as its actor.
+// Changes are recorded once the request succeeded, requests without an actor, like those of
+// guests, aren't recorded unless their handler sets one.
+func Audit(auditRepo *repo.AuditRepository) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if safeMethod(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, rec := audit.NewContext(r.Context())
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			if sw.status >= http.StatusBadRequest {
+				return
+			}
+			entry, ok := rec.Entry()
+			if !ok {
+				return
+			}
+
+			route, target := requestRoute(r)
+			entry.Route = route
+			if entry.Action == "" {
+				entry.Action = route
+			}
+			if entry.Target == "" {
+				entry.Target = target
+			}
+			entry.At = time.Now()
+			// The request may be gone by now, the entry is still written
+			if err := auditRepo.RecordEntry(context.WithoutCancel(ctx), entry); err != nil {
+				logger.Errorf("error recording audit entry %v by %v: %v", entry.Action, entry.Actor, err)
+			}
+		})
+	}
+}
+
+// requestRoute returns the method and path template of the route of a request, and its path
+// variables as a target for changes that don't name one.
+func requestRoute(r *http.Request) (string, string) {
+	route := r.Method + " " + r.URL.Path
+	if current := mux.CurrentRoute(r); current != nil {
+		if path, err := current.GetPathTemplate(); err == nil {
+			route = r.Method + " " + path
+		}
+	}
+
+	vars := mux.Vars(r)
+	var target []string
+	for name, value := range vars {
+		target = append(target, name+"="+value)
+	}
+	sort.Strings(target)
+	return route, strings.Join(target, " ")
+}
+
+// venueTarget names a venue in the audit log.
+func venueTarget(venue *structs.Venue) string {
+	return fmt.Sprintf("%s (%s)", venue.Name, venue.ID.Hex())
+}
+
+// tableTarget names a table of a venue in the audit log.
+func tableTarget(venue *structs.Venue, table *structs.TableCode) string {
+	return fmt.Sprintf("%s table %s (%s)", venue.Name, table.Name(), table.Code)
+}
+
+// codeTarget names the table of a code in the audit log, pickup orders aren't on a table.
+func codeTarget(venue *structs.Venue, code string) string {
+	if table, ok := venue.Table(code); ok {
+		return tableTarget(venue, table)
+	}
+	return fmt.Sprintf("%s order %s", venue.Name, code)
+}
+
+// statusWriter remembers the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// AuditLogHandler shows the latest entries of the audit log of the tenant matching the search.
+func (h *AuditHandler) AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := h.auditRepo.FindEntries(r.Context(), sessionTenant(session), filter, auditPageSize+1)
+	if err != nil {
+		logger.Errorf("error fetching audit log: %v", err)
+		http.Error(w, "Error fetching audit log", http.StatusInternalServerError)
+		return
+	}
+
+	auditPage := structs.AdminAuditPage{
+		Title:   "Audit log",
+		Filter:  filter,
+		Entries: entries,
+		From:    r.FormValue("from"),
+		To:      r.FormValue("to"),
+	}
+	if len(entries) > auditPageSize {
+		auditPage.Entries = entries[:auditPageSize]
+		auditPage.Limited = true
+	}
+
+	tmpl := template.Must(template.New("admin-audit.html").Funcs(templateFuncs).ParseFiles("templates/admin-audit.html"))
+	err = tmpl.Execute(w, auditPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AuditExportHandler downloads the entries of the audit log of the tenant matching the search as
+// CSV, or as JSON when the format parameter asks for it.
+func (h *AuditHandler) AuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "Format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.auditRepo.FindEntries(r.Context(), sessionTenant(session), filter, auditExportLimit)
+	if err != nil {
+		logger.Errorf("error fetching audit log: %v", err)
+		http.Error(w, "Error fetching audit log", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("audit-log-%s.%s", time.Now().Format(dateLayout), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if entries == nil {
+			entries = []structs.AuditEntry{}
+		}
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			logger.Errorf("error encoding audit log: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"at", "actor", "actor_id", "action", "target", "route", "changes"})
+	for _, entry := range entries {
+		var changes []string
+		for _, change := range entry.Changes {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", change.Field, change.Before, change.After))
+		}
+		writer.Write([]string{
+			entry.At.UTC().Format(time.RFC3339),
+			csvText(entry.Actor),
+			csvText(entry.ActorID),
+			entry.Action,
+			csvText(entry.Target),
+			entry.Route,
+			csvText(strings.Join(changes, "\n")),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logger.Errorf("error writing audit log: %v", err)
+	}
+}
+
+// csvText keeps names staff and guests typed, like usernames and venue names, from being run as
+// formulas when the export is opened in a spreadsheet by quoting values that start like one.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// parseAuditFilter reads the search of the audit log. Dates are whole days, the to date included.
+func parseAuditFilter(r *http.Request) (structs.AuditFilter, error) {
+	filter := structs.AuditFilter{
+		Actor:  strings.TrimSpace(r.FormValue("actor")),
+		Action: strings.TrimSpace(r.FormValue("action")),
+		Target: strings.TrimSpace(r.FormValue("target")),
+	}
+	if from := r.FormValue("from"); from != "" {
+		day, err := time.ParseInLocation(dateLayout, from, time.Local)
+		if err != nil {
+			return filter, errors.New("From must be a date like 2006-01-02")
+		}
+		filter.From = day
+	}
+	if to := r.FormValue("to"); to != "" {
+		day, err := time.ParseInLocation(dateLayout, to, time.Local)
+		if err != nil {
+			return filter, errors.New("To must be a date like 2006-01-02")
+		}
+		filter.To = day.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
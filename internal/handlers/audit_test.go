@@ -0,0 +1,21 @@
+package handlers
+
+import "testing"
+
+func TestCSVText(t *testing.T) {
+	tests := map[string]string{
+		"":                            "",
+		"ana":                         "ana",
+		"Cafe - Centro table 4 (abc)": "Cafe - Centro table 4 (abc)",
+		"=cmd|' /C calc'!A0 (64f1)":   "'=cmd|' /C calc'!A0 (64f1)",
+		"=HYPERLINK(\"x\")":           "'=HYPERLINK(\"x\")",
+		"+1 555":                      "'+1 555",
+		"-2+3":                        "'-2+3",
+		"@SUM(A1)":                    "'@SUM(A1)",
+	}
+	for value, want := range tests {
+		if got := csvText(value); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
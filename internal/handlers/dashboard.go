@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"strings"
+	"vortex.studio/account/internal/pubsub"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+// Changes of open sessions published to the dashboards, messages are "<change> <table code>"
+const (
+	sessionOpened  = "opened"
+	sessionUpdated = "updated"
+	sessionClosed  = "closed"
+)
+
+func sessionsTopic(tenant string) string {
+	return "sessions/" + tenant
+}
+
+// sessionEvents publishes the changes of open sessions to the live dashboards of their tenant.
+type sessionEvents struct {
+	bus    pubsub.Bus
+	venues *repo.VenueRepository
+}
+
+func newSessionEvents(bus pubsub.Bus, venues *repo.VenueRepository) *sessionEvents {
+	return &sessionEvents{bus: bus, venues: venues}
+}
+
+// publish tells the dashboards a session changed. Failures are only logged, dashboards catch up
+// with the next change or when they are reloaded.
+func (e *sessionEvents) publish(ctx context.Context, change string, session *structs.ActiveTable) {
+	if e == nil || e.bus == nil {
+		return
+	}
+	venue, err := findSessionVenue(ctx, e.venues, session)
+	if err != nil {
+		logger.Errorf("error fetching venue of table %v: %v", session.TableCode, err)
+		return
+	}
+	e.publishAt(venue, change, session.TableCode)
+}
+
+// publishAt tells the dashboards the session of a table of the venue changed.
+func (e *sessionEvents) publishAt(venue *structs.Venue, change, code string) {
+	if e == nil || e.bus == nil {
+		return
+	}
+	e.bus.Publish(sessionsTopic(venue.TenantID), change+" "+code)
+}
+
+// findSessionVenue returns the venue of a session. Pickup sessions aren't on a table of the venue
+// and keep its id instead.
+func findSessionVenue(ctx context.Context, venues *repo.VenueRepository, session *structs.ActiveTable) (*structs.Venue, error) {
+	if !session.VenueID.IsZero() {
+		return venues.GetVenueById(ctx, session.VenueID)
+	}
+	return venues.GetVenueByTableCode(ctx, session.TableCode)
+}
+
+// SessionEventsHandler streams the changes of the open sessions of the tenant to the dashboard.
+// New sessions are sent as their card, changed and closed ones as an event named after the table
+// that makes their card fetch itself again. Every change is also sent as session-changed for
+// views showing all tables, like the floor plan.
+func (h *TableHandler) SessionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	tenant := sessionTenant(session)
+
+	streamEvents(w, r, h.events.bus, sessionsTopic(tenant), func(message string) []sseEvent {
+		change, code, _ := strings.Cut(message, " ")
+		events := []sseEvent{{Name: "session-changed", Data: code}}
+		if change == sessionOpened {
+			if card := h.renderSessionCard(r.Context(), code); card != "" {
+				events = append(events, sseEvent{Name: "session-opened", Data: card})
+			}
+		} else {
+			events = append(events, sseEvent{Name: "session-" + code, Data: change})
+		}
+		if change != sessionUpdated {
+			if targets := h.renderMergeTargets(r.Context(), tenant); targets != "" {
+				events = append(events, sseEvent{Name: "merge-targets", Data: targets})
+			}
+		}
+		return events
+	})
+}
+
+// SessionCardHandler renders the dashboard card of the session of a table, or nothing once the
+// session is closed so the card goes away.
+func (h *TableHandler) SessionCardHandler(w http.ResponseWriter, r *http.Request) {
+	adminSession, _ := store.Get(r, "session-name")
+	code := mux.Vars(r)["code"]
+
+	session, err := h.tablesRepo.GetSessionForTable(code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+	venue, err := h.sessionVenue(r.Context(), session)
+	if err != nil || !ownsVenue(adminSession, venue) {
+		return
+	}
+	if table, ok := venue.Table(code); ok {
+		session.TableLabel = table.Name()
+	}
+	session.ReadyAt = session.ReadyAt.In(venue.Location())
+
+	tmpl := template.Must(template.New("session-card.html").Funcs(templateFuncs).ParseFiles("templates/session-card.html"))
+	if err := tmpl.Execute(w, sessionCard(session, r.FormValue("expanded") == "true")); err != nil {
+		logger.Errorf("error executing template: %v", err)
+	}
+}
+
+// renderSessionCard renders the card of a newly opened session for the event stream, an empty
+// string when the session is already gone.
+func (h *TableHandler) renderSessionCard(ctx context.Context, code string) string {
+	session, err := h.tablesRepo.GetSessionForTable(code)
+	if err != nil {
+		return ""
+	}
+	if venue, err := h.sessionVenue(ctx, session); err == nil {
+		if table, ok := venue.Table(code); ok {
+			session.TableLabel = table.Name()
+		}
+		session.ReadyAt = session.ReadyAt.In(venue.Location())
+	}
+
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("session-card.html").Funcs(templateFuncs).ParseFiles("templates/session-card.html"))
+	if err := tmpl.Execute(&buf, sessionCard(session, false)); err != nil {
+		logger.Errorf("error executing template: %v", err)
+		return ""
+	}
+	return buf.String()
+}
+
+// renderMergeTargets renders the tables with an open session of the tenant, which sessions can
+// be merged into.
+func (h *TableHandler) renderMergeTargets(ctx context.Context, tenant string) string {
+	venues, err := h.venuesRepo.GetVenuesForTenant(ctx, tenant)
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		return ""
+	}
+	sessions, err := h.tablesRepo.GetOpenSessionsForVenues(ctx, venues)
+	if err != nil {
+		logger.Errorf("error fetching open sessions: %v", err)
+		return ""
+	}
+
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("merge-targets.html").Funcs(templateFuncs).ParseFiles("templates/merge-targets.html"))
+	if err := tmpl.Execute(&buf, sessions); err != nil {
+		logger.Errorf("error executing template: %v", err)
+		return ""
+	}
+	// An empty list still has to replace the old one
+	if strings.TrimSpace(buf.String()) == "" {
+		return "<!-- no open tables -->"
+	}
+	return buf.String()
+}
+
+func sessionCard(session *structs.ActiveTable, expanded bool) structs.SessionCard {
+	return structs.SessionCard{ActiveTable: session, Expanded: expanded}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/pubsub"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment so proxies don't close it
+const sseKeepAlive = 30 * time.Second
+
+// sseEvent is a server-sent event. Data can't be empty, browsers drop events without data.
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// namedEvent turns a message into an event named after it, for clients that only need to know
+// something changed.
+func namedEvent(message string) []sseEvent {
+	return []sseEvent{{Name: message, Data: message}}
+}
+
+// streamEvents relays the messages of a bus topic to the client as the server-sent events
+// toEvents makes of them, until the client disconnects.
+func streamEvents(w http.ResponseWriter, r *http.Request, bus pubsub.Bus, topic string, toEvents func(message string) []sseEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	messages, unsubscribe := bus.Subscribe(topic)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			for _, event := range toEvents(message) {
+				writeEvent(w, event)
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes an event in the text/event-stream format, one data field per line of data.
+func writeEvent(w http.ResponseWriter, event sseEvent) {
+	fmt.Fprintf(w, "event: %s\n", event.Name)
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	fmt.Fprint(w, "\n")
+}
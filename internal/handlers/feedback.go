@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+const defaultFeedbackAlertRating = 2
+
+type FeedbackHandler struct {
+	feedbackRepo *repo.FeedbackRepository
+	eventsRepo   *repo.EventsRepo
+	venuesRepo   *repo.VenueRepository
+}
+
+func NewFeedbackHandler(feedbackRepo *repo.FeedbackRepository, eventsRepo *repo.EventsRepo, venuesRepo *repo.VenueRepository) *FeedbackHandler {
+	return &FeedbackHandler{
+		feedbackRepo: feedbackRepo,
+		eventsRepo:   eventsRepo,
+		venuesRepo:   venuesRepo,
+	}
+}
+
+// FeedbackHandler shows the feedback form of a closed session, reached through its receipt token.
+func (h *FeedbackHandler) FeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	event, err := h.eventsRepo.GetEventByReceiptToken(r.Context(), token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching event: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		h.feedbackHandlerPOST(w, r, event)
+		return
+	}
+
+	_, err = h.feedbackRepo.GetFeedbackByEventID(r.Context(), event.ID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching feedback: %v", err)
+		http.Error(w, "Error fetching feedback", http.StatusInternalServerError)
+		return
+	}
+
+	feedbackPage := structs.FeedbackPage{
+		Title:     "How was your visit?",
+		Token:     token,
+		Dishes:    orderedDishes(event.Order.OrderHistory),
+		Submitted: err == nil,
+	}
+	if !event.VenueID.IsZero() {
+		if venue, err := h.venuesRepo.GetVenueById(r.Context(), event.VenueID); err == nil {
+			feedbackPage.VenueName = venue.Name
+		}
+	}
+
+	tmpl := template.Must(template.New("feedback.html").Funcs(templateFuncs).ParseFiles("templates/feedback.html"))
+	err = tmpl.Execute(w, feedbackPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *FeedbackHandler) feedbackHandlerPOST(w http.ResponseWriter, r *http.Request, event *structs.Event) {
+	_, err := h.feedbackRepo.GetFeedbackByEventID(r.Context(), event.ID)
+	if err == nil {
+		http.Error(w, "Feedback was already submitted", http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching feedback: %v", err)
+		http.Error(w, "Error fetching feedback", http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	rating, err := parseRating(r.FormValue("rating"))
+	if err != nil {
+		http.Error(w, "Rating must be between 1 and 5", http.StatusBadRequest)
+		return
+	}
+
+	feedback := structs.Feedback{
+		VenueID:   event.VenueID,
+		EventID:   event.ID,
+		TableCode: event.Order.TableCode,
+		ClientID:  event.Order.ClientID,
+		Rating:    rating,
+		Comment:   strings.TrimSpace(r.FormValue("comment")),
+		CreatedAt: time.Now(),
+	}
+
+	// Dish ratings are optional, only dishes that were actually ordered are accepted
+	for i, dish := range orderedDishes(event.Order.OrderHistory) {
+		dishRating, err := parseRating(r.FormValue(fmt.Sprintf("dish-%d", i)))
+		if err != nil {
+			continue
+		}
+		feedback.Dishes = append(feedback.Dishes, structs.DishRating{Name: dish, Rating: dishRating})
+	}
+
+	if _, err := h.feedbackRepo.CreateFeedback(r.Context(), &feedback); err != nil {
+		logger.Errorf("error creating feedback: %v", err)
+		http.Error(w, "Error saving feedback", http.StatusInternalServerError)
+		return
+	}
+
+	if feedback.Rating <= feedbackAlertRating() {
+		logger.Infof("low score alert: venue %v table %v rated %v", feedback.VenueID.Hex(), feedback.TableCode, feedback.Rating)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/feedback/%s", event.ReceiptToken), http.StatusSeeOther)
+}
+
+// AdminFeedbackHandler shows the average ratings per venue and dish and the latest low scores.
+func (h *FeedbackHandler) AdminFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venues, err := h.venuesRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+
+	venueRatings, err := h.feedbackRepo.GetVenueRatings(r.Context())
+	if err != nil {
+		logger.Errorf("error fetching venue ratings: %v", err)
+		http.Error(w, "Error fetching ratings", http.StatusInternalServerError)
+		return
+	}
+
+	threshold := feedbackAlertRating()
+	lowScores, err := h.feedbackRepo.GetLowScores(r.Context(), threshold, 20)
+	if err != nil {
+		logger.Errorf("error fetching low scores: %v", err)
+		http.Error(w, "Error fetching ratings", http.StatusInternalServerError)
+		return
+	}
+
+	feedbackPage := structs.AdminFeedbackPage{
+		Title:     "Feedback",
+		Threshold: threshold,
+	}
+	venueNames := map[string]string{}
+	for _, venue := range venues {
+		venueNames[venue.ID.Hex()] = venue.Name
+
+		dishes, err := h.feedbackRepo.GetDishRatings(r.Context(), venue.ID)
+		if err != nil {
+			logger.Errorf("error fetching dish ratings: %v", err)
+			http.Error(w, "Error fetching ratings", http.StatusInternalServerError)
+			return
+		}
+		rating := venueRatings[venue.ID]
+		rating.Name = venue.Name
+		feedbackPage.Venues = append(feedbackPage.Venues, structs.VenueRatings{
+			Venue:  venue,
+			Rating: rating,
+			Dishes: dishes,
+		})
+	}
+	for _, feedback := range lowScores {
+		feedbackPage.LowScores = append(feedbackPage.LowScores, structs.LowScore{
+			Feedback:  feedback,
+			VenueName: venueNames[feedback.VenueID.Hex()],
+		})
+	}
+
+	tmpl := template.Must(template.New("admin-feedback.html").Funcs(templateFuncs).ParseFiles("templates/admin-feedback.html"))
+	err = tmpl.Execute(w, feedbackPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// orderedDishes returns the distinct names of the ordered items in order of appearance.
+func orderedDishes(items []structs.OrderItem) []string {
+	seen := map[string]bool{}
+	var dishes []string
+	for _, item := range items {
+		if item.MenuItem == nil || seen[item.Name] {
+			continue
+		}
+		seen[item.Name] = true
+		dishes = append(dishes, item.Name)
+	}
+	return dishes
+}
+
+func parseRating(value string) (int, error) {
+	rating, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if rating < 1 || rating > 5 {
+		return 0, fmt.Errorf("rating out of range: %d", rating)
+	}
+	return rating, nil
+}
+
+// feedbackAlertRating is the rating at or below which feedback is flagged, configured through
+// FEEDBACK_ALERT_RATING.
+func feedbackAlertRating() int {
+	if threshold, err := strconv.Atoi(os.Getenv("FEEDBACK_ALERT_RATING")); err == nil {
+		return threshold
+	}
+	return defaultFeedbackAlertRating
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	defaultTableSeats = 4
+	maxTableSeats     = 50
+)
+
+// FloorHandler shows the floor plan of a venue with every table colored by the state of its
+// session. Staff who can manage venues can switch to edit mode and arrange the tables.
+func (h *AdminHandler) FloorHandler(w http.ResponseWriter, r *http.Request) {
+	h.renderFloor(w, r, "floor.html", "templates/floor.html", "templates/floor-grid.html")
+}
+
+// FloorGridHandler renders only the grid of the floor plan, the floor view polls it to stay
+// current.
+func (h *AdminHandler) FloorGridHandler(w http.ResponseWriter, r *http.Request) {
+	h.renderFloor(w, r, "floor-grid.html", "templates/floor-grid.html")
+}
+
+func (h *AdminHandler) renderFloor(w http.ResponseWriter, r *http.Request, name string, files ...string) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venues, err := h.venueRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+	if len(venues) == 0 {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	venue := venues[0]
+	for _, v := range venues {
+		if v.ID.Hex() == r.FormValue("venue") {
+			venue = v
+		}
+	}
+
+	floorPage, err := h.floorPage(r.Context(), session, &venue)
+	if err != nil {
+		logger.Errorf("error fetching open sessions: %v", err)
+		http.Error(w, "Error fetching open sessions", http.StatusInternalServerError)
+		return
+	}
+	floorPage.Venues = venues
+	floorPage.Edit = r.FormValue("edit") != "" && floorPage.Can[string(auth.ManageVenues)]
+
+	tmpl := template.Must(template.New(name).Funcs(templateFuncs).ParseFiles(files...))
+	err = tmpl.Execute(w, floorPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// floorPage lays the active tables of a venue out on its grid. Tables that haven't been placed,
+// or whose cell is taken by another table, are listed as unplaced.
+func (h *AdminHandler) floorPage(ctx context.Context, session *sessions.Session, venue *structs.Venue) (*structs.FloorPage, error) {
+	openSessions, err := h.tablesRepo.GetOpenSessionsForVenues(ctx, []structs.Venue{*venue})
+	if err != nil {
+		return nil, err
+	}
+	occupied := map[string]*structs.ActiveTable{}
+	for _, openSession := range openSessions {
+		occupied[openSession.TableCode] = openSession
+	}
+
+	columns, rows := venue.Floor.Size()
+	floorPage := &structs.FloorPage{
+		Title:     "Floor plan",
+		Venue:     *venue,
+		Columns:   columns,
+		Rows:      rows,
+		Shapes:    structs.TableShapes,
+		Can:       auth.Permissions(sessionRoles(session)),
+		CSRFToken: csrfToken(session),
+	}
+	floorPage.Grid = make([][]structs.FloorCell, rows)
+	for y := range floorPage.Grid {
+		floorPage.Grid[y] = make([]structs.FloorCell, columns)
+		for x := range floorPage.Grid[y] {
+			floorPage.Grid[y][x] = structs.FloorCell{X: x, Y: y}
+		}
+	}
+
+	for _, table := range venue.ActiveTables() {
+		floorTable := &structs.FloorTable{TableCode: table, State: structs.TableFree}
+		if openSession, ok := occupied[table.Code]; ok {
+			floorTable.State = openSession.State()
+			floorTable.Session = openSession
+		}
+		layout := table.Layout
+		if layout == nil || layout.X < 0 || layout.X >= columns || layout.Y < 0 || layout.Y >= rows || floorPage.Grid[layout.Y][layout.X].Table != nil {
+			floorPage.Unplaced = append(floorPage.Unplaced, *floorTable)
+			continue
+		}
+		floorPage.Grid[layout.Y][layout.X].Table = floorTable
+	}
+	return floorPage, nil
+}
+
+// FloorSizeHandler changes the number of columns and rows of the floor plan of a venue.
+func (h *AdminHandler) FloorSizeHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	columns, err := strconv.Atoi(r.FormValue("columns"))
+	if err != nil || columns < 1 || columns > structs.MaxFloorSize {
+		http.Error(w, fmt.Sprintf("Columns must be between 1 and %v", structs.MaxFloorSize), http.StatusBadRequest)
+		return
+	}
+	rows, err := strconv.Atoi(r.FormValue("rows"))
+	if err != nil || rows < 1 || rows > structs.MaxFloorSize {
+		http.Error(w, fmt.Sprintf("Rows must be between 1 and %v", structs.MaxFloorSize), http.StatusBadRequest)
+		return
+	}
+	for _, table := range venue.ActiveTables() {
+		if table.Layout != nil && (table.Layout.X >= columns || table.Layout.Y >= rows) {
+			http.Error(w, fmt.Sprintf("Table %v would be off the floor plan, move it first", table.Name()), http.StatusConflict)
+			return
+		}
+	}
+
+	floor := structs.FloorPlan{Columns: columns, Rows: rows}
+	if _, err := h.venueRepo.SetFloorPlan(r.Context(), venue.ID, floor); err != nil {
+		logger.Errorf("error saving floor plan: %v", err)
+		http.Error(w, "Error saving floor plan", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "floor.resize", venueTarget(venue))
+	audit.Before(r.Context(), venue.Floor)
+	audit.After(r.Context(), floor)
+	http.Redirect(w, r, floorEditURL(venue), http.StatusSeeOther)
+}
+
+// TableLayoutHandler places a table on the floor plan. Dragging a table sends its new cell as
+// x and y, the table form its shape, seats and section; whatever isn't sent stays as it was.
+// Tables placed without a cell go to the first free one.
+func (h *AdminHandler) TableLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, table, ok := h.getTable(w, r)
+	if !ok {
+		return
+	}
+	if table.Retired {
+		http.Error(w, "Retired tables can't be placed on the floor plan", http.StatusBadRequest)
+		return
+	}
+
+	layout := structs.TableLayout{X: -1, Y: -1, Shape: structs.ShapeSquare, Seats: defaultTableSeats}
+	if table.Layout != nil {
+		layout = *table.Layout
+	}
+	section := table.Section
+
+	if r.FormValue("x") != "" || r.FormValue("y") != "" {
+		x, errX := strconv.Atoi(r.FormValue("x"))
+		y, errY := strconv.Atoi(r.FormValue("y"))
+		if errX != nil || errY != nil {
+			http.Error(w, "Position must be a column and a row", http.StatusBadRequest)
+			return
+		}
+		layout.X, layout.Y = x, y
+	}
+	if shape := r.FormValue("shape"); shape != "" {
+		if !validShape(shape) {
+			http.Error(w, "Unknown table shape", http.StatusBadRequest)
+			return
+		}
+		layout.Shape = shape
+	}
+	if r.FormValue("seats") != "" {
+		seats, err := strconv.Atoi(r.FormValue("seats"))
+		if err != nil || seats < 1 || seats > maxTableSeats {
+			http.Error(w, fmt.Sprintf("Seats must be between 1 and %v", maxTableSeats), http.StatusBadRequest)
+			return
+		}
+		layout.Seats = seats
+	}
+	if _, ok := r.Form["section"]; ok {
+		section = strings.TrimSpace(r.FormValue("section"))
+	}
+
+	columns, rows := venue.Floor.Size()
+	if layout.X < 0 || layout.Y < 0 {
+		var free bool
+		layout.X, layout.Y, free = freeCell(venue, table.Code, columns, rows)
+		if !free {
+			http.Error(w, "The floor plan is full, make it bigger first", http.StatusConflict)
+			return
+		}
+	}
+	if layout.X >= columns || layout.Y >= rows {
+		http.Error(w, "The position is off the floor plan", http.StatusBadRequest)
+		return
+	}
+	if other, taken := tableAt(venue, table.Code, layout.X, layout.Y); taken {
+		http.Error(w, fmt.Sprintf("Table %v is already there", other.Name()), http.StatusConflict)
+		return
+	}
+
+	if _, err := h.venueRepo.SetTableLayout(r.Context(), venue.ID, table.Code, layout, section); err != nil {
+		logger.Errorf("error saving table layout: %v", err)
+		http.Error(w, "Error saving table layout", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "table.layout", tableTarget(venue, table))
+	audit.Before(r.Context(), table)
+	table.Layout, table.Section = &layout, section
+	audit.After(r.Context(), table)
+	http.Redirect(w, r, floorEditURL(venue), http.StatusSeeOther)
+}
+
+// RemoveTableLayoutHandler takes a table off the floor plan, it stays in use.
+func (h *AdminHandler) RemoveTableLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, table, ok := h.getTable(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.venueRepo.RemoveTableLayout(r.Context(), venue.ID, table.Code); err != nil {
+		logger.Errorf("error removing table layout: %v", err)
+		http.Error(w, "Error removing table layout", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "table.layout.remove", tableTarget(venue, table))
+	audit.Before(r.Context(), table)
+	table.Layout = nil
+	audit.After(r.Context(), table)
+	http.Redirect(w, r, floorEditURL(venue), http.StatusSeeOther)
+}
+
+// ServedHandler records that the food ordered by a table was brought to it and renders the floor
+// grid again.
+func (h *AdminHandler) ServedHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+	venue, err := h.venueRepo.GetVenueByTableCode(r.Context(), code)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && !ownsVenue(session, venue)) {
+		http.Error(w, "Table not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := h.tablesRepo.SetServed(r.Context(), code, time.Now())
+	if err != nil {
+		logger.Errorf("error marking table %v served: %v", code, err)
+		http.Error(w, "Error updating session", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return
+	}
+	h.events.publishAt(venue, sessionUpdated, code)
+	audit.Describe(r.Context(), "session.served", codeTarget(venue, code))
+
+	floorPage, err := h.floorPage(r.Context(), session, venue)
+	if err != nil {
+		logger.Errorf("error fetching open sessions: %v", err)
+		http.Error(w, "Error fetching open sessions", http.StatusInternalServerError)
+		return
+	}
+	tmpl := template.Must(template.New("floor-grid.html").Funcs(templateFuncs).ParseFiles("templates/floor-grid.html"))
+	tmpl.Execute(w, floorPage)
+}
+
+func validShape(shape string) bool {
+	for _, s := range structs.TableShapes {
+		if s == shape {
+			return true
+		}
+	}
+	return false
+}
+
+// tableAt returns the active table other than code placed on a cell of the floor plan.
+func tableAt(venue *structs.Venue, code string, x, y int) (*structs.TableCode, bool) {
+	for _, table := range venue.ActiveTables() {
+		if table.Code != code && table.Layout != nil && table.Layout.X == x && table.Layout.Y == y {
+			return &table, true
+		}
+	}
+	return nil, false
+}
+
+// freeCell returns the first cell of the floor plan, row by row, without a table on it.
+func freeCell(venue *structs.Venue, code string, columns, rows int) (int, int, bool) {
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			if _, taken := tableAt(venue, code, x, y); !taken {
+				return x, y, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func floorEditURL(venue *structs.Venue) string {
+	return "/admin/floor?" + url.Values{"venue": {venue.ID.Hex()}, "edit": {"1"}}.Encode()
+}
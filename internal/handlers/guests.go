@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/mailer"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/utils"
+)
+
+const linkTokenTTL = 30 * time.Minute
+
+type GuestHandler struct {
+	guestsRepo  *repo.GuestProfilesRepository
+	venuesRepo  *repo.VenueRepository
+	loyaltyRepo *repo.LoyaltyRepository
+	mailer      mailer.Sender
+}
+
+func NewGuestHandler(guestsRepo *repo.GuestProfilesRepository, venuesRepo *repo.VenueRepository, loyaltyRepo *repo.LoyaltyRepository, mailer mailer.Sender) *GuestHandler {
+	return &GuestHandler{
+		guestsRepo:  guestsRepo,
+		venuesRepo:  venuesRepo,
+		loyaltyRepo: loyaltyRepo,
+		mailer:      mailer,
+	}
+}
+
+// ProfileHandler shows the guest their past visits across the venues of the tenant the venue
+// belongs to, with links to their receipts.
+func (h *GuestHandler) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	clientID := getOrCreateClientID(w, r)
+	profile, err := h.guestsRepo.GetOrCreateProfile(r.Context(), venue.TenantID, clientID)
+	if err != nil {
+		logger.Errorf("error fetching guest profile: %v", err)
+		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		return
+	}
+
+	// Most recent visits first
+	visits := append([]structs.Visit{}, profile.Visits...)
+	sort.Slice(visits, func(i, j int) bool {
+		return visits[i].ClosedAt.After(visits[j].ClosedAt)
+	})
+
+	guestPage := structs.GuestPage{
+		Title:    "My visits",
+		Venue:    *venue,
+		Profile:  profile,
+		Visits:   visits,
+		CanEmail: h.mailer != nil,
+		Message:  r.URL.Query().Get("message"),
+	}
+	tmpl := template.Must(template.New("guest.html").Funcs(templateFuncs).ParseFiles("templates/guest.html"))
+	err = tmpl.Execute(w, guestPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpgradeHandler turns the anonymous profile of the guest into an account with a name and email.
+// If the email already belongs to another account a sign-in link is sent instead.
+func (h *GuestHandler) UpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	email, ok := parseEmail(r.FormValue("email"))
+	if !ok {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	clientID := getOrCreateClientID(w, r)
+	profile, err := h.guestsRepo.GetOrCreateProfile(r.Context(), venue.TenantID, clientID)
+	if err != nil {
+		logger.Errorf("error fetching guest profile: %v", err)
+		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		return
+	}
+
+	existing, err := h.guestsRepo.GetProfileByEmail(r.Context(), venue.TenantID, email)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching guest profile: %v", err)
+		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && existing.ID != profile.ID {
+		h.sendSignInLink(w, r, venue, existing)
+		return
+	}
+
+	if _, err := h.guestsRepo.UpdateAccount(r.Context(), profile.ID, name, email); err != nil {
+		logger.Errorf("error updating guest profile: %v", err)
+		http.Error(w, "Error updating profile", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/guest/%s?message=%s", venue.ID.Hex(), "Your account was saved"), http.StatusSeeOther)
+}
+
+// SignInHandler emails a link that attaches the current device to an existing account.
+func (h *GuestHandler) SignInHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	email, ok := parseEmail(r.FormValue("email"))
+	if !ok {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := h.guestsRepo.GetProfileByEmail(r.Context(), venue.TenantID, email)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching guest profile: %v", err)
+		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		// Don't reveal which emails have an account
+		http.Redirect(w, r, fmt.Sprintf("/guest/%s?message=%s", venue.ID.Hex(), "Check your email for a sign-in link"), http.StatusSeeOther)
+		return
+	}
+
+	h.sendSignInLink(w, r, venue, profile)
+}
+
+// LinkHandler attaches the current device to the account of a sign-in link. Opening the link
+// only shows a confirmation, the client_id cookie is strict and isn't sent when arriving from
+// an email, so the device is linked by the same-site POST that follows.
+func (h *GuestHandler) LinkHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	profile, err := h.guestsRepo.GetProfileByLinkToken(r.Context(), token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "This link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching guest profile: %v", err)
+		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		linkPage := structs.GuestLinkPage{
+			Title:   "Sign in",
+			Token:   token,
+			Email:   profile.Email,
+			VenueID: r.URL.Query().Get("venue"),
+		}
+		tmpl := template.Must(template.New("guest-link.html").Funcs(templateFuncs).ParseFiles("templates/guest-link.html"))
+		tmpl.Execute(w, linkPage)
+		return
+	}
+
+	clientID := getOrCreateClientID(w, r)
+	if err := h.guestsRepo.LinkClient(r.Context(), profile, clientID); err != nil {
+		logger.Errorf("error linking guest device: %v", err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+
+	venueID := r.FormValue("venue")
+	if _, err := primitive.ObjectIDFromHex(venueID); err != nil {
+		venueID = ""
+		if len(profile.Visits) > 0 {
+			venueID = profile.Visits[len(profile.Visits)-1].VenueID.Hex()
+		}
+	}
+	if venueID == "" {
+		fmt.Fprint(w, "You are signed in, scan a table code to continue.")
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/guest/%s", venueID), http.StatusSeeOther)
+}
+
+func (h *GuestHandler) sendSignInLink(w http.ResponseWriter, r *http.Request, venue *structs.Venue, profile *structs.GuestProfile) {
+	if h.mailer == nil {
+		http.Error(w, "Signing in by email is not available", http.StatusNotImplemented)
+		return
+	}
+
+	token := uuid.New().String()
+	if _, err := h.guestsRepo.SetLinkToken(r.Context(), profile.ID, token, time.Now().Add(linkTokenTTL)); err != nil {
+		logger.Errorf("error saving link token: %v", err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+
+	link := fmt.Sprintf("%s/guest/link/%s?venue=%s", utils.PublicBaseURL(), token, venue.ID.Hex())
+	body := fmt.Sprintf(`<p>Open this link to see your visits to %s on this device:</p><p><a href="%s">%s</a></p><p>The link expires in 30 minutes.</p>`,
+		template.HTMLEscapeString(venue.Name), link, link)
+	if err := h.mailer.Send(profile.Email, "Your sign-in link", body); err != nil {
+		logger.Errorf("error sending sign-in link: %v", err)
+		http.Error(w, "Error sending email", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/guest/%s?message=%s", venue.ID.Hex(), "Check your email for a sign-in link"), http.StatusSeeOther)
+}
+
+// PointsHandler shows the guest their points balance and ledger history.
+func (h *GuestHandler) PointsHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	pointsPage := structs.PointsPage{
+		Title: "My points",
+		Venue: *venue,
+	}
+
+	profile, err := h.guestsRepo.GetProfileByClientID(r.Context(), venue.TenantID, getClientID(r))
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching guest profile: %v", err)
+		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		return
+	}
+	if profile != nil {
+		pointsPage.Balance, err = h.loyaltyRepo.GetBalance(r.Context(), profile.ID)
+		if err != nil {
+			logger.Errorf("error fetching points balance: %v", err)
+			http.Error(w, "Error fetching points", http.StatusInternalServerError)
+			return
+		}
+		pointsPage.Entries, err = h.loyaltyRepo.GetLedgerEntries(r.Context(), profile.ID)
+		if err != nil {
+			logger.Errorf("error fetching points history: %v", err)
+			http.Error(w, "Error fetching points", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if program, err := h.loyaltyRepo.GetProgram(r.Context(), venue.TenantID); err == nil && program.Enabled {
+		pointsPage.Rewards = program.Rewards
+	}
+
+	tmpl := template.Must(template.New("points.html").Funcs(templateFuncs).ParseFiles("templates/points.html"))
+	err = tmpl.Execute(w, pointsPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *GuestHandler) getVenue(w http.ResponseWriter, r *http.Request) (*structs.Venue, bool) {
+	venueID, err := primitive.ObjectIDFromHex(mux.Vars(r)["venue"])
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), venueID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, false
+	}
+	return venue, true
+}
+
+func parseEmail(value string) (string, bool) {
+	address, err := mail.ParseAddress(strings.TrimSpace(value))
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(address.Address), true
+}
+
+// orderAgainSuggestions returns the items of the menu the guest ordered most in past visits.
+func orderAgainSuggestions(profile *structs.GuestProfile, menu *structs.MenuData, limit int) []structs.MenuItem {
+	ordered := map[string]int{}
+	for _, visit := range profile.Visits {
+		for _, item := range visit.Items {
+			if item.MenuItem != nil {
+				ordered[item.Name] += item.Amount
+			}
+		}
+	}
+	if len(ordered) == 0 {
+		return nil
+	}
+
+	var suggestions []structs.MenuItem
+	seen := map[string]bool{}
+	for _, category := range menu.Categories {
+		for _, item := range category.Items {
+			if ordered[item.Name] > 0 && !seen[item.Name] {
+				seen[item.Name] = true
+				suggestions = append(suggestions, item)
+			}
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return ordered[suggestions[i].Name] > ordered[suggestions[j].Name]
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
@@ -2,20 +2,24 @@ package handlers
 
 import (
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
-	"github.com/lithammer/shortuuid/v4"
+	"github.com/vorticist/logger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"html/template"
-	"os"
+	"net/http"
 	"regexp"
 	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/qr"
 	"vortex.studio/account/internal/repo"
 	"vortex.studio/account/internal/structs"
-	"vortex.studio/account/internal/utils"
 )
 
 var (
-	store = sessions.NewCookieStore([]byte("super-secret-key"))
+	store = newSessionStore()
 )
 
 var templateFuncs = template.FuncMap{
@@ -23,6 +27,12 @@ var templateFuncs = template.FuncMap{
 	"getItemVals":       getItemVals,
 	"getCloseOrderVals": getCloseOrderVals,
 	"getOrderTotal":     getOrderTotal,
+	"imageURL":          imageURL,
+	"ratingScale":       ratingScale,
+	"float":             toFloat,
+	"minutes":           minutes,
+	"qrRecoveries":      qrRecoveries,
+	"sessionCard":       sessionCard,
 }
 
 type Handler struct {
@@ -51,34 +61,42 @@ func makeURLSafe(name string) string {
 	return name
 }
 
-func generateTableCodes(venue *structs.Venue, howMany int) error {
-	venue.TableCodes = []structs.TableCode{}
-	for i := 0; i < howMany; i++ {
-		u := shortuuid.New()
+// generateTableCodes gives a new venue howMany tables numbered from 1.
+func generateTableCodes(venue *structs.Venue, howMany int) {
+	venue.TableCodes = newTables(venue, howMany, "")
+}
 
-		hostname := "https://the-account.vortex.studio"
-		env := os.Getenv("ENVIRONMENT")
-		if env == "local" {
-			hostname = "http://localhost:9090"
-		}
+// getClientID returns the guest identifier of the client_id cookie, or an empty string.
+func getClientID(r *http.Request) string {
+	cookie, err := r.Cookie("client_id")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
 
-		// TODO: generate url based on environment
-		tableCodeUrl := fmt.Sprintf("%v/table/%s", hostname, u)
-		qrCode, err := utils.GenerateQRCodeBase64(tableCodeUrl)
-		if err != nil {
-			return err
-		}
-		venue.TableCodes = append(venue.TableCodes, structs.TableCode{
-			Code:    u,
-			CodeUrl: tableCodeUrl,
-			Base64:  qrCode,
-		})
+// getOrCreateClientID returns the guest identifier of the client_id cookie, issuing a new
+// 30 day cookie when the client doesn't have one yet.
+func getOrCreateClientID(w http.ResponseWriter, r *http.Request) string {
+	if clientID := getClientID(r); clientID != "" {
+		return clientID
 	}
-	return nil
+
+	clientID := uuid.New().String()
+	http.SetCookie(w, &http.Cookie{
+		Name:     "client_id",
+		Value:    clientID,
+		Path:     "/",
+		MaxAge:   86400 * 30, // 30 days
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return clientID
 }
 
 func getItemVals(item structs.MenuItem) string {
-	return fmt.Sprintf(`{"name": "%s", "description": "%s", "price": %v, "amount": 1}`, item.Name, item.Description, item.Price)
+	return fmt.Sprintf(`{"name": "%s", "amount": 1}`, item.Name)
 }
 
 func getCloseOrderVals(order []structs.OrderItem) string {
@@ -100,6 +118,78 @@ func getOrderTotal(order []structs.OrderItem) float64 {
 	return total
 }
 
+func ratingScale() []int {
+	return []int{1, 2, 3, 4, 5}
+}
+
+func qrRecoveries() []string {
+	return qr.Recoveries
+}
+
+func toFloat(i int) float64 {
+	return float64(i)
+}
+
+// minutes rounds a duration up to whole minutes for display.
+func minutes(d time.Duration) int {
+	return int((d + time.Minute - 1) / time.Minute)
+}
+
 func getStringID(id primitive.ObjectID) string {
 	return id.Hex()
 }
+
+// sessionTenant returns the tenant the admin of a session belongs to.
+func sessionTenant(session *sessions.Session) string {
+	tenant, _ := session.Values["tenant"].(string)
+	return tenant
+}
+
+// ownsVenue reports whether the venue belongs to the tenant the admin of a session signed in to.
+func ownsVenue(session *sessions.Session, venue *structs.Venue) bool {
+	return venue.TenantID == sessionTenant(session)
+}
+
+// sessionUser returns the username of the admin of a session.
+func sessionUser(session *sessions.Session) string {
+	user, _ := session.Values["user"].(string)
+	return user
+}
+
+// sessionUserID returns the Keycloak user id of the admin of a session.
+func sessionUserID(session *sessions.Session) string {
+	userID, _ := session.Values["user_id"].(string)
+	return userID
+}
+
+// sessionRoles returns the staff roles of the admin of a session.
+func sessionRoles(session *sessions.Session) []string {
+	roles, _ := session.Values["roles"].([]string)
+	return roles
+}
+
+// Require only lets requests through to next when the signed in staff member has a role that
+// grants the permission, state-changing requests also need the CSRF token of the session. Roles
+// are rechecked with the realm every sessionRecheckInterval. The
+// staff member is the actor of the changes the request makes in the audit log.
+func Require(permission auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "session-name")
+		authenticated, ok := session.Values["authenticated"].(bool)
+		if !ok || !authenticated {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !recheckSession(w, r, session) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !auth.Allowed(sessionRoles(session), permission) {
+			logger.Infof("%v is not allowed to %v on %v", sessionUser(session), permission, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		audit.Actor(r.Context(), sessionTenant(session), sessionUserID(session), sessionUser(session))
+		CheckCSRF(next)(w, r)
+	}
+}
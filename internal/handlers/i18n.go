@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const languageCookie = "lang"
+
+// resolveLanguage picks the language a menu should be displayed in. An explicit "lang" query
+// parameter wins and is remembered in a cookie, then the cookie itself, then the Accept-Language
+// header. When nothing matches the available languages the fallback (the original menu language)
+// is used.
+func resolveLanguage(w http.ResponseWriter, r *http.Request, available []string, fallback string) string {
+	if lang := matchLanguage(r.URL.Query().Get("lang"), available); lang != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     languageCookie,
+			Value:    lang,
+			Path:     "/",
+			MaxAge:   86400 * 365,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return lang
+	}
+
+	if cookie, err := r.Cookie(languageCookie); err == nil {
+		if lang := matchLanguage(cookie.Value, available); lang != "" {
+			return lang
+		}
+	}
+
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if lang := matchLanguage(tag, available); lang != "" {
+			return lang
+		}
+	}
+
+	return fallback
+}
+
+// matchLanguage returns the available language matching the given tag, comparing the full tag
+// first and then only its primary subtag, so "es-MX" matches "es".
+func matchLanguage(tag string, available []string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return ""
+	}
+
+	for _, lang := range available {
+		if strings.ToLower(lang) == tag {
+			return lang
+		}
+	}
+
+	base, _, _ := strings.Cut(tag, "-")
+	for _, lang := range available {
+		langBase, _, _ := strings.Cut(strings.ToLower(lang), "-")
+		if langBase == base {
+			return lang
+		}
+	}
+
+	return ""
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language header ordered by quality.
+func parseAcceptLanguage(header string) []string {
+	type weightedTag struct {
+		tag     string
+		quality float64
+	}
+
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		tags = append(tags, weightedTag{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].quality > tags[j].quality
+	})
+
+	result := make([]string, 0, len(tags))
+	for _, t := range tags {
+		result = append(result, t.tag)
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"vortex.studio/account/internal/blobstore"
+	"vortex.studio/account/internal/images"
+)
+
+type ImagesHandler struct {
+	store blobstore.Store
+}
+
+func NewImagesHandler(store blobstore.Store) *ImagesHandler {
+	return &ImagesHandler{store: store}
+}
+
+// ImageHandler serves stored images. Image keys are never reused so responses can be cached forever.
+func (h *ImagesHandler) ImageHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	blob, err := h.store.Get(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching image %v: %v", key, err)
+		http.Error(w, "Error fetching image", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, blob)
+}
+
+// storeImage resizes an uploaded image and saves the full size and thumbnail versions under
+// the given prefix, returning both keys.
+func storeImage(ctx context.Context, store blobstore.Store, file multipart.File, prefix string) (string, string, error) {
+	resized, err := images.Process(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := uuid.New().String()
+	image := fmt.Sprintf("%s/%s.jpg", prefix, id)
+	thumbnail := fmt.Sprintf("%s/%s-thumb.jpg", prefix, id)
+
+	if err := store.Put(ctx, image, bytes.NewReader(resized.Full)); err != nil {
+		return "", "", err
+	}
+	if err := store.Put(ctx, thumbnail, bytes.NewReader(resized.Thumbnail)); err != nil {
+		store.Delete(ctx, image)
+		return "", "", err
+	}
+
+	return image, thumbnail, nil
+}
+
+// deleteImages removes replaced images, failures only leave orphaned blobs behind so they are just logged.
+func deleteImages(ctx context.Context, store blobstore.Store, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			logger.Errorf("error deleting image %v: %v", key, err)
+		}
+	}
+}
+
+// readImage returns a stored image, or nil when it can't be read since it only decorates what it
+// is used in.
+func readImage(ctx context.Context, store blobstore.Store, key string) []byte {
+	blob, err := store.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, blobstore.ErrNotFound) {
+			logger.Errorf("error fetching image %v: %v", key, err)
+		}
+		return nil
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		logger.Errorf("error reading image %v: %v", key, err)
+		return nil
+	}
+	return data
+}
+
+func imageURL(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "/images/" + key
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+// RedeemHandler applies a reward of the guest's points balance to the bill of their table, or
+// removes it when no reward is given.
+func (h *TableHandler) RedeemHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	logger.Infof("got code: %v", code)
+
+	session, err := h.tablesRepo.GetSessionForTable(code)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+
+	if session == nil {
+		logger.Errorf("no active session found for code: %v", code)
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return
+	}
+
+	if !session.HasClient(getClientID(r)) {
+		tmpl := template.Must(template.New("occupied.html").Funcs(templateFuncs).ParseFiles("templates/occupied.html"))
+		tmpl.Execute(w, nil)
+		return
+	}
+
+	venue, err := h.sessionVenue(r.Context(), session)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+
+	session.Redemption = nil
+	if rewardID := r.FormValue("reward"); rewardID != "" {
+		session.Redemption = &structs.Redemption{RewardID: rewardID}
+		session.Redemption = h.checkRedemption(r.Context(), venue, session)
+		if session.Redemption == nil {
+			http.Error(w, "This reward can't be applied", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// $set doesn't clear a nil redemption so it is removed explicitly
+	var result *mongo.UpdateResult
+	if session.Redemption == nil {
+		result, err = h.tablesRepo.ClearRedemption(r.Context(), session.ID)
+	} else {
+		result, err = h.tablesRepo.SetRedemption(r.Context(), session.ID, session.Redemption)
+	}
+	if err != nil {
+		logger.Errorf("error updating session: %v", err)
+		http.Error(w, "Error updating session", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, sessionGoneMessage, http.StatusConflict)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/history/%s", code), http.StatusSeeOther)
+}
+
+// checkRedemption validates the reward chosen for a session against the current program and the
+// guest's balance, returning the redemption with its discount recalculated or nil if it can't
+// be applied.
+func (h *TableHandler) checkRedemption(ctx context.Context, venue *structs.Venue, session *structs.ActiveTable) *structs.Redemption {
+	if session.Redemption == nil {
+		return nil
+	}
+
+	program, err := h.loyaltyRepo.GetProgram(ctx, venue.TenantID)
+	if err != nil || !program.Enabled {
+		return nil
+	}
+	reward, ok := program.Reward(session.Redemption.RewardID)
+	if !ok {
+		return nil
+	}
+	discount, ok := rewardDiscount(reward, session.OrderHistory)
+	if !ok {
+		return nil
+	}
+
+	profile, err := h.guestsRepo.GetProfileByClientID(ctx, venue.TenantID, session.ClientID)
+	if err != nil {
+		return nil
+	}
+	balance, err := h.loyaltyRepo.GetBalance(ctx, profile.ID)
+	if err != nil {
+		logger.Errorf("error fetching points balance: %v", err)
+		return nil
+	}
+	if balance < reward.Cost {
+		return nil
+	}
+
+	return &structs.Redemption{
+		RewardID: reward.ID,
+		Name:     reward.Name,
+		Points:   reward.Cost,
+		Discount: discount,
+	}
+}
+
+// recordLoyalty writes the ledger entries of a paid event: the points of the redeemed reward
+// and the points earned with the amount paid.
+func (h *TableHandler) recordLoyalty(ctx context.Context, venue *structs.Venue, profile *structs.GuestProfile, event *structs.Event) {
+	program, err := h.loyaltyRepo.GetProgram(ctx, venue.TenantID)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			logger.Errorf("error fetching loyalty program: %v", err)
+		}
+		return
+	}
+
+	if redemption := event.Order.Redemption; redemption != nil {
+		_, err := h.loyaltyRepo.AddLedgerEntry(ctx, &structs.LedgerEntry{
+			TenantID:    venue.TenantID,
+			ProfileID:   profile.ID,
+			Kind:        structs.LedgerRedeem,
+			Points:      -redemption.Points,
+			EventID:     event.ID,
+			RewardID:    redemption.RewardID,
+			Description: fmt.Sprintf("%s at %s", redemption.Name, venue.Name),
+			CreatedAt:   event.ClosedAt,
+		})
+		if err != nil {
+			logger.Errorf("error recording redemption: %v", err)
+		}
+	}
+
+	if !program.Enabled {
+		return
+	}
+	points := int(math.Floor((event.Subtotal - event.Discount) * program.EarnRate))
+	if points <= 0 {
+		return
+	}
+	_, err = h.loyaltyRepo.AddLedgerEntry(ctx, &structs.LedgerEntry{
+		TenantID:    venue.TenantID,
+		ProfileID:   profile.ID,
+		Kind:        structs.LedgerEarn,
+		Points:      points,
+		EventID:     event.ID,
+		Description: fmt.Sprintf("Visit to %s, receipt #%s", venue.Name, event.ReceiptNumber),
+		CreatedAt:   event.ClosedAt,
+	})
+	if err != nil {
+		logger.Errorf("error recording earned points: %v", err)
+	}
+}
+
+// loyaltySummary returns the balance and rewards a guest can use on the bill, nil when the
+// tenant has no enabled program or the guest has no profile yet.
+func (h *TableHandler) loyaltySummary(ctx context.Context, venue *structs.Venue, session *structs.ActiveTable) *structs.LoyaltySummary {
+	program, err := h.loyaltyRepo.GetProgram(ctx, venue.TenantID)
+	if err != nil || !program.Enabled {
+		return nil
+	}
+	profile, err := h.guestsRepo.GetProfileByClientID(ctx, venue.TenantID, session.ClientID)
+	if err != nil {
+		return nil
+	}
+	balance, err := h.loyaltyRepo.GetBalance(ctx, profile.ID)
+	if err != nil {
+		logger.Errorf("error fetching points balance: %v", err)
+		return nil
+	}
+
+	summary := &structs.LoyaltySummary{
+		VenueID:    venue.ID.Hex(),
+		Balance:    balance,
+		Redemption: session.Redemption,
+	}
+	for _, reward := range program.Rewards {
+		if _, ok := rewardDiscount(reward, session.OrderHistory); ok && reward.Cost <= balance {
+			summary.Rewards = append(summary.Rewards, reward)
+		}
+	}
+	return summary
+}
+
+// rewardDiscount returns the amount a reward takes off a bill. Free item rewards only apply
+// when the item was ordered.
+func rewardDiscount(reward structs.Reward, items []structs.OrderItem) (float64, bool) {
+	switch reward.Type {
+	case structs.RewardDiscount:
+		return reward.Amount, reward.Amount > 0
+	case structs.RewardFreeItem:
+		for _, item := range items {
+			if item.MenuItem != nil && strings.EqualFold(item.Name, reward.ItemName) {
+				return item.Price, true
+			}
+		}
+	}
+	return 0, false
+}
+
+type LoyaltyHandler struct {
+	loyaltyRepo *repo.LoyaltyRepository
+}
+
+func NewLoyaltyHandler(loyaltyRepo *repo.LoyaltyRepository) *LoyaltyHandler {
+	return &LoyaltyHandler{
+		loyaltyRepo: loyaltyRepo,
+	}
+}
+
+// AdminLoyaltyHandler shows and saves the loyalty program settings of the admin's tenant.
+func (h *LoyaltyHandler) AdminLoyaltyHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	tenantID := sessionTenant(session)
+
+	if r.Method == http.MethodPost {
+		earnRate, err := strconv.ParseFloat(r.FormValue("earnRate"), 64)
+		if err != nil || earnRate < 0 {
+			http.Error(w, "Earn rate must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if program, err := h.loyaltyRepo.GetProgram(r.Context(), tenantID); err == nil {
+			audit.Before(r.Context(), structs.LoyaltyProgram{Enabled: program.Enabled, EarnRate: program.EarnRate})
+		}
+		enabled := r.FormValue("enabled") == "on"
+		if _, err := h.loyaltyRepo.SaveProgramSettings(r.Context(), tenantID, enabled, earnRate); err != nil {
+			logger.Errorf("error saving loyalty program: %v", err)
+			http.Error(w, "Error saving loyalty program", http.StatusInternalServerError)
+			return
+		}
+		audit.Describe(r.Context(), "loyalty.settings", "loyalty program")
+		audit.After(r.Context(), structs.LoyaltyProgram{Enabled: enabled, EarnRate: earnRate})
+		http.Redirect(w, r, "/admin/loyalty", http.StatusSeeOther)
+		return
+	}
+
+	program, err := h.loyaltyRepo.GetProgram(r.Context(), tenantID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching loyalty program: %v", err)
+		http.Error(w, "Error fetching loyalty program", http.StatusInternalServerError)
+		return
+	}
+	if program == nil {
+		program = &structs.LoyaltyProgram{TenantID: tenantID, EarnRate: 1}
+	}
+
+	loyaltyPage := structs.AdminLoyaltyPage{
+		Title:     "Loyalty Program",
+		Program:   program,
+		CSRFToken: csrfToken(session),
+	}
+	tmpl := template.Must(template.New("admin-loyalty.html").Funcs(templateFuncs).ParseFiles("templates/admin-loyalty.html"))
+	err = tmpl.Execute(w, loyaltyPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *LoyaltyHandler) AddRewardHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	reward := structs.Reward{
+		ID:       uuid.New().String(),
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		Type:     r.FormValue("type"),
+		ItemName: strings.TrimSpace(r.FormValue("itemName")),
+	}
+	if reward.Name == "" {
+		http.Error(w, "Reward name is required", http.StatusBadRequest)
+		return
+	}
+	cost, err := strconv.Atoi(r.FormValue("cost"))
+	if err != nil || cost <= 0 {
+		http.Error(w, "Cost must be a positive number of points", http.StatusBadRequest)
+		return
+	}
+	reward.Cost = cost
+
+	switch reward.Type {
+	case structs.RewardFreeItem:
+		if reward.ItemName == "" {
+			http.Error(w, "Free item rewards need an item name", http.StatusBadRequest)
+			return
+		}
+	case structs.RewardDiscount:
+		reward.Amount, err = strconv.ParseFloat(r.FormValue("amount"), 64)
+		if err != nil || reward.Amount <= 0 {
+			http.Error(w, "Discount amount must be a positive number", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid reward type", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.loyaltyRepo.AddReward(r.Context(), sessionTenant(session), reward); err != nil {
+		logger.Errorf("error adding reward: %v", err)
+		http.Error(w, "Error adding reward", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "loyalty.reward.add", reward.Name)
+	audit.After(r.Context(), reward)
+	http.Redirect(w, r, "/admin/loyalty", http.StatusSeeOther)
+}
+
+func (h *LoyaltyHandler) RemoveRewardHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rewardID := mux.Vars(r)["id"]
+	audit.Describe(r.Context(), "loyalty.reward.remove", rewardID)
+	if program, err := h.loyaltyRepo.GetProgram(r.Context(), sessionTenant(session)); err == nil {
+		for _, reward := range program.Rewards {
+			if reward.ID == rewardID {
+				audit.Describe(r.Context(), "loyalty.reward.remove", reward.Name)
+				audit.Before(r.Context(), reward)
+			}
+		}
+	}
+	if _, err := h.loyaltyRepo.RemoveReward(r.Context(), sessionTenant(session), rewardID); err != nil {
+		logger.Errorf("error removing reward: %v", err)
+		http.Error(w, "Error removing reward", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/loyalty", http.StatusSeeOther)
+}
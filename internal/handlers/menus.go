@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/structs"
+)
+
+// MenusHandler lists the menus of the tenant with the venues using them.
+func (h *AdminHandler) MenusHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venues, err := h.venueRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+	menus, err := h.tenantMenus(r.Context(), sessionTenant(session), venues)
+	if err != nil {
+		logger.Errorf("error fetching menus: %v", err)
+		http.Error(w, "Error fetching menus", http.StatusInternalServerError)
+		return
+	}
+
+	menusPage := structs.AdminMenusPage{
+		Title:     "Menus",
+		Error:     r.FormValue("error"),
+		CSRFToken: csrfToken(session),
+	}
+	for _, menu := range menus {
+		adminMenu := structs.AdminMenu{Menu: menu}
+		for _, venue := range venues {
+			if venue.MenuID == menu.ID {
+				adminMenu.Venues = append(adminMenu.Venues, venue.Name)
+			}
+		}
+		menusPage.Menus = append(menusPage.Menus, adminMenu)
+	}
+
+	tmpl := template.Must(template.New("admin-menus.html").Funcs(templateFuncs).ParseFiles("templates/admin-menus.html"))
+	err = tmpl.Execute(w, menusPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *AdminHandler) RenameMenuHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	menu, ok := h.getMenu(w, r, mux.Vars(r)["menu"])
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, "/admin/menus?error=Menus+need+a+name", http.StatusSeeOther)
+		return
+	}
+
+	if _, err := h.menuRepo.RenameMenu(r.Context(), menu.ID, name); err != nil {
+		logger.Errorf("error renaming menu: %v", err)
+		http.Error(w, "Error renaming menu", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "menu.rename", menuTarget(menu))
+	audit.Before(r.Context(), map[string]string{"name": menu.Name})
+	audit.After(r.Context(), map[string]string{"name": name})
+	http.Redirect(w, r, "/admin/menus", http.StatusSeeOther)
+}
+
+// DeleteMenuHandler deletes a menu no venue uses anymore, with the images of its items.
+func (h *AdminHandler) DeleteMenuHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	menu, ok := h.getMenu(w, r, mux.Vars(r)["menu"])
+	if !ok {
+		return
+	}
+	inUse, err := h.venueRepo.CountVenuesWithMenu(r.Context(), menu.ID)
+	if err != nil {
+		logger.Errorf("error counting venues of menu: %v", err)
+		http.Error(w, "Error deleting menu", http.StatusInternalServerError)
+		return
+	}
+	if inUse > 0 {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, "/admin/menus?error=Switch+the+venues+using+the+menu+to+another+one+before+deleting+it", http.StatusSeeOther)
+		return
+	}
+
+	audit.Describe(r.Context(), "menu.delete", menuTarget(menu))
+	audit.Before(r.Context(), menu)
+	h.deleteMenu(r.Context(), menu)
+	http.Redirect(w, r, "/admin/menus", http.StatusSeeOther)
+}
+
+// LinkMenuHandler switches a venue to another menu of the tenant. The overrides of the venue
+// were made for its previous menu and are dropped.
+func (h *AdminHandler) LinkMenuHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	menu, ok := h.getMenu(w, r, r.FormValue("menu"))
+	if !ok {
+		return
+	}
+	if menu.ID == venue.MenuID {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	if _, err := h.venueRepo.SetMenu(r.Context(), venue.ID, menu.ID); err != nil {
+		logger.Errorf("error setting menu of venue: %v", err)
+		http.Error(w, "Error setting menu", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "venue.menu", venueTarget(venue))
+	audit.Before(r.Context(), map[string]interface{}{"menu_id": venue.MenuID.Hex(), "menu_overrides": venue.MenuOverrides})
+	audit.After(r.Context(), map[string]interface{}{"menu_id": menu.ID.Hex()})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// ItemOverrideHandler sets the price and availability of an item of the menu of a venue for that
+// venue only. An empty price goes back to the price of the menu.
+func (h *AdminHandler) ItemOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	categoryIndex, err := strconv.Atoi(mux.Vars(r)["category"])
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	itemIndex, err := strconv.Atoi(mux.Vars(r)["item"])
+	if err != nil {
+		http.Error(w, "Invalid item", http.StatusBadRequest)
+		return
+	}
+	menu, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+	item, ok := menuItem(w, menu, categoryIndex, itemIndex)
+	if !ok {
+		return
+	}
+	category := menu.Data.Categories[categoryIndex].Name
+
+	override := structs.MenuOverride{
+		Category:    category,
+		Item:        item.Name,
+		Unavailable: r.FormValue("available") != "on",
+	}
+	if price := strings.TrimSpace(r.FormValue("price")); price != "" {
+		value, err := strconv.ParseFloat(price, 64)
+		if err != nil || value < 0 || math.IsInf(value, 0) {
+			http.Error(w, "Price must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if value != item.Price {
+			override.Price = &value
+		}
+	}
+
+	var overrides []structs.MenuOverride
+	for _, existing := range venue.MenuOverrides {
+		if existing.Category != category || existing.Item != item.Name {
+			overrides = append(overrides, existing)
+		}
+	}
+	if override.Price != nil || override.Unavailable {
+		overrides = append(overrides, override)
+	}
+	if _, err := h.venueRepo.SetMenuOverrides(r.Context(), venue.ID, overrides); err != nil {
+		logger.Errorf("error saving menu overrides: %v", err)
+		http.Error(w, "Error saving item", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "menu.override", fmt.Sprintf("%s %s", venueTarget(venue), item.Name))
+	audit.Before(r.Context(), venue.ItemOverride(category, item.Name))
+	audit.After(r.Context(), override)
+	http.Redirect(w, r, fmt.Sprintf("/venue/%s/menu", venue.ID.Hex()), http.StatusSeeOther)
+}
+
+// tenantMenus returns the menus of a tenant. Menus its venues got before menus could be shared
+// become menus of the tenant, named after their venue, the first time they are listed.
+func (h *AdminHandler) tenantMenus(ctx context.Context, tenantID string, venues []structs.Venue) ([]structs.Menu, error) {
+	for i := range venues {
+		venue := &venues[i]
+		if !venue.MenuID.IsZero() {
+			continue
+		}
+		menu, err := h.menuRepo.GetMenuForVenue(ctx, venue)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := h.menuRepo.AdoptMenu(ctx, menu.ID, tenantID, venue.Name); err != nil {
+			return nil, err
+		}
+		if _, err := h.venueRepo.SetMenu(ctx, venue.ID, menu.ID); err != nil {
+			return nil, err
+		}
+		venue.MenuID = menu.ID
+	}
+	return h.menuRepo.GetMenusForTenant(ctx, tenantID)
+}
+
+// getMenu loads a menu of the tenant of the session by its id, writing the error response itself
+// when it can't.
+func (h *AdminHandler) getMenu(w http.ResponseWriter, r *http.Request, id string) (*structs.Menu, bool) {
+	menuID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid menu id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	menu, err := h.menuRepo.GetMenu(r.Context(), menuID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Menu not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching menu: %v", err)
+		http.Error(w, "Error fetching menu", http.StatusInternalServerError)
+		return nil, false
+	}
+	if session, _ := store.Get(r, "session-name"); menu.TenantID != sessionTenant(session) {
+		http.Error(w, "Menu not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return menu, true
+}
+
+// venueMenu returns the menu a venue uses, an empty one without id when it has none, writing the
+// error response itself when it can't.
+func (h *AdminHandler) venueMenu(w http.ResponseWriter, r *http.Request, venue *structs.Venue) (*structs.Menu, bool) {
+	menu, err := h.menuRepo.GetMenuForVenue(r.Context(), venue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &structs.Menu{}, true
+	}
+	if err != nil {
+		logger.Errorf("error fetching menu: %v", err)
+		http.Error(w, "Error fetching menu", http.StatusInternalServerError)
+		return nil, false
+	}
+	return menu, true
+}
+
+// menuItem returns an item of a menu by the indexes in the route, writing the error response
+// itself when there is no such item.
+func menuItem(w http.ResponseWriter, menu *structs.Menu, categoryIndex, itemIndex int) (structs.MenuItem, bool) {
+	categories := menu.Data.Categories
+	if categoryIndex < 0 || categoryIndex >= len(categories) || itemIndex < 0 || itemIndex >= len(categories[categoryIndex].Items) {
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+		return structs.MenuItem{}, false
+	}
+	return categories[categoryIndex].Items[itemIndex], true
+}
+
+// deleteMenu deletes a menu with the images of its items. Failures are only logged.
+func (h *AdminHandler) deleteMenu(ctx context.Context, menu *structs.Menu) {
+	if _, err := h.menuRepo.DeleteMenu(ctx, menu.ID); err != nil {
+		logger.Errorf("error deleting menu: %v", err)
+		return
+	}
+	for _, category := range menu.Data.Categories {
+		for _, item := range category.Items {
+			deleteImages(ctx, h.images, item.Image, item.Thumbnail)
+		}
+	}
+}
+
+// menuTarget names a menu in the audit log.
+func menuTarget(menu *structs.Menu) string {
+	return fmt.Sprintf("menu %s (%s)", menu.Name, menu.ID.Hex())
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	eventMovedTo    = "moved_to"
+	eventMovedFrom  = "moved_from"
+	eventMergedInto = "merged_into"
+	eventMergedFrom = "merged_from"
+)
+
+// MoveSessionHandler moves the session of a table to another free table of the same venue that
+// isn't held for a booking.
+func (h *TableHandler) MoveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+	target := r.FormValue("target")
+	source, venue, ok := h.getSessionForTransfer(w, r, code, target)
+	if !ok {
+		return
+	}
+	if source.Payment != nil {
+		http.Error(w, "The session has an online payment in progress", http.StatusConflict)
+		return
+	}
+	if blocking, _ := reservationBlock(r.Context(), h.reservationsRepo, venue, target, time.Now()); blocking != nil {
+		http.Error(w, fmt.Sprintf("The target table is held for the booking of %s at %s", blocking.Name, blocking.StartsAt.In(venue.Reservations.Location()).Format("15:04")), http.StatusConflict)
+		return
+	}
+
+	existing, err := h.tablesRepo.GetSessionForTable(target)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		http.Error(w, "The target table is occupied, merge the sessions instead", http.StatusConflict)
+		return
+	}
+
+	before := *source
+	result, err := h.tablesRepo.MoveSession(r.Context(), source, target)
+	if errors.Is(err, repo.ErrTableOccupied) {
+		http.Error(w, "The target table is occupied, merge the sessions instead", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error moving session: %v", err)
+		http.Error(w, "Error moving session", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "The session changed while moving it, please try again", http.StatusConflict)
+		return
+	}
+	source.TableCode = target
+
+	h.recordTransfer(r.Context(), &before, eventMovedTo, target)
+	h.recordTransfer(r.Context(), source, eventMovedFrom, code)
+	h.events.publish(r.Context(), sessionClosed, &before)
+	h.events.publish(r.Context(), sessionOpened, source)
+	audit.Describe(r.Context(), "session.move", codeTarget(venue, code)+" to "+codeTarget(venue, target))
+	audit.Before(r.Context(), before)
+	audit.After(r.Context(), source)
+
+	h.renderOpenSessions(w, r)
+}
+
+// MergeSessionHandler merges the session of a table into the session of another table, combining
+// their orders. The guest of the merged session keeps access to the combined one.
+func (h *TableHandler) MergeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+	into := r.FormValue("into")
+	source, venue, ok := h.getSessionForTransfer(w, r, code, into)
+	if !ok {
+		return
+	}
+	if source.Payment != nil {
+		http.Error(w, "The session has an online payment in progress", http.StatusConflict)
+		return
+	}
+
+	target, err := h.tablesRepo.GetSessionForTable(into)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "The target table has no active session, move the session instead", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+	if target.Payment != nil {
+		http.Error(w, "The target session has an online payment in progress", http.StatusConflict)
+		return
+	}
+
+	// The source is taken off its table first so whatever it has ordered by then is merged, and
+	// put back when the target changed in the meantime
+	before := *target
+	source, err = h.tablesRepo.TakeSession(r.Context(), source)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "The session changed while merging it, please try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error taking session: %v", err)
+		http.Error(w, "Error merging sessions", http.StatusInternalServerError)
+		return
+	}
+	var clients []string
+	for _, clientID := range append([]string{source.ClientID}, source.AdditionalClients...) {
+		if clientID != "" && !target.HasClient(clientID) {
+			clients = append(clients, clientID)
+		}
+	}
+	target, err = h.tablesRepo.MergeSession(r.Context(), target.ID, source, clients)
+	if err != nil {
+		if _, restoreErr := h.tablesRepo.TableActive(source); restoreErr != nil {
+			logger.Errorf("error restoring session of table %v: %v", source.TableCode, restoreErr)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "The target session changed while merging, please try again", http.StatusConflict)
+			return
+		}
+		logger.Errorf("error merging session: %v", err)
+		http.Error(w, "Error merging sessions", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordTransfer(r.Context(), source, eventMergedInto, into)
+	h.recordTransfer(r.Context(), &before, eventMergedFrom, code)
+	h.events.publish(r.Context(), sessionClosed, source)
+	h.events.publish(r.Context(), sessionUpdated, target)
+	audit.Describe(r.Context(), "session.merge", codeTarget(venue, code)+" into "+codeTarget(venue, into))
+	audit.Before(r.Context(), before)
+	audit.After(r.Context(), target)
+
+	h.renderOpenSessions(w, r)
+}
+
+// getSessionForTransfer returns the session of a table and its venue after checking the other
+// table of a move or merge is a different table of the same venue.
+func (h *TableHandler) getSessionForTransfer(w http.ResponseWriter, r *http.Request, code, other string) (*structs.ActiveTable, *structs.Venue, bool) {
+	if other == "" || other == code {
+		http.Error(w, "Choose a different table", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	session, err := h.tablesRepo.GetSessionForTable(code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if session.IsPickup() {
+		http.Error(w, "Pickup orders aren't on a table", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	venue, err := h.venuesRepo.GetVenueByTableCode(r.Context(), code)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if adminSession, _ := store.Get(r, "session-name"); !ownsVenue(adminSession, venue) {
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	otherVenue, err := h.venuesRepo.GetVenueByTableCode(r.Context(), other)
+	if err != nil || otherVenue.ID != venue.ID {
+		http.Error(w, "The table doesn't belong to this venue", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if !venueHasTable(otherVenue, other) {
+		http.Error(w, "The table has been retired", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	return session, venue, true
+}
+
+// recordTransfer records the event of one side of a move or merge, failures are only logged
+// since the sessions have already been updated.
+func (h *TableHandler) recordTransfer(ctx context.Context, session *structs.ActiveTable, status, relatedTable string) {
+	event := structs.Event{
+		Status:       status,
+		Order:        *session,
+		RelatedTable: relatedTable,
+		ClosedAt:     time.Now(),
+	}
+	if venue, err := h.venuesRepo.GetVenueByTableCode(ctx, session.TableCode); err == nil {
+		event.VenueID = venue.ID
+	}
+	if _, err := h.eventsRepo.RecordEvent(&event); err != nil {
+		logger.Errorf("error recording %v event for table %v: %v", status, session.TableCode, err)
+	}
+}
+
+// transferredSession returns the table code a guest's session was moved or merged into, when
+// their last session on the table didn't end there.
+func (h *TableHandler) transferredSession(ctx context.Context, code, clientID string) (string, bool) {
+	event, err := h.eventsRepo.GetLatestEventForClient(ctx, code, clientID)
+	if err != nil || (event.Status != eventMovedTo && event.Status != eventMergedInto) {
+		return "", false
+	}
+	session, err := h.tablesRepo.GetSessionForTable(event.RelatedTable)
+	if err != nil || !session.HasClient(clientID) {
+		return "", false
+	}
+	return session.TableCode, true
+}
+
+func (h *TableHandler) renderOpenSessions(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	venues, err := h.venuesRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := h.tablesRepo.GetOpenSessionsForVenues(r.Context(), venues)
+	if err != nil {
+		logger.Errorf("error fetching open sessions: %v", err)
+		http.Error(w, "Error fetching open sessions", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := template.Must(template.New("open-sessions.html").Funcs(templateFuncs).ParseFiles("templates/open-sessions.html", "templates/session-card.html", "templates/merge-targets.html"))
+	tmpl.Execute(w, sessions)
+}
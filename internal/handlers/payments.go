@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+	"vortex.studio/account/internal/payments"
+	"vortex.studio/account/internal/receipts"
+	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/utils"
+)
+
+// PayHandler starts an online checkout for the placed orders of a table and redirects the guest
+// to the provider's payment page.
+func (h *TableHandler) PayHandler(w http.ResponseWriter, r *http.Request) {
+	if h.payments == nil {
+		http.Error(w, "Online payments are not available", http.StatusNotImplemented)
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+	logger.Infof("got code: %v", code)
+
+	session, err := h.tablesRepo.GetSessionForTable(code)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+
+	if session == nil {
+		logger.Errorf("no active session found for code: %v", code)
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return
+	}
+
+	clientID := ""
+	cookie, err := r.Cookie("client_id")
+	if err == nil {
+		clientID = cookie.Value
+	}
+
+	if !session.HasClient(clientID) {
+		tmpl := template.Must(template.New("occupied.html").Funcs(templateFuncs).ParseFiles("templates/occupied.html"))
+		tmpl.Execute(w, nil)
+		return
+	}
+
+	if len(session.OrderHistory) == 0 {
+		http.Error(w, "There is nothing to pay yet", http.StatusBadRequest)
+		return
+	}
+	if session.Payment.NeedsReview() {
+		http.Error(w, "A payment of this table is being checked, please ask a member of staff", http.StatusConflict)
+		return
+	}
+
+	var tip float64
+	if tipStr := r.FormValue("tip"); tipStr != "" {
+		tip, err = strconv.ParseFloat(tipStr, 64)
+		if err != nil || tip < 0 {
+			http.Error(w, "Invalid tip", http.StatusBadRequest)
+			return
+		}
+	}
+
+	venue, err := h.sessionVenue(r.Context(), session)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+
+	var discount float64
+	if redemption := h.checkRedemption(r.Context(), venue, session); redemption != nil {
+		discount = redemption.Discount
+	}
+
+	totals := receipts.Calculate(session.OrderHistory, venue.TaxRate, discount, tip)
+	historyURL := fmt.Sprintf("%s/history/%s", utils.PublicBaseURL(), code)
+	checkout, err := h.payments.CreateCheckout(r.Context(), payments.CheckoutRequest{
+		Reference:   session.ID.Hex(),
+		Amount:      totals.Total,
+		Currency:    payments.Currency(),
+		Description: fmt.Sprintf("%s - table %s", venue.Name, code),
+		SuccessURL:  historyURL,
+		CancelURL:   historyURL,
+	})
+	if err != nil {
+		logger.Errorf("error creating checkout: %v", err)
+		http.Error(w, "Error starting payment", http.StatusBadGateway)
+		return
+	}
+
+	payment := &structs.Payment{
+		CheckoutID: checkout.ID,
+		Amount:     totals.Total,
+		Tip:        totals.Tip,
+		CreatedAt:  time.Now(),
+	}
+	result, err := h.tablesRepo.StartPayment(r.Context(), session.ID, len(session.OrderHistory), payment)
+	if err != nil {
+		logger.Errorf("error updating session: %v", err)
+		http.Error(w, "Error updating session", http.StatusInternalServerError)
+		return
+	}
+	// The checkout is for a bill that changed in the meantime, the guest starts over
+	if result.MatchedCount == 0 {
+		http.Error(w, "The bill of this table changed, please check it and pay again", http.StatusConflict)
+		return
+	}
+
+	http.Redirect(w, r, checkout.URL, http.StatusSeeOther)
+}
+
+// PaymentWebhookHandler receives the provider notifications and closes the table as paid once
+// its checkout completes. Checkouts that no longer cover the bill leave the session open with the
+// payment flagged for staff. Unknown or already closed sessions are acknowledged so the provider
+// stops retrying.
+func (h *TableHandler) PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if h.payments == nil {
+		http.Error(w, "Online payments are not available", http.StatusNotImplemented)
+		return
+	}
+
+	event, err := h.payments.ParseWebhook(r)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		logger.Errorf("rejected payment webhook: %v", err)
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error parsing payment webhook: %v", err)
+		http.Error(w, "Invalid webhook", http.StatusBadRequest)
+		return
+	}
+
+	if event.Type != payments.EventCheckoutCompleted {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	logger.Infof("checkout %v completed for session %v", event.CheckoutID, event.Reference)
+
+	sessionID, err := primitive.ObjectIDFromHex(event.Reference)
+	if err != nil {
+		logger.Errorf("invalid payment reference: %v", event.Reference)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	session, err := h.tablesRepo.GetSessionByID(r.Context(), sessionID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Infof("session %v already closed", event.Reference)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+
+	venue, err := h.sessionVenue(r.Context(), session)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+
+	// The bill may have changed since the checkout started, the table is only closed when what
+	// was charged still covers it
+	payment := session.Payment
+	if payment == nil || payment.CheckoutID != event.CheckoutID {
+		logger.Errorf("checkout %v is no longer the checkout of the session %v", event.CheckoutID, event.Reference)
+		payment = &structs.Payment{CheckoutID: event.CheckoutID, CreatedAt: time.Now()}
+	}
+	var discount float64
+	if redemption := h.checkRedemption(r.Context(), venue, session); redemption != nil {
+		discount = redemption.Discount
+	}
+	totals := receipts.Calculate(session.OrderHistory, venue.TaxRate, discount, payment.Tip)
+	if payment != session.Payment || payments.ToMinorUnits(event.Amount) != payments.ToMinorUnits(totals.Total) {
+		logger.Errorf("paid amount %v doesn't cover the bill %v of session %v", event.Amount, totals.Total, event.Reference)
+		payment.Charged = event.Amount
+		if _, err := h.tablesRepo.FlagPayment(r.Context(), session.ID, payment); err != nil {
+			logger.Errorf("error flagging payment: %v", err)
+			http.Error(w, "Error updating session", http.StatusInternalServerError)
+			return
+		}
+		session.Payment = payment
+		h.events.publish(r.Context(), sessionUpdated, session)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	_, err = h.closeSession(r.Context(), session, "paid", "online", session.Payment.Tip)
+	if errors.Is(err, errSessionChanged) {
+		// Closed by staff or ordered at in the meantime, the provider retries and the bill is
+		// checked again
+		logger.Errorf("session %v changed while closing it", event.Reference)
+		http.Error(w, "Session changed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error closing session: %v", err)
+		http.Error(w, "Error closing session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type FakePaymentsHandler struct {
+	provider *payments.FakeProvider
+	client   *http.Client
+}
+
+func NewFakePaymentsHandler(provider *payments.FakeProvider) *FakePaymentsHandler {
+	return &FakePaymentsHandler{
+		provider: provider,
+		client:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// CheckoutHandler renders the fake provider's payment page.
+func (h *FakePaymentsHandler) CheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	checkout, ok := h.provider.Checkout(id)
+	if !ok {
+		http.Error(w, "Checkout not found", http.StatusNotFound)
+		return
+	}
+
+	checkoutPage := structs.FakeCheckoutPage{
+		Title:       "Checkout",
+		ID:          id,
+		Description: checkout.Description,
+		Amount:      checkout.Amount,
+		Currency:    checkout.Currency,
+	}
+	tmpl := template.Must(template.New("fake-checkout.html").Funcs(templateFuncs).ParseFiles("templates/fake-checkout.html"))
+	err := tmpl.Execute(w, checkoutPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CompleteHandler pays or cancels a fake checkout. Paying delivers the signed webhook to this
+// service the same way a real provider would before sending the guest back.
+func (h *FakePaymentsHandler) CompleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	checkout, ok := h.provider.Checkout(id)
+	if !ok {
+		http.Error(w, "Checkout not found", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("action") != "pay" {
+		h.provider.CancelCheckout(id)
+		http.Redirect(w, r, checkout.CancelURL, http.StatusSeeOther)
+		return
+	}
+
+	webhook, err := h.provider.CompleteCheckout(id)
+	if err != nil {
+		logger.Errorf("error completing checkout: %v", err)
+		http.Error(w, "Error completing checkout", http.StatusInternalServerError)
+		return
+	}
+	resp, err := h.client.Do(webhook.WithContext(r.Context()))
+	if err != nil {
+		logger.Errorf("error delivering payment webhook: %v", err)
+		http.Error(w, "Error delivering webhook", http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorf("payment webhook failed with status: %v", resp.Status)
+	}
+
+	http.Redirect(w, r, checkout.SuccessURL, http.StatusSeeOther)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/structs"
+)
+
+const defaultPrepMinutes = 20
+
+// PickupHandler is the venue-level ordering page for takeaway. Guests leave their name and when
+// they want to collect the order, which opens a pickup session they order on like on a table.
+func (h *TableHandler) PickupHandler(w http.ResponseWriter, r *http.Request) {
+	venueID, err := primitive.ObjectIDFromHex(mux.Vars(r)["venue"])
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return
+	}
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), venueID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+	if !venue.Pickup.Enabled {
+		http.Error(w, "This venue doesn't take pickup orders", http.StatusNotFound)
+		return
+	}
+
+	// Guests with an open pickup order go back to it
+	clientID := getOrCreateClientID(w, r)
+	session, err := h.tablesRepo.GetPickupSessionForClient(r.Context(), venue.ID, clientID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+	if session != nil {
+		http.Redirect(w, r, fmt.Sprintf("/table/%s", session.TableCode), http.StatusSeeOther)
+		return
+	}
+
+	pickupPage := structs.PickupPage{
+		Title:    "Order for pickup",
+		Venue:    *venue,
+		Earliest: earliestPickup(venue, time.Now()),
+	}
+
+	if r.Method == http.MethodPost {
+		name := strings.TrimSpace(r.FormValue("name"))
+		readyAt, ok := parsePickupTime(r.FormValue("readyAt"), pickupPage.Earliest)
+		if name == "" {
+			pickupPage.Error = "Please tell us the name to call for the order."
+		} else if !ok {
+			pickupPage.Error = "Please choose a pickup time from " + pickupPage.Earliest.Format("15:04") + "."
+		} else {
+			orderNumber, err := h.eventsRepo.NextPickupNumber(r.Context(), venue.ID, time.Now().In(venue.Location()).Format(dateLayout))
+			if err != nil {
+				logger.Errorf("error assigning pickup number: %v", err)
+				http.Error(w, "Error creating order", http.StatusInternalServerError)
+				return
+			}
+
+			session = &structs.ActiveTable{
+				ClientID:     clientID,
+				TableCode:    "pickup-" + shortuuid.New(),
+				OpenedAt:     time.Now(),
+				Mode:         structs.ModePickup,
+				VenueID:      venue.ID,
+				CustomerName: name,
+				OrderNumber:  orderNumber,
+				ReadyAt:      readyAt,
+			}
+			if _, err := h.tablesRepo.TableActive(session); err != nil {
+				logger.Errorf("error creating session: %v", err)
+				http.Error(w, "Error creating order", http.StatusInternalServerError)
+				return
+			}
+			h.events.publish(r.Context(), sessionOpened, session)
+			http.Redirect(w, r, fmt.Sprintf("/table/%s", session.TableCode), http.StatusSeeOther)
+			return
+		}
+	}
+
+	tmpl := template.Must(template.New("pickup.html").Funcs(templateFuncs).ParseFiles("templates/pickup.html"))
+	err = tmpl.Execute(w, pickupPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PickupSettingsHandler saves the pickup settings of a venue.
+func (h *AdminHandler) PickupSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	prepMinutes, err := strconv.Atoi(r.FormValue("prepMinutes"))
+	if err != nil || prepMinutes < 0 {
+		http.Error(w, "Preparation time must be a positive number of minutes", http.StatusBadRequest)
+		return
+	}
+	timezone := strings.TrimSpace(r.FormValue("timezone"))
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			http.Error(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+	}
+	settings := structs.PickupSettings{
+		Enabled:     r.FormValue("enabled") == "on",
+		PrepMinutes: prepMinutes,
+	}
+	if _, err := h.venueRepo.SetPickupSettings(r.Context(), venue.ID, settings, timezone); err != nil {
+		logger.Errorf("error saving pickup settings: %v", err)
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "venue.pickup", venueTarget(venue))
+	audit.Before(r.Context(), map[string]interface{}{"pickup": venue.Pickup, "timezone": venue.Timezone})
+	audit.After(r.Context(), map[string]interface{}{"pickup": settings, "timezone": timezone})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// earliestPickup returns when an order placed at the given time can be collected, in the time
+// zone of the venue so the pickup times chosen against it are too.
+func earliestPickup(venue *structs.Venue, at time.Time) time.Time {
+	prepMinutes := venue.Pickup.PrepMinutes
+	if prepMinutes == 0 {
+		prepMinutes = defaultPrepMinutes
+	}
+	return at.In(venue.Location()).Add(time.Duration(prepMinutes) * time.Minute).Truncate(time.Minute)
+}
+
+// parsePickupTime reads the "15:04" pickup time chosen by the guest on the day and in the time
+// zone of the earliest pickup, an empty value meaning as soon as possible. Times before the
+// earliest pickup are rejected.
+func parsePickupTime(value string, earliest time.Time) (time.Time, bool) {
+	if value == "" {
+		return earliest, true
+	}
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	readyAt := time.Date(earliest.Year(), earliest.Month(), earliest.Day(), clock.Hour(), clock.Minute(), 0, 0, earliest.Location())
+	if readyAt.Before(earliest) {
+		return time.Time{}, false
+	}
+	return readyAt, true
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/portable"
+	"vortex.studio/account/internal/structs"
+
+	menu "vortex.studio/account/internal/menu-analyzer"
+)
+
+// maxImportSize is the largest venue document or menu CSV file that can be imported.
+const maxImportSize = 10 << 20
+
+// ExportVenueHandler downloads a venue with its tables, settings and menu as a JSON or YAML
+// document that ImportVenueHandler can create the venue from again.
+func (h *AdminHandler) ExportVenueHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	venueMenu, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+
+	format := mux.Vars(r)["format"]
+	filename := fmt.Sprintf("%s-%s.%s", makeURLSafe(venue.Name), time.Now().Format(dateLayout), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := portable.Write(w, portable.FromVenue(*venue, venueMenu.Data), format); err != nil {
+		logger.Errorf("error exporting venue %v: %v", venue.ID.Hex(), err)
+	}
+}
+
+// ImportVenueHandler creates a venue in the tenant of the session from an exported document,
+// without going through the menu analysis. Tables get new codes, so the QR codes printed for
+// the venue the document came from keep pointing at it. Documents with any problem are
+// rejected as a whole with a report of them all.
+func (h *AdminHandler) ImportVenueHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("document")
+	if err != nil {
+		logger.Errorf("error getting file from form: %v", err)
+		http.Error(w, "Error getting file from form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	doc, err := portable.Read(file)
+	if err != nil {
+		renderImportReport(w, header.Filename, "/admin", []structs.ImportProblem{{Message: err.Error()}})
+		return
+	}
+	if problems := doc.Validate(); len(problems) > 0 {
+		renderImportReport(w, header.Filename, "/admin", problems)
+		return
+	}
+
+	venue := structs.Venue{
+		Name:          strings.TrimSpace(doc.Venue.Name),
+		Description:   doc.Venue.Description,
+		Address:       doc.Venue.Address,
+		Phone:         doc.Venue.Phone,
+		TaxID:         doc.Venue.TaxID,
+		TaxRate:       doc.Venue.TaxRate,
+		Timezone:      doc.Venue.Timezone,
+		TenantID:      sessionTenant(session),
+		Floor:         doc.Venue.Floor,
+		Reservations:  doc.Venue.Reservations,
+		Pickup:        doc.Venue.Pickup,
+		QR:            doc.Venue.QR,
+		MenuID:        primitive.NewObjectID(),
+		MenuOverrides: doc.Venue.MenuOverrides,
+	}
+	// The document has no venue image to put on the codes
+	venue.QR.Logo = false
+	for _, table := range doc.Venue.Tables {
+		code := newTableCode(&venue, strings.TrimSpace(table.Label), strings.TrimSpace(table.Section))
+		code.Retired = table.Retired
+		code.Layout = table.Layout
+		venue.TableCodes = append(venue.TableCodes, code)
+	}
+	for _, printer := range doc.Venue.Printers {
+		venue.Printers = append(venue.Printers, structs.Printer{
+			ID:      uuid.New().String(),
+			Name:    strings.TrimSpace(printer.Name),
+			Station: normalizeStation(printer.Station),
+			Address: strings.TrimSpace(printer.Address),
+		})
+	}
+
+	insertResult, err := h.venueRepo.CreateVenue(&venue)
+	if err != nil {
+		logger.Errorf("error creating venue: %v", err)
+		http.Error(w, "Failed to create venue", http.StatusInternalServerError)
+		return
+	}
+	venueID, ok := insertResult.InsertedID.(primitive.ObjectID)
+	if !ok {
+		logger.Errorf("error converting InsertedID to ObjectID")
+		http.Error(w, "Error creating venue", http.StatusInternalServerError)
+		return
+	}
+	venue.ID = venueID
+	audit.Describe(r.Context(), "venue.import", venueTarget(&venue))
+	audit.After(r.Context(), venue)
+
+	importedMenu := &menu.AnalysisData{
+		ID:             venue.MenuID,
+		VenueId:        venueID,
+		TenantID:       venue.TenantID,
+		Name:           venue.Name,
+		CategoryResult: doc.Menu,
+	}
+	if _, err := h.menuRepo.CreateMenu(importedMenu); err != nil {
+		logger.Errorf("error creating menu: %v", err)
+		http.Error(w, "The venue was created without its menu, import it on the menu page", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("venue %v imported from %v by %v", venueID.Hex(), header.Filename, sessionUser(session))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// ExportMenuCSVHandler downloads the items of the menu of a venue as CSV, with a column for
+// every translation.
+func (h *AdminHandler) ExportMenuCSVHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	venueMenu, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("%s-menu-%s.csv", makeURLSafe(venue.Name), time.Now().Format(dateLayout))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Content-Type", "text/csv")
+	if err := portable.WriteMenuCSV(w, venueMenu.Data); err != nil {
+		logger.Errorf("error exporting menu of venue %v: %v", venue.ID.Hex(), err)
+	}
+}
+
+// ImportMenuCSVHandler replaces the menu of a venue with the items of a CSV file, for every venue
+// using the menu. Items keeping their category and name keep their images. Files with any
+// rejected row leave the menu as it was and get a report of every rejected row.
+func (h *AdminHandler) ImportMenuCSVHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	menuURL := fmt.Sprintf("/venue/%s/menu", venue.ID.Hex())
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("menu")
+	if err != nil {
+		logger.Errorf("error getting file from form: %v", err)
+		http.Error(w, "Error getting file from form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	imported, problems := portable.ReadMenuCSV(file)
+	if len(problems) > 0 {
+		renderImportReport(w, header.Filename, menuURL, problems)
+		return
+	}
+
+	current, ok := h.venueMenu(w, r, venue)
+	if !ok {
+		return
+	}
+	imported.Language = current.Data.Language
+	images := map[string]structs.MenuItem{}
+	for _, category := range current.Data.Categories {
+		for _, item := range category.Items {
+			if item.Image != "" {
+				images[category.Name+"\x00"+item.Name] = item
+			}
+		}
+	}
+	for ci := range imported.Categories {
+		category := &imported.Categories[ci]
+		for ii := range category.Items {
+			key := category.Name + "\x00" + category.Items[ii].Name
+			if item, ok := images[key]; ok {
+				category.Items[ii].Image, category.Items[ii].Thumbnail = item.Image, item.Thumbnail
+				delete(images, key)
+			}
+		}
+	}
+
+	if current.ID.IsZero() {
+		// Venues without a menu get one of their own
+		created := &menu.AnalysisData{
+			ID:             primitive.NewObjectID(),
+			VenueId:        venue.ID,
+			TenantID:       venue.TenantID,
+			Name:           venue.Name,
+			CategoryResult: imported,
+		}
+		if _, err := h.menuRepo.CreateMenu(created); err != nil {
+			logger.Errorf("error creating menu: %v", err)
+			http.Error(w, "Error creating menu", http.StatusInternalServerError)
+			return
+		}
+		if _, err := h.venueRepo.SetMenu(r.Context(), venue.ID, created.ID); err != nil {
+			logger.Errorf("error setting menu of venue: %v", err)
+			http.Error(w, "Error setting menu", http.StatusInternalServerError)
+			return
+		}
+	} else if _, err := h.menuRepo.ReplaceMenu(r.Context(), current.ID, imported); err != nil {
+		logger.Errorf("error replacing menu: %v", err)
+		http.Error(w, "Error replacing menu", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "menu.import", venueTarget(venue))
+	audit.Before(r.Context(), current.Data)
+	audit.After(r.Context(), imported)
+	// What is left are the images of items the file dropped
+	for _, item := range images {
+		deleteImages(r.Context(), h.images, item.Image, item.Thumbnail)
+	}
+
+	http.Redirect(w, r, menuURL, http.StatusSeeOther)
+}
+
+// renderImportReport rejects an import, listing everything wrong with the file.
+func renderImportReport(w http.ResponseWriter, filename, back string, problems []structs.ImportProblem) {
+	reportPage := structs.ImportReportPage{
+		Title:    "Import rejected",
+		File:     filename,
+		Problems: problems,
+		Back:     back,
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	tmpl := template.Must(template.New("import-report.html").Funcs(templateFuncs).ParseFiles("templates/import-report.html"))
+	if err := tmpl.Execute(w, reportPage); err != nil {
+		logger.Errorf("error executing template: %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+type PrintingHandler struct {
+	venuesRepo    *repo.VenueRepository
+	menuRepo      *repo.MenuRepository
+	printJobsRepo *repo.PrintJobsRepository
+}
+
+func NewPrintingHandler(venuesRepo *repo.VenueRepository, menuRepo *repo.MenuRepository, printJobsRepo *repo.PrintJobsRepository) *PrintingHandler {
+	return &PrintingHandler{
+		venuesRepo:    venuesRepo,
+		menuRepo:      menuRepo,
+		printJobsRepo: printJobsRepo,
+	}
+}
+
+// AdminPrintingHandler shows the printers of a venue, the station of each menu category and the
+// latest print jobs.
+func (h *PrintingHandler) AdminPrintingHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venues, err := h.venuesRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+	if len(venues) == 0 {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	venue := venues[0]
+	for _, v := range venues {
+		if v.ID.Hex() == r.FormValue("venue") {
+			venue = v
+		}
+	}
+
+	printingPage := structs.AdminPrintingPage{
+		Title:     "Printing",
+		Venues:    venues,
+		Venue:     venue,
+		Error:     r.FormValue("error"),
+		Can:       auth.Permissions(sessionRoles(session)),
+		CSRFToken: csrfToken(session),
+	}
+	menu, err := h.menuRepo.GetMenuForVenue(r.Context(), &venue)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching menu: %v", err)
+	}
+	if menu != nil {
+		printingPage.Menu = menu.Data
+	}
+	printingPage.Jobs, err = h.printJobsRepo.GetRecentJobs(r.Context(), venue.ID, 50)
+	if err != nil {
+		logger.Errorf("error fetching print jobs: %v", err)
+		http.Error(w, "Error fetching print jobs", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := template.Must(template.New("admin-printing.html").Funcs(templateFuncs).ParseFiles("templates/admin-printing.html"))
+	err = tmpl.Execute(w, printingPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *PrintingHandler) AddPrinterHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	printer := structs.Printer{
+		ID:      uuid.New().String(),
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		Station: normalizeStation(r.FormValue("station")),
+		Address: strings.TrimSpace(r.FormValue("address")),
+	}
+	if printer.Name == "" || printer.Address == "" {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, adminPrintingURL(venue, "Printers need a name and an address"), http.StatusSeeOther)
+		return
+	}
+	host := printer.Address
+	if h, _, err := net.SplitHostPort(printer.Address); err == nil {
+		host = h
+	}
+	if host == "" {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, adminPrintingURL(venue, "Invalid printer address"), http.StatusSeeOther)
+		return
+	}
+
+	if _, err := h.venuesRepo.AddPrinter(r.Context(), venue.ID, printer); err != nil {
+		logger.Errorf("error adding printer: %v", err)
+		http.Error(w, "Error adding printer", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "printer.add", venueTarget(venue)+" "+printer.Name)
+	audit.After(r.Context(), printer)
+	http.Redirect(w, r, adminPrintingURL(venue, ""), http.StatusSeeOther)
+}
+
+func (h *PrintingHandler) RemovePrinterHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	printerID := mux.Vars(r)["printer"]
+	if _, err := h.venuesRepo.RemovePrinter(r.Context(), venue.ID, printerID); err != nil {
+		logger.Errorf("error removing printer: %v", err)
+		http.Error(w, "Error removing printer", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "printer.remove", venueTarget(venue)+" "+printerID)
+	for _, printer := range venue.Printers {
+		if printer.ID == printerID {
+			audit.Describe(r.Context(), "printer.remove", venueTarget(venue)+" "+printer.Name)
+			audit.Before(r.Context(), printer)
+		}
+	}
+	http.Redirect(w, r, adminPrintingURL(venue, ""), http.StatusSeeOther)
+}
+
+// CategoryStationHandler sets the station the items of a menu category are printed at.
+func (h *PrintingHandler) CategoryStationHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	categoryIndex, err := strconv.Atoi(mux.Vars(r)["category"])
+	if err != nil || categoryIndex < 0 {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+
+	menu, err := h.menuRepo.GetMenuForVenue(r.Context(), venue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "The venue has no menu", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching menu: %v", err)
+		http.Error(w, "Error fetching menu", http.StatusInternalServerError)
+		return
+	}
+
+	station := normalizeStation(r.FormValue("station"))
+	if _, err := h.menuRepo.SetCategoryStation(r.Context(), menu.ID, categoryIndex, station); err != nil {
+		logger.Errorf("error setting category station: %v", err)
+		http.Error(w, "Error saving station", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "menu.station", fmt.Sprintf("%s category %d", venueTarget(venue), categoryIndex))
+	audit.After(r.Context(), map[string]string{"station": station})
+	http.Redirect(w, r, adminPrintingURL(venue, ""), http.StatusSeeOther)
+}
+
+// ReprintHandler queues a copy of a print job, marked as a reprint on the ticket.
+func (h *PrintingHandler) ReprintHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid print job id", http.StatusBadRequest)
+		return
+	}
+	job, err := h.printJobsRepo.GetJobByID(r.Context(), id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Print job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching print job: %v", err)
+		http.Error(w, "Error fetching print job", http.StatusInternalServerError)
+		return
+	}
+
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), job.VenueID)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+	if !ownsVenue(session, venue) {
+		http.Error(w, "Print job not found", http.StatusNotFound)
+		return
+	}
+
+	ticket := job.Ticket
+	ticket.Reprint = true
+	_, err = h.printJobsRepo.AddJob(r.Context(), &structs.PrintJob{
+		VenueID:       job.VenueID,
+		PrinterName:   job.PrinterName,
+		Address:       job.Address,
+		Ticket:        ticket,
+		Status:        structs.PrintPending,
+		NextAttemptAt: time.Now(),
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		logger.Errorf("error queueing reprint: %v", err)
+		http.Error(w, "Error queueing reprint", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "print.reprint", fmt.Sprintf("%s job %s", venueTarget(venue), job.ID.Hex()))
+	http.Redirect(w, r, "/admin/printing?venue="+job.VenueID.Hex(), http.StatusSeeOther)
+}
+
+func (h *PrintingHandler) getVenue(w http.ResponseWriter, r *http.Request) (*structs.Venue, bool) {
+	venueID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), venueID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, false
+	}
+	if session, _ := store.Get(r, "session-name"); !ownsVenue(session, venue) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+	return venue, true
+}
+
+// printOrder queues a ticket of the placed items for every printer of the stations they are
+// prepared at. Failures are only logged, the order has been placed already.
+func (h *TableHandler) printOrder(ctx context.Context, session *structs.ActiveTable, items []structs.OrderItem) {
+	venue, err := h.sessionVenue(ctx, session)
+	if err != nil {
+		logger.Errorf("error fetching venue for printing: %v", err)
+		return
+	}
+	if len(venue.Printers) == 0 {
+		return
+	}
+	var menu structs.MenuData
+	if venueMenu, err := h.menuRepo.GetMenuForVenue(ctx, venue); err != nil {
+		logger.Errorf("error fetching menu for printing: %v", err)
+	} else {
+		menu = venueMenu.Data
+	}
+
+	for _, ticket := range ticketsByStation(venue, &menu, session, items, time.Now()) {
+		for _, printer := range venue.Printers {
+			if printer.Station != ticket.Station {
+				continue
+			}
+			_, err := h.printJobsRepo.AddJob(ctx, &structs.PrintJob{
+				VenueID:       venue.ID,
+				PrinterName:   printer.Name,
+				Address:       printer.Address,
+				Ticket:        ticket,
+				Status:        structs.PrintPending,
+				NextAttemptAt: time.Now(),
+				CreatedAt:     time.Now(),
+			})
+			if err != nil {
+				logger.Errorf("error queueing ticket for %v: %v", printer.Name, err)
+			}
+		}
+	}
+}
+
+// ticketsByStation splits placed items into one ticket per station, in the order stations first
+// appear in the order.
+func ticketsByStation(venue *structs.Venue, menu *structs.MenuData, session *structs.ActiveTable, items []structs.OrderItem, placedAt time.Time) []structs.Ticket {
+	var tickets []structs.Ticket
+	index := map[string]int{}
+	for _, item := range items {
+		if item.MenuItem == nil {
+			continue
+		}
+		station := menu.StationForItem(item.Name)
+		i, ok := index[station]
+		if !ok {
+			i = len(tickets)
+			index[station] = i
+			tickets = append(tickets, structs.Ticket{
+				VenueName:    venue.Name,
+				Station:      station,
+				TableCode:    session.TableCode,
+				OrderNumber:  session.OrderNumber,
+				CustomerName: session.CustomerName,
+				PlacedAt:     placedAt,
+			})
+		}
+		tickets[i].Items = append(tickets[i].Items, structs.TicketItem{Name: item.Name, Amount: item.Amount})
+	}
+	return tickets
+}
+
+func normalizeStation(station string) string {
+	station = strings.ToLower(strings.TrimSpace(station))
+	if station == "" {
+		return structs.DefaultStation
+	}
+	return station
+}
+
+func adminPrintingURL(venue *structs.Venue, message string) string {
+	query := url.Values{"venue": {venue.ID.Hex()}}
+	if message != "" {
+		query.Set("error", message)
+	}
+	return fmt.Sprintf("/admin/printing?%s", query.Encode())
+}
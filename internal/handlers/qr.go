@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strconv"
+	"vortex.studio/account/internal/blobstore"
+	"vortex.studio/account/internal/qr"
+	"vortex.studio/account/internal/repo"
+)
+
+const (
+	// qrCacheSize is how many rendered QR codes are kept in memory
+	qrCacheSize = 2000
+	// qrMaxAge is how long browsers reuse a QR code before checking it changed, in seconds
+	qrMaxAge = 300
+)
+
+type QRHandler struct {
+	venueRepo *repo.VenueRepository
+	images    blobstore.Store
+	cache     *qr.Cache
+}
+
+func NewQRHandler(venueRepo *repo.VenueRepository, images blobstore.Store) *QRHandler {
+	return &QRHandler{
+		venueRepo: venueRepo,
+		images:    images,
+		cache:     qr.NewCache(qrCacheSize),
+	}
+}
+
+// QRCodeHandler serves the QR code of a table as a PNG or SVG drawn with the QR settings of its
+// venue. PNGs are the size the venue chose unless the size parameter asks for another one. Codes
+// are cached in memory and tagged with the settings they were drawn with, so browsers only
+// download them again after the venue changes them.
+func (h *QRHandler) QRCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code, format := mux.Vars(r)["code"], mux.Vars(r)["format"]
+
+	venue, err := h.venueRepo.GetVenueByTableCode(r.Context(), code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Table not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return
+	}
+	table, ok := venue.Table(code)
+	if !ok {
+		http.Error(w, "Table not found", http.StatusNotFound)
+		return
+	}
+
+	size := venue.QR.Size
+	if param := r.URL.Query().Get("size"); param != "" {
+		size, err = strconv.Atoi(param)
+		if err != nil || size < qr.MinSize || size > qr.MaxSize {
+			http.Error(w, fmt.Sprintf("Size must be between %v and %v pixels", qr.MinSize, qr.MaxSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The venue image key changes with the image, so it stands in for the logo in the tag
+	logoKey := ""
+	if venue.QR.Logo {
+		logoKey = venue.Image
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%v|%s|%+v|%s", format, size, table.CodeUrl, venue.QR, logoKey)))
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", qrMaxAge))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := "image/png"
+	if format == "svg" {
+		contentType = "image/svg+xml"
+	}
+
+	data, ok := h.cache.Get(etag)
+	if !ok {
+		var logo []byte
+		if logoKey != "" {
+			logo = readImage(r.Context(), h.images, logoKey)
+		}
+		opts, err := qr.VenueOptions(venue.QR, logo)
+		if err != nil {
+			logger.Errorf("error reading qr settings of venue %v: %v", venue.ID.Hex(), err)
+			http.Error(w, "Error rendering QR code", http.StatusInternalServerError)
+			return
+		}
+		opts.Size = size
+		if format == "svg" {
+			data, err = qr.SVG(table.CodeUrl, opts)
+		} else {
+			data, err = qr.PNG(table.CodeUrl, opts)
+		}
+		if err != nil {
+			logger.Errorf("error rendering qr code: %v", err)
+			http.Error(w, "Error rendering QR code", http.StatusInternalServerError)
+			return
+		}
+		h.cache.Add(etag, data)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
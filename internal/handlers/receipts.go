@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"vortex.studio/account/internal/mailer"
+	"vortex.studio/account/internal/receipts"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+)
+
+type ReceiptsHandler struct {
+	eventsRepo *repo.EventsRepo
+	venuesRepo *repo.VenueRepository
+	mailer     mailer.Sender
+}
+
+func NewReceiptsHandler(eventsRepo *repo.EventsRepo, venuesRepo *repo.VenueRepository, mailer mailer.Sender) *ReceiptsHandler {
+	return &ReceiptsHandler{
+		eventsRepo: eventsRepo,
+		venuesRepo: venuesRepo,
+		mailer:     mailer,
+	}
+}
+
+func (h *ReceiptsHandler) ReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receipt, ok := h.getReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	receiptPage := structs.ReceiptPage{
+		Title:    fmt.Sprintf("Receipt #%s", receipt.Number),
+		Receipt:  receipt,
+		CanEmail: h.mailer != nil,
+	}
+	tmpl := template.Must(template.New("receipt.html").Funcs(templateFuncs).ParseFiles("templates/receipt.html", "templates/receipt-details.html"))
+	err := tmpl.Execute(w, receiptPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *ReceiptsHandler) ReceiptPDFHandler(w http.ResponseWriter, r *http.Request) {
+	receipt, ok := h.getReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := receipts.WritePDF(&buf, receipt); err != nil {
+		logger.Errorf("error rendering receipt pdf: %v", err)
+		http.Error(w, "Error rendering receipt", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%s.pdf"`, receipt.Number))
+	w.Write(buf.Bytes())
+}
+
+func (h *ReceiptsHandler) EmailReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if h.mailer == nil {
+		http.Error(w, "Email is not available", http.StatusNotImplemented)
+		return
+	}
+
+	receipt, ok := h.getReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	email := r.FormValue("email")
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendReceipt(h.mailer, email, receipt); err != nil {
+		logger.Errorf("error emailing receipt: %v", err)
+		http.Error(w, "Error sending email", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, `<div class="alert alert-success mt-3">Receipt sent to %s</div>`, template.HTMLEscapeString(email))
+}
+
+// getReceipt builds the receipt of the event referenced by the "token" route variable, writing
+// the error response itself when it can't.
+func (h *ReceiptsHandler) getReceipt(w http.ResponseWriter, r *http.Request) (*structs.Receipt, bool) {
+	token := mux.Vars(r)["token"]
+	event, err := h.eventsRepo.GetEventByReceiptToken(r.Context(), token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching receipt: %v", err)
+		http.Error(w, "Error fetching receipt", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return receipts.New(event, h.getEventVenue(r.Context(), event)), true
+}
+
+func (h *ReceiptsHandler) getEventVenue(ctx context.Context, event *structs.Event) *structs.Venue {
+	if event.VenueID.IsZero() {
+		return nil
+	}
+	venue, err := h.venuesRepo.GetVenueById(ctx, event.VenueID)
+	if err != nil {
+		logger.Errorf("error fetching venue for receipt: %v", err)
+		return nil
+	}
+	return venue
+}
+
+// sendReceipt emails the receipt as HTML with the PDF version attached.
+func sendReceipt(sender mailer.Sender, to string, receipt *structs.Receipt) error {
+	var html bytes.Buffer
+	tmpl := template.Must(template.New("receipt-email.html").Funcs(templateFuncs).ParseFiles("templates/receipt-email.html", "templates/receipt-details.html"))
+	if err := tmpl.Execute(&html, receipt); err != nil {
+		return err
+	}
+
+	var pdf bytes.Buffer
+	if err := receipts.WritePDF(&pdf, receipt); err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Your receipt from %s", receipt.VenueName)
+	return sender.Send(to, subject, html.String(), mailer.Attachment{
+		Filename:    fmt.Sprintf("receipt-%s.pdf", receipt.Number),
+		ContentType: "application/pdf",
+		Data:        pdf.Bytes(),
+	})
+}
@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/mailer"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/utils"
+)
+
+const dateLayout = "2006-01-02"
+
+var errNoFreeTable = errors.New("no free table")
+
+type ReservationsHandler struct {
+	reservationsRepo *repo.ReservationsRepository
+	venuesRepo       *repo.VenueRepository
+	mailer           mailer.Sender
+}
+
+func NewReservationsHandler(reservationsRepo *repo.ReservationsRepository, venuesRepo *repo.VenueRepository, mailer mailer.Sender) *ReservationsHandler {
+	return &ReservationsHandler{
+		reservationsRepo: reservationsRepo,
+		venuesRepo:       venuesRepo,
+		mailer:           mailer,
+	}
+}
+
+// BookingHandler shows the free slots of a venue for a day and books one of them on a free table.
+func (h *ReservationsHandler) BookingHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r, mux.Vars(r)["venue"])
+	if !ok {
+		return
+	}
+	if !venue.Reservations.Enabled {
+		http.Error(w, "This venue doesn't take reservations", http.StatusNotFound)
+		return
+	}
+
+	settings := venue.Reservations
+	loc := settings.Location()
+	day, err := time.ParseInLocation(dateLayout, r.FormValue("date"), loc)
+	if err != nil {
+		day = time.Now().In(loc)
+	}
+
+	bookingPage := structs.BookingPage{
+		Title: "Book a table",
+		Venue: *venue,
+		Date:  day.Format(dateLayout),
+		Today: time.Now().In(loc).Format(dateLayout),
+	}
+
+	if r.Method == http.MethodPost {
+		reservation, message := h.book(r, venue, day)
+		if reservation != nil {
+			http.Redirect(w, r, fmt.Sprintf("/book/%s/%s", venue.ID.Hex(), reservation.Reference), http.StatusSeeOther)
+			return
+		}
+		bookingPage.Error = message
+	}
+
+	for _, slot := range settings.Slots(day) {
+		available := false
+		if slot.After(time.Now()) {
+			_, err := h.freeTable(r.Context(), venue, slot, slot.Add(settings.Duration()), 0)
+			if err != nil && !errors.Is(err, errNoFreeTable) {
+				logger.Errorf("error checking free tables: %v", err)
+			}
+			available = err == nil
+		}
+		bookingPage.Slots = append(bookingPage.Slots, structs.BookingSlot{
+			Time:      slot.Format("15:04"),
+			Available: available,
+		})
+	}
+
+	tmpl := template.Must(template.New("booking.html").Funcs(templateFuncs).ParseFiles("templates/booking.html"))
+	err = tmpl.Execute(w, bookingPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// book validates a booking form and reserves a free table for it, returning a message for the
+// guest when the booking can't be made.
+func (h *ReservationsHandler) book(r *http.Request, venue *structs.Venue, day time.Time) (*structs.Reservation, string) {
+	settings := venue.Reservations
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return nil, "Please tell us your name."
+	}
+	partySize, err := strconv.Atoi(r.FormValue("partySize"))
+	if err != nil || partySize < 1 {
+		return nil, "Please tell us how many people are coming."
+	}
+	var email string
+	if value := r.FormValue("email"); value != "" {
+		var ok bool
+		if email, ok = parseEmail(value); !ok {
+			return nil, "Please enter a valid email address."
+		}
+	}
+
+	var start time.Time
+	for _, slot := range settings.Slots(day) {
+		if slot.Format("15:04") == r.FormValue("time") {
+			start = slot
+		}
+	}
+	if start.IsZero() || !start.After(time.Now()) {
+		return nil, "Please choose one of the available times."
+	}
+	end := start.Add(settings.Duration())
+
+	tableCode, err := h.freeTable(r.Context(), venue, start, end, partySize)
+	if errors.Is(err, errNoFreeTable) {
+		return nil, fmt.Sprintf("Sorry, we have no table for %v free at that time. Please choose another one.", partySize)
+	}
+	if err != nil {
+		logger.Errorf("error checking free tables: %v", err)
+		return nil, "We couldn't book your table, please try again."
+	}
+
+	reservation := &structs.Reservation{
+		VenueID:   venue.ID,
+		TableCode: tableCode,
+		Reference: newBookingReference(),
+		Name:      name,
+		Email:     email,
+		Phone:     strings.TrimSpace(r.FormValue("phone")),
+		PartySize: partySize,
+		StartsAt:  start,
+		EndsAt:    end,
+		Status:    structs.ReservationBooked,
+		CreatedAt: time.Now(),
+	}
+	_, err = h.reservationsRepo.CreateReservation(r.Context(), reservation)
+	if errors.Is(err, repo.ErrTableBooked) {
+		return nil, "Sorry, that time was just booked. Please choose another one."
+	}
+	if err != nil {
+		logger.Errorf("error creating reservation: %v", err)
+		return nil, "We couldn't book your table, please try again."
+	}
+
+	if h.mailer != nil && email != "" {
+		if err := h.sendConfirmation(venue, reservation); err != nil {
+			logger.Errorf("error sending reservation confirmation: %v", err)
+		}
+	}
+	return reservation, ""
+}
+
+// BookingDetailsHandler shows a booking to the guest that made it.
+func (h *ReservationsHandler) BookingDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	venue, reservation, ok := h.getBooking(w, r)
+	if !ok {
+		return
+	}
+
+	bookingPage := structs.BookingDetailsPage{
+		Title:       "Your booking",
+		Venue:       *venue,
+		Reservation: reservation,
+		Location:    venue.Reservations.Location(),
+	}
+	tmpl := template.Must(template.New("booking-details.html").Funcs(templateFuncs).ParseFiles("templates/booking-details.html"))
+	err := tmpl.Execute(w, bookingPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CancelBookingHandler lets the guest cancel a booking that hasn't started yet.
+func (h *ReservationsHandler) CancelBookingHandler(w http.ResponseWriter, r *http.Request) {
+	venue, reservation, ok := h.getBooking(w, r)
+	if !ok {
+		return
+	}
+	if reservation.Status != structs.ReservationBooked || !reservation.StartsAt.After(time.Now()) {
+		http.Error(w, "This booking can no longer be canceled", http.StatusConflict)
+		return
+	}
+
+	if _, err := h.reservationsRepo.SetStatus(r.Context(), reservation.ID, structs.ReservationCanceled); err != nil {
+		logger.Errorf("error canceling reservation: %v", err)
+		http.Error(w, "Error canceling booking", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/book/%s/%s", venue.ID.Hex(), reservation.Reference), http.StatusSeeOther)
+}
+
+// CalendarHandler shows the reservations of a venue for a day, one row per table.
+func (h *ReservationsHandler) CalendarHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	authenticated, ok := session.Values["authenticated"].(bool)
+	if !ok || !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venues, err := h.venuesRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+	if len(venues) == 0 {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	venue := venues[0]
+	for _, v := range venues {
+		if v.ID.Hex() == r.FormValue("venue") {
+			venue = v
+		}
+	}
+
+	loc := venue.Reservations.Location()
+	day, err := time.ParseInLocation(dateLayout, r.FormValue("date"), loc)
+	if err != nil {
+		now := time.Now().In(loc)
+		day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	}
+
+	reservations, err := h.reservationsRepo.GetReservationsForVenue(r.Context(), venue.ID, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		logger.Errorf("error fetching reservations: %v", err)
+		http.Error(w, "Error fetching reservations", http.StatusInternalServerError)
+		return
+	}
+
+	calendarPage := structs.ReservationsCalendarPage{
+		Title:     "Reservations",
+		Venues:    venues,
+		Venue:     venue,
+		Date:      day.Format(dateLayout),
+		Previous:  day.AddDate(0, 0, -1).Format(dateLayout),
+		Next:      day.AddDate(0, 0, 1).Format(dateLayout),
+		Location:  loc,
+		Can:       auth.Permissions(sessionRoles(session)),
+		CSRFToken: csrfToken(session),
+	}
+	for _, tableCode := range venue.ActiveTables() {
+		schedule := structs.TableSchedule{Code: tableCode.Code, Label: tableCode.Name()}
+		for _, reservation := range reservations {
+			if reservation.TableCode == tableCode.Code {
+				schedule.Reservations = append(schedule.Reservations, reservation)
+			}
+		}
+		calendarPage.Tables = append(calendarPage.Tables, schedule)
+	}
+
+	tmpl := template.Must(template.New("admin-reservations.html").Funcs(templateFuncs).ParseFiles("templates/admin-reservations.html"))
+	err = tmpl.Execute(w, calendarPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SettingsHandler saves the booking settings of a venue.
+func (h *ReservationsHandler) SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	if !ownsVenue(session, venue) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return
+	}
+
+	settings := structs.ReservationSettings{
+		Enabled:  r.FormValue("enabled") == "on",
+		Timezone: strings.TrimSpace(r.FormValue("timezone")),
+		OpensAt:  r.FormValue("opensAt"),
+		ClosesAt: r.FormValue("closesAt"),
+	}
+	if settings.Timezone != "" {
+		if _, err := time.LoadLocation(settings.Timezone); err != nil {
+			http.Error(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, value := range []string{settings.OpensAt, settings.ClosesAt} {
+		if _, err := time.Parse("15:04", value); err != nil {
+			http.Error(w, "Opening hours must be HH:MM times", http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	for field, target := range map[string]*int{"slotMinutes": &settings.SlotMinutes, "durationMinutes": &settings.DurationMinutes, "holdMinutes": &settings.HoldMinutes} {
+		*target, err = strconv.Atoi(r.FormValue(field))
+		if err != nil || *target < 0 {
+			http.Error(w, "Minutes must be positive numbers", http.StatusBadRequest)
+			return
+		}
+	}
+	if settings.SlotMinutes == 0 || settings.DurationMinutes == 0 {
+		http.Error(w, "Slot and booking length must be at least one minute", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.venuesRepo.SetReservationSettings(r.Context(), venue.ID, settings); err != nil {
+		logger.Errorf("error saving reservation settings: %v", err)
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "venue.reservations", venueTarget(venue))
+	audit.Before(r.Context(), venue.Reservations)
+	audit.After(r.Context(), settings)
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations?venue=%s", venue.ID.Hex()), http.StatusSeeOther)
+}
+
+// AssignTableHandler moves a reservation to another table of its venue if the table is free
+// for the whole booking.
+func (h *ReservationsHandler) AssignTableHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	reservation, venue, ok := h.getReservation(w, r)
+	if !ok {
+		return
+	}
+
+	tableCode := r.FormValue("table")
+	if !venueHasTable(venue, tableCode) {
+		http.Error(w, "The table doesn't belong to this venue", http.StatusBadRequest)
+		return
+	}
+	if table, _ := venue.Table(tableCode); !seatsParty(*table, reservation.PartySize) {
+		http.Error(w, fmt.Sprintf("Table %s seats %v, the booking is for %v", table.Name(), table.Layout.Seats, reservation.PartySize), http.StatusConflict)
+		return
+	}
+	conflicts, err := h.reservationsRepo.GetConflicts(r.Context(), tableCode, reservation.StartsAt, reservation.EndsAt, reservation.ID)
+	if err != nil {
+		logger.Errorf("error checking conflicts: %v", err)
+		http.Error(w, "Error checking conflicts", http.StatusInternalServerError)
+		return
+	}
+	if len(conflicts) > 0 {
+		http.Error(w, fmt.Sprintf("Table %s is booked by %s at that time", tableCode, conflicts[0].Name), http.StatusConflict)
+		return
+	}
+
+	_, err = h.reservationsRepo.SetTable(r.Context(), reservation.ID, tableCode)
+	if errors.Is(err, repo.ErrTableBooked) {
+		http.Error(w, fmt.Sprintf("Table %s was just booked at that time", tableCode), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error assigning table: %v", err)
+		http.Error(w, "Error assigning table", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "reservation.table", venueTarget(venue)+" booking "+reservation.Reference)
+	audit.Before(r.Context(), reservation)
+	reservation.TableCode = tableCode
+	audit.After(r.Context(), reservation)
+	http.Redirect(w, r, calendarURL(venue, reservation), http.StatusSeeOther)
+}
+
+func (h *ReservationsHandler) CancelReservationHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	reservation, venue, ok := h.getReservation(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.reservationsRepo.SetStatus(r.Context(), reservation.ID, structs.ReservationCanceled); err != nil {
+		logger.Errorf("error canceling reservation: %v", err)
+		http.Error(w, "Error canceling reservation", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "reservation.cancel", venueTarget(venue)+" booking "+reservation.Reference)
+	audit.Before(r.Context(), reservation)
+	reservation.Status = structs.ReservationCanceled
+	audit.After(r.Context(), reservation)
+	http.Redirect(w, r, calendarURL(venue, reservation), http.StatusSeeOther)
+}
+
+// freeTable returns the first table of a venue seating the party without bookings overlapping
+// [start, end). A party size of zero fits any table.
+func (h *ReservationsHandler) freeTable(ctx context.Context, venue *structs.Venue, start, end time.Time, partySize int) (string, error) {
+	for _, tableCode := range venue.ActiveTables() {
+		if !seatsParty(tableCode, partySize) {
+			continue
+		}
+		conflicts, err := h.reservationsRepo.GetConflicts(ctx, tableCode.Code, start, end, primitive.NilObjectID)
+		if err != nil {
+			return "", err
+		}
+		if len(conflicts) == 0 {
+			return tableCode.Code, nil
+		}
+	}
+	return "", errNoFreeTable
+}
+
+// seatsParty reports whether a table has enough seats for a party. Tables without a seat count
+// on the floor plan seat any party.
+func seatsParty(table structs.TableCode, partySize int) bool {
+	return table.Layout == nil || table.Layout.Seats == 0 || table.Layout.Seats >= partySize
+}
+
+func (h *ReservationsHandler) sendConfirmation(venue *structs.Venue, reservation *structs.Reservation) error {
+	var html bytes.Buffer
+	tmpl := template.Must(template.New("booking-email.html").Funcs(templateFuncs).ParseFiles("templates/booking-email.html"))
+	err := tmpl.Execute(&html, structs.BookingDetailsPage{
+		Title:       "Your booking",
+		Venue:       *venue,
+		Reservation: reservation,
+		Location:    venue.Reservations.Location(),
+		URL:         fmt.Sprintf("%s/book/%s/%s", utils.PublicBaseURL(), venue.ID.Hex(), reservation.Reference),
+	})
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(reservation.Email, fmt.Sprintf("Your booking at %s", venue.Name), html.String())
+}
+
+func (h *ReservationsHandler) getBooking(w http.ResponseWriter, r *http.Request) (*structs.Venue, *structs.Reservation, bool) {
+	venue, ok := h.getVenue(w, r, mux.Vars(r)["venue"])
+	if !ok {
+		return nil, nil, false
+	}
+	reference := strings.ToUpper(mux.Vars(r)["reference"])
+	reservation, err := h.reservationsRepo.GetReservationByReference(r.Context(), venue.ID, reference)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Booking not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching reservation: %v", err)
+		http.Error(w, "Error fetching booking", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return venue, reservation, true
+}
+
+func (h *ReservationsHandler) getReservation(w http.ResponseWriter, r *http.Request) (*structs.Reservation, *structs.Venue, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid reservation id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	reservation, err := h.reservationsRepo.GetReservationByID(r.Context(), id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Reservation not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching reservation: %v", err)
+		http.Error(w, "Error fetching reservation", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), reservation.VenueID)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if session, _ := store.Get(r, "session-name"); !ownsVenue(session, venue) {
+		http.Error(w, "Reservation not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return reservation, venue, true
+}
+
+func (h *ReservationsHandler) getVenue(w http.ResponseWriter, r *http.Request, id string) (*structs.Venue, bool) {
+	venueID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), venueID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, false
+	}
+	return venue, true
+}
+
+// reservationBlock returns the booking that keeps walk-in guests off a table at the given time,
+// and the next booking of the table when it is still free to use.
+func reservationBlock(ctx context.Context, reservationsRepo *repo.ReservationsRepository, venue *structs.Venue, tableCode string, at time.Time) (blocking, upcoming *structs.Reservation) {
+	reservation, err := reservationsRepo.GetNextReservationForTable(ctx, tableCode, at)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			logger.Errorf("error fetching reservations for table %v: %v", tableCode, err)
+		}
+		return nil, nil
+	}
+	if !reservation.StartsAt.Add(-venue.Reservations.Hold()).After(at) {
+		return reservation, nil
+	}
+	return nil, reservation
+}
+
+// venueHasTable reports whether a table of the venue is in use and can be assigned to guests.
+func venueHasTable(venue *structs.Venue, tableCode string) bool {
+	table, ok := venue.Table(tableCode)
+	return ok && !table.Retired
+}
+
+func calendarURL(venue *structs.Venue, reservation *structs.Reservation) string {
+	query := url.Values{
+		"venue": {venue.ID.Hex()},
+		"date":  {reservation.StartsAt.In(venue.Reservations.Location()).Format(dateLayout)},
+	}
+	return "/admin/reservations?" + query.Encode()
+}
+
+// newBookingReference returns a short reference guests can type in at the table.
+func newBookingReference() string {
+	return strings.ToUpper(shortuuid.New()[:8])
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/gorilla/sessions"
+	"github.com/vorticist/logger"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/keycloak"
+	"vortex.studio/account/internal/utils"
+)
+
+const (
+	// sessionMaxAge is how long a staff member stays signed in, in seconds.
+	sessionMaxAge = 12 * 60 * 60
+	// minSessionSecretLength keeps guessable secrets out of SESSION_KEYS.
+	minSessionSecretLength = 32
+	// sessionRecheckInterval is how long the roles of a session are trusted before the account
+	// is looked up in its realm again.
+	sessionRecheckInterval = 5 * time.Minute
+
+	checkedAtSessionKey = "checked_at"
+
+	csrfSessionKey = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// newSessionStore returns the store of admin sessions. Cookies are encrypted with the keys of
+// SESSION_KEYS and are only sent over HTTPS outside of local development.
+func newSessionStore() *sessions.CookieStore {
+	store := sessions.NewCookieStore(sessionKeys()...)
+	store.Options = &sessions.Options{
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		// Lax rather than strict, the session has to come along when Keycloak redirects back to
+		// the login callback
+		SameSite: http.SameSiteLaxMode,
+	}
+	store.MaxAge(sessionMaxAge)
+	return store
+}
+
+// staffAccounts looks staff up in their realm to recheck their sessions, sessions are trusted
+// until they expire when it is nil.
+var staffAccounts *keycloak.AdminClient
+
+// RecheckSessionsWith makes Require and the dashboard look the staff member of a session up in
+// their realm every sessionRecheckInterval, so disabled, removed or demoted staff lose their
+// access within minutes instead of when their cookie expires.
+func RecheckSessionsWith(client *keycloak.AdminClient) {
+	staffAccounts = client
+}
+
+// recheckSession refreshes the roles of a session from the realm once they are older than
+// sessionRecheckInterval. It returns false and signs the session out when the account was
+// disabled or removed. The effective roles are kept, so roles granted through composite roles
+// or groups count. While the realm can't be reached the roles the session has are kept.
+func recheckSession(w http.ResponseWriter, r *http.Request, session *sessions.Session) bool {
+	if staffAccounts == nil {
+		return true
+	}
+	checkedAt, _ := session.Values[checkedAtSessionKey].(int64)
+	if time.Since(time.Unix(checkedAt, 0)) < sessionRecheckInterval {
+		return true
+	}
+
+	realm, userID := sessionTenant(session), sessionUserID(session)
+	user, err := staffAccounts.GetUser(r.Context(), realm, userID)
+	if errors.Is(err, keycloak.ErrNotFound) || (err == nil && !user.Enabled) {
+		logger.Infof("signing out %v, the account was disabled or removed", sessionUser(session))
+		signOut(session)
+		session.Save(r, w)
+		return false
+	}
+	if err != nil {
+		logger.Errorf("error rechecking the account of %v: %v", sessionUser(session), err)
+		return true
+	}
+	realmRoles, err := staffAccounts.GetUserCompositeRealmRoles(r.Context(), realm, userID)
+	if err != nil {
+		logger.Errorf("error rechecking the roles of %v: %v", sessionUser(session), err)
+		return true
+	}
+
+	var roles []string
+	for _, role := range realmRoles {
+		if auth.IsRole(role.Name) {
+			roles = append(roles, role.Name)
+		}
+	}
+	session.Values["roles"] = roles
+	session.Values[checkedAtSessionKey] = time.Now().Unix()
+	if err := session.Save(r, w); err != nil {
+		logger.Errorf("error saving session: %v", err)
+	}
+	return true
+}
+
+// signOut drops the staff member from a session.
+func signOut(session *sessions.Session) {
+	for _, key := range []string{"authenticated", "user_id", "user", "tenant", "roles", checkedAtSessionKey, csrfSessionKey} {
+		delete(session.Values, key)
+	}
+}
+
+// secureCookies reports whether cookies must be restricted to HTTPS.
+func secureCookies() bool {
+	return !utils.IsLocal()
+}
+
+// sessionKeys returns the hash and encryption key pairs of the secrets in SESSION_KEYS, a comma
+// separated list with the current secret first. Cookies are written with the first secret and
+// read with any of them, so a new secret can be put in front of the old one and the old one
+// dropped once the sessions it signed have expired. Without SESSION_KEYS a random secret is used
+// and sessions don't survive restarts.
+func sessionKeys() [][]byte {
+	var keys [][]byte
+	for _, secret := range strings.Split(os.Getenv("SESSION_KEYS"), ",") {
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			continue
+		}
+		if len(secret) < minSessionSecretLength {
+			logger.Errorf("ignoring a SESSION_KEYS secret shorter than %v characters", minSessionSecretLength)
+			continue
+		}
+		keys = append(keys, deriveKey(secret, "session-hash"), deriveKey(secret, "session-encryption"))
+	}
+	if len(keys) > 0 {
+		return keys
+	}
+
+	logger.Infof("SESSION_KEYS is not set, using a random session key")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return [][]byte{deriveKey(string(secret), "session-hash"), deriveKey(string(secret), "session-encryption")}
+}
+
+// deriveKey returns a 32 byte key for one purpose from a configured secret, the encryption key
+// has to be a valid AES key whatever the length of the secret.
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// csrfToken returns the CSRF token of a session, state-changing admin requests have to send it
+// back in the X-CSRF-Token header or the csrf_token form field.
+func csrfToken(session *sessions.Session) string {
+	token, _ := session.Values[csrfSessionKey].(string)
+	return token
+}
+
+// ensureCSRFToken gives a session a CSRF token if it doesn't have one yet, it has to be called
+// before anything is written to the response.
+func ensureCSRFToken(w http.ResponseWriter, r *http.Request, session *sessions.Session) string {
+	if token := csrfToken(session); token != "" {
+		return token
+	}
+	token, err := auth.RandomString()
+	if err != nil {
+		logger.Errorf("error generating csrf token: %v", err)
+		return ""
+	}
+	session.Values[csrfSessionKey] = token
+	if err := session.Save(r, w); err != nil {
+		logger.Errorf("error saving session: %v", err)
+		return ""
+	}
+	return token
+}
+
+// validCSRFToken reports whether a request carries the CSRF token of its session.
+func validCSRFToken(r *http.Request, session *sessions.Session) bool {
+	expected := csrfToken(session)
+	if expected == "" {
+		return false
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.FormValue(csrfFormField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// safeMethod reports whether a request can't change anything and needs no CSRF token.
+func safeMethod(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// CheckCSRF rejects state-changing requests that don't carry the CSRF token of the session. Safe
+// requests are let through and get the token in the X-CSRF-Token response header, for API
+// clients that have no page to read it from.
+func CheckCSRF(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "session-name")
+		if safeMethod(r) {
+			if token := ensureCSRFToken(w, r, session); token != "" {
+				w.Header().Set(csrfHeader, token)
+			}
+			next(w, r)
+			return
+		}
+		if !validCSRFToken(r, session) {
+			logger.Infof("rejected %v %v from %v without a valid csrf token", r.Method, r.URL.Path, sessionUser(session))
+			http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/keycloak"
+)
+
+// recheckRequest returns a request with the session of ana, the waiter of the staff test realm,
+// last checked long ago.
+func recheckRequest(st *staffTest, roles ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/staff", nil)
+	session, _ := store.Get(r, "session-name")
+	session.Values["authenticated"] = true
+	session.Values["tenant"] = staffTestRealm
+	session.Values["user_id"] = st.waiterID
+	session.Values["user"] = "ana"
+	session.Values["roles"] = roles
+	return r
+}
+
+func TestRecheckSession(t *testing.T) {
+	st := newStaffTest(t)
+	staffAccounts = st.client
+	t.Cleanup(func() { staffAccounts = nil })
+
+	// Promoted since signing in, the effective roles of the realm replace the ones of the session
+	manager, err := st.client.GetRealmRole(context.Background(), staffTestRealm, auth.RoleManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.client.AddUserRealmRoles(context.Background(), staffTestRealm, st.waiterID, []keycloak.Role{*manager}); err != nil {
+		t.Fatal(err)
+	}
+	r := recheckRequest(st, auth.RoleWaiter)
+	st.seen = len(st.fake.Requests())
+	if !recheckSession(httptest.NewRecorder(), r, mustSession(r)) {
+		t.Fatal("recheck signed out an enabled account")
+	}
+	want := []string{
+		"GET " + st.userPath(st.waiterID),
+		"GET " + st.userPath(st.waiterID, "role-mappings", "realm", "composite"),
+	}
+	if got := st.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+	roles := sessionRoles(mustSession(r))
+	if !auth.Allowed(roles, auth.ManageTables) || len(roles) != 2 {
+		t.Errorf("roles = %v, want waiter and manager", roles)
+	}
+
+	// Checked recently, the realm isn't asked again
+	st.seen = len(st.fake.Requests())
+	if !recheckSession(httptest.NewRecorder(), r, mustSession(r)) || len(st.requests()) != 0 {
+		t.Errorf("recent session was rechecked with %v", st.requests())
+	}
+
+	// Disabled, Require signs the session out
+	user, err := st.client.GetUser(context.Background(), staffTestRealm, st.waiterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Enabled = false
+	if err := st.client.UpdateUser(context.Background(), staffTestRealm, *user); err != nil {
+		t.Fatal(err)
+	}
+	r = recheckRequest(st, auth.RoleWaiter)
+	w := httptest.NewRecorder()
+	Require(auth.ViewDashboard, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("disabled account was let through")
+	})(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	if _, ok := mustSession(r).Values["authenticated"]; ok {
+		t.Error("disabled account is still signed in")
+	}
+}
+
+func mustSession(r *http.Request) *sessions.Session {
+	session, _ := store.Get(r, "session-name")
+	return session
+}
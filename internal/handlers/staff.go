@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/vorticist/logger"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/keycloak"
+	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/utils"
+)
+
+var (
+	errStaffNotConfigured = errors.New("staff management is not configured")
+	errOwnAccount         = errors.New("you can't change your own account")
+	errUnknownRole        = errors.New("unknown role")
+	errMissingStaffFields = errors.New("a username and an email are required")
+)
+
+// inviteActions are what invited staff are asked to do before they can sign in.
+var inviteActions = []string{"VERIFY_EMAIL", "UPDATE_PASSWORD"}
+
+// StaffHandler manages the staff users of a tenant in its Keycloak realm, through admin pages
+// and a JSON API.
+type StaffHandler struct {
+	keycloak *keycloak.AdminClient
+	clientID string
+}
+
+func NewStaffHandler(keycloakClient *keycloak.AdminClient, clientID string) *StaffHandler {
+	return &StaffHandler{
+		keycloak: keycloakClient,
+		clientID: clientID,
+	}
+}
+
+func (h *StaffHandler) AdminStaffHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	members, err := h.members(r.Context(), sessionTenant(session))
+	if err != nil {
+		status, message := staffErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	staffPage := structs.StaffPage{
+		Title:     "Staff",
+		Members:   members,
+		Roles:     auth.Roles,
+		UserID:    sessionUserID(session),
+		Error:     r.FormValue("error"),
+		Notice:    r.FormValue("notice"),
+		CSRFToken: csrfToken(session),
+	}
+	tmpl := template.Must(template.New("admin-staff.html").Funcs(templateFuncs).ParseFiles("templates/admin-staff.html"))
+	err = tmpl.Execute(w, staffPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *StaffHandler) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	invite := structs.StaffInvite{
+		Username:  r.FormValue("username"),
+		Email:     r.FormValue("email"),
+		FirstName: r.FormValue("firstName"),
+		LastName:  r.FormValue("lastName"),
+		Role:      r.FormValue("role"),
+	}
+	member, emailed, err := h.invite(r.Context(), sessionTenant(session), invite)
+	if err != nil {
+		_, message := staffErrorStatus(err)
+		audit.Discard(r.Context())
+		http.Redirect(w, r, staffURL("error", message), http.StatusSeeOther)
+		return
+	}
+	if !emailed {
+		http.Redirect(w, r, staffURL("notice", member.Username+" was added but the invite email couldn't be sent"), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, staffURL("notice", "An invite was emailed to "+member.Email), http.StatusSeeOther)
+}
+
+func (h *StaffHandler) RoleHandler(w http.ResponseWriter, r *http.Request) {
+	role := r.FormValue("role")
+	h.updateFromForm(w, r, structs.StaffUpdate{Role: &role})
+}
+
+func (h *StaffHandler) EnabledHandler(w http.ResponseWriter, r *http.Request) {
+	enabled := r.FormValue("enabled") == "on"
+	h.updateFromForm(w, r, structs.StaffUpdate{Enabled: &enabled})
+}
+
+func (h *StaffHandler) RemoveHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	if err := h.remove(r.Context(), session, mux.Vars(r)["id"]); err != nil {
+		_, message := staffErrorStatus(err)
+		audit.Discard(r.Context())
+		http.Redirect(w, r, staffURL("error", message), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin/staff", http.StatusSeeOther)
+}
+
+func (h *StaffHandler) updateFromForm(w http.ResponseWriter, r *http.Request, update structs.StaffUpdate) {
+	session, _ := store.Get(r, "session-name")
+	if _, err := h.update(r.Context(), session, mux.Vars(r)["id"], update); err != nil {
+		_, message := staffErrorStatus(err)
+		audit.Discard(r.Context())
+		http.Redirect(w, r, staffURL("error", message), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin/staff", http.StatusSeeOther)
+}
+
+// APIStaffHandler lists the staff of the tenant on GET and invites a new member on POST.
+func (h *StaffHandler) APIStaffHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	if r.Method == http.MethodGet {
+		members, err := h.members(r.Context(), sessionTenant(session))
+		if err != nil {
+			status, message := staffErrorStatus(err)
+			http.Error(w, message, status)
+			return
+		}
+		writeStaffJSON(w, http.StatusOK, members)
+		return
+	}
+
+	var invite structs.StaffInvite
+	if err := json.NewDecoder(r.Body).Decode(&invite); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	member, _, err := h.invite(r.Context(), sessionTenant(session), invite)
+	if err != nil {
+		status, message := staffErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+	writeStaffJSON(w, http.StatusCreated, member)
+}
+
+// APIStaffMemberHandler updates a staff member on PATCH and removes them on DELETE.
+func (h *StaffHandler) APIStaffMemberHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	id := mux.Vars(r)["id"]
+
+	if r.Method == http.MethodDelete {
+		if err := h.remove(r.Context(), session, id); err != nil {
+			status, message := staffErrorStatus(err)
+			http.Error(w, message, status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var update structs.StaffUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	member, err := h.update(r.Context(), session, id, update)
+	if err != nil {
+		status, message := staffErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+	writeStaffJSON(w, http.StatusOK, member)
+}
+
+// members returns the users of the realm with their staff role.
+func (h *StaffHandler) members(ctx context.Context, realm string) ([]structs.StaffMember, error) {
+	if h.keycloak == nil {
+		return nil, errStaffNotConfigured
+	}
+
+	users, err := h.keycloak.ListUsers(ctx, realm)
+	if err != nil {
+		return nil, err
+	}
+	roles := map[string]string{}
+	// Roles are listed from the least privileged so users with several keep the highest one
+	for i := len(auth.Roles) - 1; i >= 0; i-- {
+		roleUsers, err := h.keycloak.GetRoleUsers(ctx, realm, auth.Roles[i])
+		if errors.Is(err, keycloak.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range roleUsers {
+			roles[user.ID] = auth.Roles[i]
+		}
+	}
+
+	members := make([]structs.StaffMember, 0, len(users))
+	for _, user := range users {
+		members = append(members, staffMember(user, roles[user.ID]))
+	}
+	return members, nil
+}
+
+// invite creates an enabled user with a staff role and emails them a link to set up their
+// account. The member is created even when the email can't be sent.
+func (h *StaffHandler) invite(ctx context.Context, realm string, invite structs.StaffInvite) (*structs.StaffMember, bool, error) {
+	if h.keycloak == nil {
+		return nil, false, errStaffNotConfigured
+	}
+	invite.Username = strings.ToLower(strings.TrimSpace(invite.Username))
+	invite.Email = strings.TrimSpace(invite.Email)
+	if invite.Username == "" || invite.Email == "" {
+		return nil, false, errMissingStaffFields
+	}
+	if !auth.IsRole(invite.Role) {
+		return nil, false, errUnknownRole
+	}
+
+	user := keycloak.User{
+		Username:        invite.Username,
+		Email:           invite.Email,
+		FirstName:       strings.TrimSpace(invite.FirstName),
+		LastName:        strings.TrimSpace(invite.LastName),
+		Enabled:         true,
+		RequiredActions: inviteActions,
+	}
+	id, err := h.keycloak.CreateUser(ctx, realm, user)
+	if err != nil {
+		return nil, false, err
+	}
+	user.ID = id
+	if err := h.setRole(ctx, realm, id, invite.Role); err != nil {
+		return nil, false, err
+	}
+
+	emailed := true
+	if err := h.keycloak.SendActionsEmail(ctx, realm, id, inviteActions, h.clientID, utils.PublicBaseURL()+"/admin"); err != nil {
+		logger.Errorf("error emailing invite to %v: %v", invite.Username, err)
+		emailed = false
+	}
+
+	member := staffMember(user, invite.Role)
+	audit.Describe(ctx, "staff.invite", member.Username)
+	audit.After(ctx, member)
+	return &member, emailed, nil
+}
+
+func (h *StaffHandler) update(ctx context.Context, session *sessions.Session, id string, update structs.StaffUpdate) (*structs.StaffMember, error) {
+	if h.keycloak == nil {
+		return nil, errStaffNotConfigured
+	}
+	if id == sessionUserID(session) {
+		return nil, errOwnAccount
+	}
+	// Everything is checked before anything changes, a bad update leaves the user as it was
+	if update.Role != nil && *update.Role != "" && !auth.IsRole(*update.Role) {
+		return nil, errUnknownRole
+	}
+	realm := sessionTenant(session)
+
+	user, err := h.keycloak.GetUser(ctx, realm, id)
+	if err != nil {
+		return nil, err
+	}
+	previousRole, err := h.staffRole(ctx, realm, id)
+	if err != nil {
+		return nil, err
+	}
+	audit.Before(ctx, staffMember(*user, previousRole))
+	if update.Enabled != nil && *update.Enabled != user.Enabled {
+		user.Enabled = *update.Enabled
+		if err := h.keycloak.UpdateUser(ctx, realm, *user); err != nil {
+			return nil, err
+		}
+		if !user.Enabled {
+			h.logout(ctx, realm, user.ID)
+		}
+	}
+	if update.Role != nil {
+		if err := h.setRole(ctx, realm, id, *update.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	role, err := h.staffRole(ctx, realm, id)
+	if err != nil {
+		return nil, err
+	}
+	member := staffMember(*user, role)
+	audit.Describe(ctx, "staff.update", member.Username)
+	audit.After(ctx, member)
+	return &member, nil
+}
+
+func (h *StaffHandler) remove(ctx context.Context, session *sessions.Session, id string) error {
+	if h.keycloak == nil {
+		return errStaffNotConfigured
+	}
+	if id == sessionUserID(session) {
+		return errOwnAccount
+	}
+	realm := sessionTenant(session)
+
+	audit.Describe(ctx, "staff.remove", id)
+	if user, err := h.keycloak.GetUser(ctx, realm, id); err == nil {
+		audit.Describe(ctx, "staff.remove", user.Username)
+		audit.Before(ctx, staffMember(*user, ""))
+	}
+	h.logout(ctx, realm, id)
+	return h.keycloak.DeleteUser(ctx, realm, id)
+}
+
+// logout ends the realm sessions of a user who lost their access. Failures are only logged, the
+// sessions of this service are rechecked with the realm on their own.
+func (h *StaffHandler) logout(ctx context.Context, realm, id string) {
+	if err := h.keycloak.LogoutUser(ctx, realm, id); err != nil {
+		logger.Errorf("error signing out user %v: %v", id, err)
+	}
+}
+
+// setRole makes role the only staff role of a user, an empty role removes their access.
+func (h *StaffHandler) setRole(ctx context.Context, realm, userID, role string) error {
+	current, err := h.keycloak.GetUserRealmRoles(ctx, realm, userID)
+	if err != nil {
+		return err
+	}
+
+	var remove []keycloak.Role
+	hasRole := false
+	for _, r := range current {
+		if r.Name == role {
+			hasRole = true
+		} else if auth.IsRole(r.Name) {
+			remove = append(remove, r)
+		}
+	}
+	if len(remove) > 0 {
+		if err := h.keycloak.RemoveUserRealmRoles(ctx, realm, userID, remove); err != nil {
+			return err
+		}
+	}
+	if role == "" || hasRole {
+		return nil
+	}
+
+	realmRole, err := h.keycloak.GetRealmRole(ctx, realm, role)
+	if err != nil {
+		return err
+	}
+	return h.keycloak.AddUserRealmRoles(ctx, realm, userID, []keycloak.Role{*realmRole})
+}
+
+func (h *StaffHandler) staffRole(ctx context.Context, realm, userID string) (string, error) {
+	roles, err := h.keycloak.GetUserRealmRoles(ctx, realm, userID)
+	if err != nil {
+		return "", err
+	}
+	for _, role := range auth.Roles {
+		for _, r := range roles {
+			if r.Name == role {
+				return role, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func staffMember(user keycloak.User, role string) structs.StaffMember {
+	return structs.StaffMember{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Role:      role,
+		Enabled:   user.Enabled,
+		Pending:   len(user.RequiredActions) > 0,
+	}
+}
+
+// staffErrorStatus maps an error of a staff operation to the response status and the message
+// shown to the user.
+func staffErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errStaffNotConfigured):
+		return http.StatusServiceUnavailable, "Staff management is not available right now"
+	case errors.Is(err, errOwnAccount):
+		return http.StatusBadRequest, "You can't change your own account"
+	case errors.Is(err, errUnknownRole):
+		return http.StatusBadRequest, "Unknown role"
+	case errors.Is(err, errMissingStaffFields):
+		return http.StatusBadRequest, "A username and an email are required"
+	case errors.Is(err, keycloak.ErrConflict):
+		return http.StatusConflict, "A user with this username or email already exists"
+	case errors.Is(err, keycloak.ErrNotFound):
+		return http.StatusNotFound, "Staff member not found"
+	}
+	logger.Errorf("error managing staff: %v", err)
+	return http.StatusInternalServerError, "Error managing staff"
+}
+
+func staffURL(key, message string) string {
+	return "/admin/staff?" + url.Values{key: {message}}.Encode()
+}
+
+func writeStaffJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf("error encoding response: %v", err)
+	}
+}
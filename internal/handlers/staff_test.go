@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/keycloak"
+	"vortex.studio/account/internal/structs"
+)
+
+const staffTestRealm = "acme"
+
+// staffTest is a staff handler backed by a fake Keycloak with a realm of an owner, who makes the
+// requests, and a waiter.
+type staffTest struct {
+	handler  *StaffHandler
+	fake     *keycloak.FakeServer
+	client   *keycloak.AdminClient
+	ownerID  string
+	waiterID string
+	// seen is how many admin API calls were made before the request under test
+	seen int
+}
+
+func newStaffTest(t *testing.T) *staffTest {
+	t.Helper()
+	fake := keycloak.NewFakeServer("admin-cli", "secret")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := keycloak.NewAdminClient(server.URL, "admin-cli", "secret")
+
+	var roles []keycloak.Role
+	for _, role := range auth.Roles {
+		roles = append(roles, keycloak.Role{Name: role})
+	}
+	err := client.CreateRealm(context.Background(), keycloak.Realm{
+		Realm:   staffTestRealm,
+		Enabled: true,
+		Roles:   &keycloak.RealmRoles{Realm: roles},
+		Users: []keycloak.User{
+			{Username: "owner", Email: "owner@example.com", Enabled: true, RealmRoles: []string{auth.RoleOwner}},
+			{Username: "ana", Email: "ana@example.com", Enabled: true, RealmRoles: []string{auth.RoleWaiter}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("creating realm: %v", err)
+	}
+	users, err := client.ListUsers(context.Background(), staffTestRealm)
+	if err != nil {
+		t.Fatalf("listing users: %v", err)
+	}
+
+	st := &staffTest{handler: NewStaffHandler(client, "the-account"), fake: fake, client: client}
+	for _, user := range users {
+		switch user.Username {
+		case "owner":
+			st.ownerID = user.ID
+		case "ana":
+			st.waiterID = user.ID
+		}
+	}
+	return st
+}
+
+// do runs a request to the staff API as the owner and returns the response.
+func (st *staffTest) do(t *testing.T, handler http.HandlerFunc, method, target, id string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, &payload)
+	if id != "" {
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+	}
+	session, _ := store.Get(r, "session-name")
+	session.Values["authenticated"] = true
+	session.Values["tenant"] = staffTestRealm
+	session.Values["user_id"] = st.ownerID
+	session.Values["user"] = "owner"
+
+	st.seen = len(st.fake.Requests())
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// requests returns the admin API calls made by the last request.
+func (st *staffTest) requests() []string {
+	return st.fake.Requests()[st.seen:]
+}
+
+func (st *staffTest) userPath(id string, parts ...string) string {
+	return strings.Join(append([]string{"/admin/realms", staffTestRealm, "users", id}, parts...), "/")
+}
+
+func decodeMember(t *testing.T, w *httptest.ResponseRecorder) structs.StaffMember {
+	t.Helper()
+	var member structs.StaffMember
+	if err := json.NewDecoder(w.Body).Decode(&member); err != nil {
+		t.Fatalf("decoding member: %v", err)
+	}
+	return member
+}
+
+func TestStaffInvite(t *testing.T) {
+	st := newStaffTest(t)
+
+	invite := structs.StaffInvite{Username: " Luis ", Email: "luis@example.com", Role: auth.RoleKitchen}
+	w := st.do(t, st.handler.APIStaffHandler, http.MethodPost, "/api/staff", "", invite)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	member := decodeMember(t, w)
+	if member.Username != "luis" || member.Role != auth.RoleKitchen || !member.Enabled || !member.Pending {
+		t.Errorf("member = %+v", member)
+	}
+
+	want := []string{
+		"POST /admin/realms/acme/users",
+		"GET " + st.userPath(member.ID, "role-mappings", "realm"),
+		"GET /admin/realms/acme/roles/kitchen",
+		"POST " + st.userPath(member.ID, "role-mappings", "realm"),
+		"PUT " + st.userPath(member.ID, "execute-actions-email"),
+	}
+	if got := st.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	emails := st.fake.ActionsEmails()
+	if len(emails) != 1 {
+		t.Fatalf("sent %v actions emails, want 1", len(emails))
+	}
+	if emails[0].UserID != member.ID || !reflect.DeepEqual(emails[0].Actions, inviteActions) || emails[0].ClientID != "the-account" || !strings.HasSuffix(emails[0].RedirectURI, "/admin") {
+		t.Errorf("actions email = %+v", emails[0])
+	}
+}
+
+func TestStaffInviteErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		invite   structs.StaffInvite
+		status   int
+		requests int
+	}{
+		{"missing email", structs.StaffInvite{Username: "luis", Role: auth.RoleWaiter}, http.StatusBadRequest, 0},
+		{"unknown role", structs.StaffInvite{Username: "luis", Email: "luis@example.com", Role: "chef"}, http.StatusBadRequest, 0},
+		{"taken username", structs.StaffInvite{Username: "Ana", Email: "other@example.com", Role: auth.RoleWaiter}, http.StatusConflict, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := newStaffTest(t)
+			w := st.do(t, st.handler.APIStaffHandler, http.MethodPost, "/api/staff", "", test.invite)
+			if w.Code != test.status {
+				t.Errorf("status = %v, want %v: %s", w.Code, test.status, w.Body)
+			}
+			if got := st.requests(); len(got) != test.requests {
+				t.Errorf("requests = %v, want %v of them", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestStaffUpdateRole(t *testing.T) {
+	st := newStaffTest(t)
+
+	role := auth.RoleManager
+	w := st.do(t, st.handler.APIStaffMemberHandler, http.MethodPatch, "/api/staff/"+st.waiterID, st.waiterID, structs.StaffUpdate{Role: &role})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if member := decodeMember(t, w); member.Role != auth.RoleManager {
+		t.Errorf("role = %q, want %q", member.Role, auth.RoleManager)
+	}
+
+	mappings := st.userPath(st.waiterID, "role-mappings", "realm")
+	want := []string{
+		"GET " + st.userPath(st.waiterID),
+		"GET " + mappings,
+		"GET " + mappings,
+		"DELETE " + mappings,
+		"GET /admin/realms/acme/roles/manager",
+		"POST " + mappings,
+		"GET " + mappings,
+	}
+	if got := st.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStaffUpdateErrors(t *testing.T) {
+	enabled := false
+	unknown := "chef"
+	tests := []struct {
+		name   string
+		id     func(st *staffTest) string
+		update structs.StaffUpdate
+		status int
+	}{
+		{"unknown role", func(st *staffTest) string { return st.waiterID }, structs.StaffUpdate{Role: &unknown, Enabled: &enabled}, http.StatusBadRequest},
+		{"own account", func(st *staffTest) string { return st.ownerID }, structs.StaffUpdate{Enabled: &enabled}, http.StatusBadRequest},
+		{"unknown user", func(st *staffTest) string { return "missing" }, structs.StaffUpdate{Enabled: &enabled}, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := newStaffTest(t)
+			id := test.id(st)
+			w := st.do(t, st.handler.APIStaffMemberHandler, http.MethodPatch, "/api/staff/"+id, id, test.update)
+			if w.Code != test.status {
+				t.Errorf("status = %v, want %v: %s", w.Code, test.status, w.Body)
+			}
+			// Nothing is changed when any part of the update is rejected
+			for _, request := range st.requests() {
+				if !strings.HasPrefix(request, "GET ") {
+					t.Errorf("rejected update made the change %v", request)
+				}
+			}
+		})
+	}
+}
+
+func TestStaffDisable(t *testing.T) {
+	st := newStaffTest(t)
+
+	enabled := false
+	w := st.do(t, st.handler.APIStaffMemberHandler, http.MethodPatch, "/api/staff/"+st.waiterID, st.waiterID, structs.StaffUpdate{Enabled: &enabled})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if member := decodeMember(t, w); member.Enabled || member.Role != auth.RoleWaiter {
+		t.Errorf("member = %+v", member)
+	}
+
+	want := []string{
+		"GET " + st.userPath(st.waiterID),
+		"GET " + st.userPath(st.waiterID, "role-mappings", "realm"),
+		"PUT " + st.userPath(st.waiterID),
+		"POST " + st.userPath(st.waiterID, "logout"),
+		"GET " + st.userPath(st.waiterID, "role-mappings", "realm"),
+	}
+	if got := st.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	user, err := st.client.GetUser(context.Background(), staffTestRealm, st.waiterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Enabled {
+		t.Error("user is still enabled")
+	}
+}
+
+func TestStaffRemove(t *testing.T) {
+	st := newStaffTest(t)
+
+	w := st.do(t, st.handler.APIStaffMemberHandler, http.MethodDelete, "/api/staff/"+st.waiterID, st.waiterID, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	want := []string{
+		"GET " + st.userPath(st.waiterID),
+		"POST " + st.userPath(st.waiterID, "logout"),
+		"DELETE " + st.userPath(st.waiterID),
+	}
+	if got := st.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	w = st.do(t, st.handler.APIStaffMemberHandler, http.MethodDelete, "/api/staff/"+st.waiterID, st.waiterID, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("removing again: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	w = st.do(t, st.handler.APIStaffMemberHandler, http.MethodDelete, "/api/staff/"+st.ownerID, st.ownerID, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("removing own account: status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if got := st.requests(); len(got) != 0 {
+		t.Errorf("removing own account made requests %v", got)
+	}
+}
+
+func TestStaffNotConfigured(t *testing.T) {
+	st := &staffTest{handler: NewStaffHandler(nil, "the-account"), fake: keycloak.NewFakeServer("", "")}
+	w := st.do(t, st.handler.APIStaffHandler, http.MethodGet, "/api/staff", "", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"image/color"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/qr"
+	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/tentcards"
+	"vortex.studio/account/internal/utils"
+)
+
+const (
+	// maxNewTables is the most tables that can be added to a venue at once.
+	maxNewTables = 100
+	// minQRContrast is how much lighter than the foreground the background of QR codes must be.
+	minQRContrast = 0.4
+)
+
+// newTableCode returns a table of the venue with a new random code.
+func newTableCode(venue *structs.Venue, label, section string) structs.TableCode {
+	code := shortuuid.New()
+	return structs.TableCode{
+		Code:    code,
+		Label:   label,
+		Section: section,
+		CodeUrl: tableURL(venueBaseURL(venue), code),
+	}
+}
+
+// newTables returns tables to add to a venue, numbered on from its last table. Retired tables
+// count so their numbers aren't given out again.
+func newTables(venue *structs.Venue, howMany int, section string) []structs.TableCode {
+	last := len(venue.TableCodes)
+	for _, table := range venue.TableCodes {
+		if number, err := strconv.Atoi(table.Label); err == nil && number > last {
+			last = number
+		}
+	}
+
+	var tables []structs.TableCode
+	for i := 1; i <= howMany; i++ {
+		tables = append(tables, newTableCode(venue, strconv.Itoa(last+i), section))
+	}
+	return tables
+}
+
+// venueBaseURL returns the URL the QR codes of a venue point at, its own domain when it has one.
+func venueBaseURL(venue *structs.Venue) string {
+	if venue.QR.Domain != "" {
+		return venue.QR.Domain
+	}
+	return utils.PublicBaseURL()
+}
+
+func tableURL(baseURL, code string) string {
+	return fmt.Sprintf("%v/table/%s", baseURL, code)
+}
+
+// labelTaken reports whether a table of the venue other than code already uses the label.
+func labelTaken(venue *structs.Venue, code, label string) bool {
+	for _, table := range venue.ActiveTables() {
+		if table.Code != code && strings.EqualFold(table.Name(), label) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTablesHandler adds numbered tables to a venue without touching its existing tables.
+func (h *AdminHandler) AddTablesHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil || count < 1 || count > maxNewTables {
+		http.Error(w, fmt.Sprintf("Number of tables must be between 1 and %v", maxNewTables), http.StatusBadRequest)
+		return
+	}
+	tables := newTables(venue, count, strings.TrimSpace(r.FormValue("section")))
+	if _, err := h.venueRepo.AddTables(r.Context(), venue.ID, tables); err != nil {
+		logger.Errorf("error adding tables: %v", err)
+		http.Error(w, "Error adding tables", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "table.add", venueTarget(venue))
+	audit.After(r.Context(), tables)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// RenameTableHandler changes the label and section of a table, its code and QR code stay valid.
+func (h *AdminHandler) RenameTableHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, table, ok := h.getTable(w, r)
+	if !ok {
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		http.Error(w, "Tables need a label", http.StatusBadRequest)
+		return
+	}
+	if !table.Retired && labelTaken(venue, table.Code, label) {
+		http.Error(w, fmt.Sprintf("Another table is already called %v", label), http.StatusConflict)
+		return
+	}
+	section := strings.TrimSpace(r.FormValue("section"))
+	if _, err := h.venueRepo.RenameTable(r.Context(), venue.ID, table.Code, label, section); err != nil {
+		logger.Errorf("error renaming table: %v", err)
+		http.Error(w, "Error renaming table", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "table.rename", tableTarget(venue, table))
+	audit.Before(r.Context(), table)
+	table.Label, table.Section = label, section
+	audit.After(r.Context(), table)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// RegenerateTableHandler gives a table a new code, for when its QR code has been copied or
+// taken. The old code stops working and the bookings of the table move to the new one.
+func (h *AdminHandler) RegenerateTableHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, table, ok := h.getTable(w, r)
+	if !ok || !h.checkTableFree(w, table) {
+		return
+	}
+
+	regenerated := newTableCode(venue, table.Label, table.Section)
+	if _, err := h.venueRepo.ReplaceTableCode(r.Context(), venue.ID, table.Code, regenerated.Code, regenerated.CodeUrl); err != nil {
+		logger.Errorf("error replacing table code: %v", err)
+		http.Error(w, "Error replacing table code", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.reservationsRepo.MoveTableReservations(r.Context(), table.Code, regenerated.Code); err != nil {
+		logger.Errorf("error moving reservations of table %v to %v: %v", table.Code, regenerated.Code, err)
+	}
+	audit.Describe(r.Context(), "table.regenerate", tableTarget(venue, table))
+	audit.Before(r.Context(), table)
+	table.Code, table.CodeUrl = regenerated.Code, regenerated.CodeUrl
+	audit.After(r.Context(), table)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// RetireTableHandler takes a table out of use. Its code stops opening sessions but the table is
+// kept, so the history of the venue still knows it.
+func (h *AdminHandler) RetireTableHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, table, ok := h.getTable(w, r)
+	if !ok || !h.checkTableFree(w, table) {
+		return
+	}
+
+	upcoming, err := h.reservationsRepo.GetNextReservationForTable(r.Context(), table.Code, time.Now())
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching reservations: %v", err)
+		http.Error(w, "Error fetching reservations", http.StatusInternalServerError)
+		return
+	}
+	if upcoming != nil {
+		http.Error(w, "The table has upcoming bookings, move them to another table first", http.StatusConflict)
+		return
+	}
+
+	if _, err := h.venueRepo.SetTableRetired(r.Context(), venue.ID, table.Code, true); err != nil {
+		logger.Errorf("error retiring table: %v", err)
+		http.Error(w, "Error retiring table", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "table.retire", tableTarget(venue, table))
+	audit.Before(r.Context(), table)
+	table.Retired = true
+	audit.After(r.Context(), table)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// RestoreTableHandler puts a retired table back in use with its old code.
+func (h *AdminHandler) RestoreTableHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, table, ok := h.getTable(w, r)
+	if !ok {
+		return
+	}
+	if labelTaken(venue, table.Code, table.Name()) {
+		http.Error(w, fmt.Sprintf("Another table is already called %v, rename it first", table.Name()), http.StatusConflict)
+		return
+	}
+
+	if _, err := h.venueRepo.SetTableRetired(r.Context(), venue.ID, table.Code, false); err != nil {
+		logger.Errorf("error restoring table: %v", err)
+		http.Error(w, "Error restoring table", http.StatusInternalServerError)
+		return
+	}
+	audit.Describe(r.Context(), "table.restore", tableTarget(venue, table))
+	audit.Before(r.Context(), table)
+	table.Retired = false
+	audit.After(r.Context(), table)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// TentCardsHandler downloads a PDF with a folding tent card for every table of a venue, with
+// its QR code, label and the venue name and image.
+func (h *AdminHandler) TentCardsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok || !h.checkHasTables(w, venue) {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tentcards.WritePDF(&buf, venue, h.venueLogo(r.Context(), venue)); err != nil {
+		logger.Errorf("error rendering tent cards: %v", err)
+		http.Error(w, "Error rendering tent cards", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-tent-cards.pdf"`, makeURLSafe(venue.Name)))
+	w.Write(buf.Bytes())
+}
+
+// QRCodesHandler downloads a ZIP with the QR code of every table of a venue as PNG and SVG.
+func (h *AdminHandler) QRCodesHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok || !h.checkHasTables(w, venue) {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tentcards.WriteZIP(&buf, venue, h.venueLogo(r.Context(), venue)); err != nil {
+		logger.Errorf("error rendering qr codes: %v", err)
+		http.Error(w, "Error rendering QR codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-qr-codes.zip"`, makeURLSafe(venue.Name)))
+	w.Write(buf.Bytes())
+}
+
+// QRSettingsHandler saves how the QR codes of a venue look and which domain they point at.
+// Changing the domain updates the URL of every table, printed codes keep working as long as the
+// old domain still reaches the service.
+func (h *AdminHandler) QRSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := parseQRSettings(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if settings.Logo && venue.Image == "" {
+		http.Error(w, "Upload an image of the venue to put it on the QR codes", http.StatusBadRequest)
+		return
+	}
+
+	audit.Describe(r.Context(), "venue.qr", venueTarget(venue))
+	audit.Before(r.Context(), venue.QR)
+	venue.QR = settings
+	if _, err := h.venueRepo.SetQRSettings(r.Context(), venue.ID, settings, venueBaseURL(venue)); err != nil {
+		logger.Errorf("error saving qr settings: %v", err)
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	audit.After(r.Context(), settings)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// parseQRSettings reads the QR settings form, an empty field keeps the default.
+func parseQRSettings(r *http.Request) (structs.QRSettings, error) {
+	settings := structs.QRSettings{
+		Recovery: r.FormValue("recovery"),
+		Logo:     r.FormValue("logo") == "on",
+	}
+
+	if domain := strings.TrimSpace(r.FormValue("domain")); domain != "" {
+		u, err := url.Parse(domain)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+			return settings, errors.New("The domain must be a URL like https://menu.example.com")
+		}
+		settings.Domain = u.Scheme + "://" + u.Host
+	}
+
+	if size := r.FormValue("size"); size != "" {
+		pixels, err := strconv.Atoi(size)
+		if err != nil || pixels < qr.MinSize || pixels > qr.MaxSize {
+			return settings, fmt.Errorf("The size must be between %v and %v pixels", qr.MinSize, qr.MaxSize)
+		}
+		settings.Size = pixels
+	}
+
+	if settings.Recovery != "" {
+		if _, ok := qr.ParseRecovery(settings.Recovery); !ok {
+			return settings, fmt.Errorf("Unknown error correction level %v", settings.Recovery)
+		}
+	}
+
+	foreground, background := color.Color(color.Black), color.Color(color.White)
+	if hex := r.FormValue("foreground"); hex != "" {
+		c, err := qr.ParseColor(hex)
+		if err != nil {
+			return settings, errors.New("The foreground color must look like #000000")
+		}
+		settings.Foreground, foreground = hex, c
+	}
+	if hex := r.FormValue("background"); hex != "" {
+		c, err := qr.ParseColor(hex)
+		if err != nil {
+			return settings, errors.New("The background color must look like #ffffff")
+		}
+		settings.Background, background = hex, c
+	}
+	// Many phones only read dark codes on a light background
+	if qr.Luminance(background)-qr.Luminance(foreground) < minQRContrast {
+		return settings, errors.New("The foreground color must be much darker than the background for phones to read the code")
+	}
+
+	return settings, nil
+}
+
+func (h *AdminHandler) checkHasTables(w http.ResponseWriter, venue *structs.Venue) bool {
+	if len(venue.ActiveTables()) == 0 {
+		http.Error(w, "The venue has no tables yet", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// venueLogo returns the image of a venue to print on its tent cards and QR codes, nil when it
+// has none.
+func (h *AdminHandler) venueLogo(ctx context.Context, venue *structs.Venue) []byte {
+	if venue.Image == "" {
+		return nil
+	}
+	return readImage(ctx, h.images, venue.Image)
+}
+
+// getTable loads the venue and table referenced by the "id" and "code" route variables, writing
+// the error response itself when it can't.
+func (h *AdminHandler) getTable(w http.ResponseWriter, r *http.Request) (*structs.Venue, *structs.TableCode, bool) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	table, ok := venue.Table(mux.Vars(r)["code"])
+	if !ok {
+		http.Error(w, "Table not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return venue, table, true
+}
+
+// checkTableFree makes sure nobody is sitting at a table before its code changes.
+func (h *AdminHandler) checkTableFree(w http.ResponseWriter, table *structs.TableCode) bool {
+	_, err := h.tablesRepo.GetSessionForTable(table.Code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return true
+	}
+	if err != nil {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return false
+	}
+	http.Error(w, "The table has an open session, close it first", http.StatusConflict)
+	return false
+}
@@ -1,32 +1,63 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/mailer"
+	"vortex.studio/account/internal/payments"
+	"vortex.studio/account/internal/pubsub"
+	"vortex.studio/account/internal/receipts"
 	"vortex.studio/account/internal/repo"
 	"vortex.studio/account/internal/structs"
 )
 
+// sessionGoneMessage answers guests changing a session that was closed, or moved or merged
+// to another table, since they loaded the page.
+const sessionGoneMessage = "This table's session has changed, please scan the code again"
+
+// errSessionChanged is returned when closing a session that was closed already or ordered at
+// since it was read.
+var errSessionChanged = errors.New("session was closed or changed")
+
 type TableHandler struct {
-	tablesRepo *repo.ActiveTablesRepository
-	venuesRepo *repo.VenueRepository
-	eventsRepo *repo.EventsRepo
-	menuRepo   *repo.MenuRepository
+	tablesRepo       *repo.ActiveTablesRepository
+	venuesRepo       *repo.VenueRepository
+	eventsRepo       *repo.EventsRepo
+	menuRepo         *repo.MenuRepository
+	guestsRepo       *repo.GuestProfilesRepository
+	loyaltyRepo      *repo.LoyaltyRepository
+	reservationsRepo *repo.ReservationsRepository
+	printJobsRepo    *repo.PrintJobsRepository
+	mailer           mailer.Sender
+	payments         payments.PaymentProvider
+	events           *sessionEvents
 }
 
-func NewTablesHandler(venueRepo *repo.VenueRepository, activeTablesRepo *repo.ActiveTablesRepository, eventsRepo *repo.EventsRepo, menuRepo *repo.MenuRepository) *TableHandler {
+func NewTablesHandler(venueRepo *repo.VenueRepository, activeTablesRepo *repo.ActiveTablesRepository, eventsRepo *repo.EventsRepo, menuRepo *repo.MenuRepository, guestsRepo *repo.GuestProfilesRepository, loyaltyRepo *repo.LoyaltyRepository, reservationsRepo *repo.ReservationsRepository, printJobsRepo *repo.PrintJobsRepository, mailer mailer.Sender, payments payments.PaymentProvider, bus pubsub.Bus) *TableHandler {
 	return &TableHandler{
-		tablesRepo: activeTablesRepo,
-		venuesRepo: venueRepo,
-		eventsRepo: eventsRepo,
-		menuRepo:   menuRepo,
+		tablesRepo:       activeTablesRepo,
+		venuesRepo:       venueRepo,
+		eventsRepo:       eventsRepo,
+		menuRepo:         menuRepo,
+		guestsRepo:       guestsRepo,
+		loyaltyRepo:      loyaltyRepo,
+		reservationsRepo: reservationsRepo,
+		printJobsRepo:    printJobsRepo,
+		mailer:           mailer,
+		payments:         payments,
+		events:           newSessionEvents(bus, venueRepo),
 	}
 
 }
@@ -35,24 +66,8 @@ func (h *TableHandler) CodeHandler(w http.ResponseWriter, r *http.Request) {
 	code := mux.Vars(r)["code"]
 	logger.Infof("got code: %v", code)
 
-	// Check for existing client cookie
-	clientID := ""
-	cookie, err := r.Cookie("client_id")
-	if errors.Is(err, http.ErrNoCookie) {
-		// Generate new client ID if cookie doesn't exist
-		clientID = uuid.New().String()
-		http.SetCookie(w, &http.Cookie{
-			Name:     "client_id",
-			Value:    clientID,
-			Path:     "/",
-			MaxAge:   86400 * 30, // 30 days
-			HttpOnly: true,
-			Secure:   false,
-			SameSite: http.SameSiteStrictMode,
-		})
-	} else {
-		clientID = cookie.Value
-	}
+	// Check for existing client cookie, generating a new client ID if it doesn't exist
+	clientID := getOrCreateClientID(w, r)
 
 	logger.Infof("client ID: %v", clientID)
 	session, err := h.tablesRepo.GetSessionForTable(code)
@@ -63,11 +78,28 @@ func (h *TableHandler) CodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if session == nil {
+		// Guests whose session was moved or merged are sent to the table it is on now
+		if moved, ok := h.transferredSession(r.Context(), code, clientID); ok {
+			http.Redirect(w, r, fmt.Sprintf("/table/%s", moved), http.StatusSeeOther)
+			return
+		}
+
+		if !h.claimTable(w, r, code) {
+			return
+		}
+
 		session = &structs.ActiveTable{
 			ClientID:  clientID,
 			TableCode: code,
+			OpenedAt:  time.Now(),
 		}
 		_, err = h.tablesRepo.TableActive(session)
+		if errors.Is(err, repo.ErrTableOccupied) {
+			// Another guest or a move opened the table at the same time
+			session, err = h.tablesRepo.GetSessionForTable(code)
+		} else if err == nil {
+			h.events.publish(r.Context(), sessionOpened, session)
+		}
 		if err != nil {
 			logger.Errorf("error creating session: %v", err)
 			http.Error(w, "Error creating session", http.StatusInternalServerError)
@@ -75,13 +107,13 @@ func (h *TableHandler) CodeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if session.ClientID != clientID {
+	if !session.HasClient(clientID) {
 		tmpl := template.Must(template.New("occupied.html").Funcs(templateFuncs).ParseFiles("templates/occupied.html"))
 		tmpl.Execute(w, nil)
 		return
 	}
 
-	venue, err := h.venuesRepo.GetVenueByTableCode(r.Context(), code)
+	venue, err := h.sessionVenue(r.Context(), session)
 	if err != nil {
 		logger.Errorf("error fetching venue: %v", err)
 		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
@@ -94,18 +126,44 @@ func (h *TableHandler) CodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.Infof("found venue: %v", venue)
-	menu, err := h.menuRepo.GetMenuByVenueID(venue.ID)
+	venueMenu, err := h.menuRepo.GetMenuForVenue(r.Context(), venue)
 	if err != nil {
 		logger.Errorf("error fetching menu: %v", err)
 		http.Error(w, "Error fetching menu", http.StatusInternalServerError)
 		return
 	}
+	// Guests get the prices and items of this venue
+	menu := venueMenu.Data.WithOverrides(venue.MenuOverrides)
 	logger.Infof("found menu: %v", menu)
 
+	var suggestions []structs.MenuItem
+	profile, err := h.guestsRepo.GetProfileByClientID(r.Context(), venue.TenantID, clientID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching guest profile: %v", err)
+	}
+	if profile != nil {
+		suggestions = orderAgainSuggestions(profile, &menu, 5)
+	}
+
+	// Guests at the table get to know when it has to be free again
+	var reservation *structs.Reservation
+	if _, upcoming := reservationBlock(r.Context(), h.reservationsRepo, venue, code, time.Now()); upcoming != nil {
+		loc := venue.Reservations.Location()
+		if upcoming.StartsAt.In(loc).Format(dateLayout) == time.Now().In(loc).Format(dateLayout) {
+			reservation = upcoming
+		}
+	}
+
+	languages := menu.Languages()
 	menuPage := structs.MenuPage{
-		Title:     "Menu",
-		Menu:      *menu,
-		TableCode: code,
+		Title:       "Menu",
+		Venue:       *venue,
+		Menu:        menu,
+		TableCode:   code,
+		Language:    resolveLanguage(w, r, languages, menu.Language),
+		Languages:   languages,
+		Suggestions: suggestions,
+		Reservation: reservation,
 	}
 	tmpl := template.Must(template.New("menu.html").Funcs(templateFuncs).ParseFiles("templates/menu.html"))
 	err = tmpl.Execute(w, menuPage)
@@ -139,7 +197,7 @@ func (h *TableHandler) OrderHandler(w http.ResponseWriter, r *http.Request) {
 		clientID = cookie.Value
 	}
 
-	if session.ClientID != clientID {
+	if !session.HasClient(clientID) {
 		tmpl := template.Must(template.New("occupied.html").Funcs(templateFuncs).ParseFiles("templates/occupied.html"))
 		tmpl.Execute(w, nil)
 		return
@@ -182,42 +240,54 @@ func (h *TableHandler) orderHandlerPOST(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	menuItemPriceStr := r.FormValue("price")
-	if menuItemPriceStr == "" {
-		logger.Error("price is required")
-		http.Error(w, "price is required", http.StatusBadRequest)
+	menuItemAmount, err := strconv.Atoi(r.FormValue("amount"))
+	if err != nil {
+		logger.Errorf("error parsing amount: %v", err)
+		menuItemAmount = 1
+	}
+	if menuItemAmount < 1 {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
 		return
 	}
-	menuItemDescStr := r.FormValue("description")
 
-	menuItemPrice, err := strconv.ParseFloat(menuItemPriceStr, 64)
+	// Prices come from the menu of the venue, never from the guest
+	venue, err := h.sessionVenue(r.Context(), session)
 	if err != nil {
-		logger.Errorf("error parsing price: %v", err)
-		http.Error(w, "Error parsing price", http.StatusBadRequest)
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
 		return
 	}
-	menuItemAmount, err := strconv.Atoi(r.FormValue("amount"))
+	venueMenu, err := h.menuRepo.GetMenuForVenue(r.Context(), venue)
 	if err != nil {
-		logger.Errorf("error parsing amount: %v", err)
-		menuItemAmount = 1
+		logger.Errorf("error fetching menu: %v", err)
+		http.Error(w, "Error fetching menu", http.StatusInternalServerError)
+		return
+	}
+	menuItem, ok := venueMenu.Data.WithOverrides(venue.MenuOverrides).Item(menuItemName)
+	if !ok {
+		logger.Errorf("item %v is not on the menu of venue %v", menuItemName, venue.ID.Hex())
+		http.Error(w, "This item is not available", http.StatusBadRequest)
+		return
 	}
 
-	session.PreOrder = append(session.PreOrder, structs.OrderItem{
+	item := structs.OrderItem{
 		MenuItem: &structs.MenuItem{
-			Name:        menuItemName,
-			Price:       menuItemPrice,
-			Description: menuItemDescStr,
+			Name:        menuItem.Name,
+			Price:       menuItem.Price,
+			Description: menuItem.Description,
 		},
 		Amount: menuItemAmount,
-	})
-
-	// Update the session in the database
-	_, err = h.tablesRepo.UpdateSession(session)
+	}
+	result, err := h.tablesRepo.AddToCart(r.Context(), session.ID, item)
 	if err != nil {
 		logger.Errorf("error updating session: %v", err)
 		http.Error(w, "Error updating session", http.StatusInternalServerError)
 		return
 	}
+	if result.MatchedCount == 0 {
+		http.Error(w, sessionGoneMessage, http.StatusConflict)
+		return
+	}
 	return
 }
 
@@ -244,50 +314,122 @@ func (h *TableHandler) PlaceOrderHandler(w http.ResponseWriter, r *http.Request)
 		clientID = cookie.Value
 	}
 
-	if session.ClientID != clientID {
+	if !session.HasClient(clientID) {
 		tmpl := template.Must(template.New("occupied.html").Funcs(templateFuncs).ParseFiles("templates/occupied.html"))
 		tmpl.Execute(w, nil)
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		session.OrderHistory = append(session.OrderHistory, session.PreOrder...)
-		session.PreOrder = []structs.OrderItem{}
-		_, err = h.tablesRepo.UpdateSession(session)
+		// The kitchen needs the preparation time of pickup orders from the moment they come in
+		now := time.Now()
+		var earliest time.Time
+		if session.IsPickup() {
+			if venue, err := h.sessionVenue(r.Context(), session); err == nil {
+				earliest = earliestPickup(venue, now)
+			}
+		}
+		before, err := h.tablesRepo.PlaceOrder(r.Context(), session.ID, now, earliest)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, sessionGoneMessage, http.StatusConflict)
+			return
+		}
 		if err != nil {
 			logger.Errorf("error updating session: %v", err)
 			http.Error(w, "Error updating session", http.StatusInternalServerError)
 			return
 		}
+		placed := before.PreOrder
+		session.OrderHistory = append(before.OrderHistory, placed...)
+		session.PreOrder = []structs.OrderItem{}
+		if session.ReadyAt.Before(earliest) {
+			session.ReadyAt = earliest
+		}
+		if len(placed) > 0 {
+			session.OrderedAt = now
+			h.clearPayment(r.Context(), session)
+			h.printOrder(r.Context(), session, placed)
+			// Ordering more means the guests aren't leaving yet
+			if !session.BillRequestedAt.IsZero() {
+				if _, err := h.tablesRepo.ClearBillRequest(r.Context(), code); err != nil {
+					logger.Errorf("error clearing bill request of table %v: %v", code, err)
+				}
+			}
+			h.events.publish(r.Context(), sessionUpdated, session)
+		}
 		http.Redirect(w, r, fmt.Sprintf("/table/%s", code), http.StatusSeeOther)
 		return
 	}
 }
 
-func (h *TableHandler) OrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+// BillRequestHandler lets the guests of a table ask for the bill, the table shows up as waiting
+// for it on the floor plan.
+func (h *TableHandler) BillRequestHandler(w http.ResponseWriter, r *http.Request) {
 	code := mux.Vars(r)["code"]
-	logger.Infof("got code: %v", code)
 
 	session, err := h.tablesRepo.GetSessionForTable(code)
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		logger.Errorf("error fetching session: %v", err)
 		http.Error(w, "Error fetching session", http.StatusInternalServerError)
 		return
 	}
-
-	if session == nil {
-		logger.Errorf("no active session found for code: %v", code)
+	if !session.HasClient(getClientID(r)) {
 		http.Error(w, "No active session found", http.StatusNotFound)
 		return
 	}
 
+	if session.BillRequestedAt.IsZero() {
+		if _, err := h.tablesRepo.RequestBill(r.Context(), code, time.Now()); err != nil {
+			logger.Errorf("error requesting bill for table %v: %v", code, err)
+			http.Error(w, "Error requesting the bill", http.StatusInternalServerError)
+			return
+		}
+		h.events.publish(r.Context(), sessionUpdated, session)
+	}
+	fmt.Fprint(w, "The bill is on its way")
+}
+
+func (h *TableHandler) OrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	logger.Infof("got code: %v", code)
+
+	session, err := h.tablesRepo.GetSessionForTable(code)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Errorf("error fetching session: %v", err)
+		http.Error(w, "Error fetching session", http.StatusInternalServerError)
+		return
+	}
+
 	clientID := ""
 	cookie, err := r.Cookie("client_id")
 	if err == nil {
 		clientID = cookie.Value
 	}
 
-	if session.ClientID != clientID {
+	if session == nil {
+		// Once the table has been closed the guest is sent to the receipt of their session
+		if clientID != "" && r.Method == http.MethodGet {
+			if moved, ok := h.transferredSession(r.Context(), code, clientID); ok {
+				http.Redirect(w, r, fmt.Sprintf("/history/%s", moved), http.StatusSeeOther)
+				return
+			}
+			event, err := h.eventsRepo.GetLatestEventForClient(r.Context(), code, clientID)
+			if err == nil && event.ReceiptToken != "" {
+				http.Redirect(w, r, fmt.Sprintf("/receipt/%s", event.ReceiptToken), http.StatusSeeOther)
+				return
+			}
+		}
+
+		logger.Errorf("no active session found for code: %v", code)
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return
+	}
+
+	if !session.HasClient(clientID) {
 		tmpl := template.Must(template.New("occupied.html").Funcs(templateFuncs).ParseFiles("templates/occupied.html"))
 		tmpl.Execute(w, nil)
 		return
@@ -304,25 +446,49 @@ func (h *TableHandler) OrderHistoryHandler(w http.ResponseWriter, r *http.Reques
 			Title:        "Order History",
 			Session:      session,
 			CurrentTotal: total,
+			CanPayOnline: h.payments != nil && len(session.OrderHistory) > 0,
+		}
+		if venue, err := h.sessionVenue(r.Context(), session); err == nil {
+			orderPage.Loyalty = h.loyaltySummary(r.Context(), venue, session)
+			session.ReadyAt = session.ReadyAt.In(venue.Location())
 		}
 		tmpl := template.Must(template.New("order-history.html").Funcs(templateFuncs).ParseFiles("templates/order-history.html"))
 		tmpl.Execute(w, orderPage)
 	}
 
 	if r.Method == http.MethodPost {
-		session.OrderHistory = []structs.OrderItem{}
-		_, err = h.tablesRepo.UpdateSession(session)
+		// Guests clearing their history aren't signed in staff, they are recorded by client id
+		if venue, err := h.sessionVenue(r.Context(), session); err == nil {
+			audit.Actor(r.Context(), venue.TenantID, clientID, "guest "+clientID)
+			audit.Describe(r.Context(), "order.history.clear", codeTarget(venue, code))
+		}
+		audit.Before(r.Context(), session)
+		result, err := h.tablesRepo.ClearOrderHistory(r.Context(), session.ID)
 		if err != nil {
 			logger.Errorf("error updating session: %v", err)
 			http.Error(w, "Error updating session", http.StatusInternalServerError)
 			return
 		}
+		if result.MatchedCount == 0 {
+			http.Error(w, sessionGoneMessage, http.StatusConflict)
+			return
+		}
+		session.OrderHistory = []structs.OrderItem{}
+		audit.After(r.Context(), session)
+		h.events.publish(r.Context(), sessionUpdated, session)
 		http.Redirect(w, r, fmt.Sprintf("/table/%s", code), http.StatusSeeOther)
 		return
 	}
 }
 
 func (h *TableHandler) CloseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	adminSession, _ := store.Get(r, "session-name")
+	auth, ok := adminSession.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	code := mux.Vars(r)["code"]
 	logger.Infof("got code: %v", code)
 	session, err := h.tablesRepo.GetSessionForTable(code)
@@ -337,6 +503,11 @@ func (h *TableHandler) CloseOrderHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "No active session found", http.StatusNotFound)
 		return
 	}
+	venue, err := h.sessionVenue(r.Context(), session)
+	if err != nil || !ownsVenue(adminSession, venue) {
+		http.Error(w, "No active session found", http.StatusNotFound)
+		return
+	}
 
 	err = r.ParseForm()
 	if err != nil {
@@ -351,27 +522,224 @@ func (h *TableHandler) CloseOrderHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid order status", http.StatusBadRequest)
 		return
 	}
+
+	var tip float64
+	if tipStr := r.FormValue("tip"); tipStr != "" {
+		tip, err = strconv.ParseFloat(tipStr, 64)
+		if err != nil || tip < 0 {
+			logger.Errorf("invalid tip: %v", tipStr)
+			http.Error(w, "Invalid tip", http.StatusBadRequest)
+			return
+		}
+	}
+
 	logger.Infof("updating session status to: %v", status)
-	event := structs.Event{
-		Status: status,
-		Order:  *session,
+	event, err := h.closeSession(r.Context(), session, status, r.FormValue("paymentMethod"), tip)
+	if errors.Is(err, errSessionChanged) {
+		http.Error(w, "This table was closed or ordered again, please refresh and check it", http.StatusConflict)
+		return
 	}
-	_, err = h.eventsRepo.RecordEvent(&event)
 	if err != nil {
-		logger.Errorf("error recording event: %v", err)
-		http.Error(w, "Error recording event", http.StatusInternalServerError)
+		logger.Errorf("error closing session: %v", err)
+		http.Error(w, "Error closing session", http.StatusInternalServerError)
 		return
 	}
+	audit.Describe(r.Context(), "session.close", codeTarget(venue, code))
+	audit.After(r.Context(), event)
 
-	h.tablesRepo.DeleteSession(session.TableCode)
+	if email := r.FormValue("email"); email != "" && event.ReceiptToken != "" {
+		if err := h.emailReceipt(r.Context(), event, email); err != nil {
+			logger.Errorf("error emailing receipt: %v", err)
+		}
+	}
 
-	sessions, err := h.tablesRepo.GetOpenSessions(r.Context())
-	if err != nil {
-		logger.Errorf("error fetching open sessions: %v", err)
-		http.Error(w, "Error fetching open sessions", http.StatusInternalServerError)
+	h.renderOpenSessions(w, r)
+}
+
+// clearPayment drops the checkout a session started, it no longer covers the bill once the
+// guests place another order. Checkouts the provider already charged stay for staff to settle.
+func (h *TableHandler) clearPayment(ctx context.Context, session *structs.ActiveTable) {
+	if session.Payment == nil || session.Payment.NeedsReview() {
 		return
 	}
+	if _, err := h.tablesRepo.ClearPayment(ctx, session.ID); err != nil {
+		logger.Errorf("error clearing payment of table %v: %v", session.TableCode, err)
+		return
+	}
+	session.Payment = nil
+}
+
+// sessionVenue returns the venue of a session.
+func (h *TableHandler) sessionVenue(ctx context.Context, session *structs.ActiveTable) (*structs.Venue, error) {
+	return findSessionVenue(ctx, h.venuesRepo, session)
+}
+
+// claimTable checks a walk-in guest can open a session on a table. Tables held for a booking
+// can only be opened with its reference, which seats the booking.
+func (h *TableHandler) claimTable(w http.ResponseWriter, r *http.Request, code string) bool {
+	venue, err := h.venuesRepo.GetVenueByTableCode(r.Context(), code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Codes of closed pickup orders and unknown codes don't open sessions
+		http.Error(w, "Table not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		// Other errors are reported once the session exists
+		return true
+	}
+	if table, ok := venue.Table(code); ok && table.Retired {
+		http.Error(w, "This table is no longer in use, please ask a member of staff", http.StatusGone)
+		return false
+	}
+	blocking, _ := reservationBlock(r.Context(), h.reservationsRepo, venue, code, time.Now())
+	if blocking == nil {
+		return true
+	}
+
+	reference := strings.ToUpper(strings.TrimSpace(r.FormValue("booking")))
+	if reference == blocking.Reference {
+		if _, err := h.reservationsRepo.SetStatus(r.Context(), blocking.ID, structs.ReservationSeated); err != nil {
+			logger.Errorf("error seating reservation: %v", err)
+		}
+		return true
+	}
+
+	reservedPage := structs.ReservedPage{
+		Title:       "Reserved",
+		TableCode:   code,
+		Reservation: blocking,
+		Location:    venue.Reservations.Location(),
+	}
+	if reference != "" {
+		reservedPage.Error = "That booking reference doesn't match the reservation of this table."
+	}
+	tmpl := template.Must(template.New("reserved.html").Funcs(templateFuncs).ParseFiles("templates/reserved.html"))
+	err = tmpl.Execute(w, reservedPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// closeSession records the closing event of an active table and frees the table. The session is
+// claimed first, so when two requests close the same table only one records it. Paid sessions get
+// their totals calculated and a receipt number assigned. errSessionChanged is returned when the
+// session was closed already or an order was placed since it was read.
+func (h *TableHandler) closeSession(ctx context.Context, session *structs.ActiveTable, status, paymentMethod string, tip float64) (*structs.Event, error) {
+	var taxRate float64
+	venue, err := h.sessionVenue(ctx, session)
+	if err != nil {
+		logger.Errorf("error fetching venue for table %v: %v", session.TableCode, err)
+	} else {
+		taxRate = venue.TaxRate
+	}
+	if status == "paid" && venue == nil {
+		return nil, fmt.Errorf("error assigning receipt number: the venue of table %v is unknown", session.TableCode)
+	}
+
+	claimed, err := h.tablesRepo.CloseSession(ctx, session.ID, len(session.OrderHistory))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errSessionChanged
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error deleting session: %w", err)
+	}
+
+	event := structs.Event{
+		Status:   status,
+		Order:    *claimed,
+		ClosedAt: time.Now(),
+	}
+	if venue != nil {
+		event.VenueID = venue.ID
+	}
+
+	if status == "paid" {
+		var discount float64
+		event.Order.Redemption = h.checkRedemption(ctx, venue, claimed)
+		if event.Order.Redemption != nil {
+			discount = event.Order.Redemption.Discount
+		}
+
+		totals := receipts.Calculate(claimed.OrderHistory, taxRate, discount, tip)
+		event.Subtotal = totals.Subtotal
+		event.Discount = totals.Discount
+		event.TaxRate = taxRate
+		event.Tax = totals.Tax
+		event.Tip = totals.Tip
+		event.Total = totals.Total
+		event.PaymentMethod = paymentMethod
 
-	tmpl := template.Must(template.New("open-sessions.html").Funcs(templateFuncs).ParseFiles("templates/open-sessions.html"))
-	tmpl.Execute(w, sessions)
+		event.ReceiptNumber, err = h.eventsRepo.NextReceiptNumber(ctx, venue.ID)
+		if err != nil {
+			h.reopenSession(claimed)
+			return nil, fmt.Errorf("error assigning receipt number: %w", err)
+		}
+		event.ReceiptToken = uuid.New().String()
+	}
+
+	result, err := h.eventsRepo.RecordEvent(&event)
+	if err != nil {
+		h.reopenSession(claimed)
+		return nil, fmt.Errorf("error recording event: %w", err)
+	}
+	event.ID, _ = result.InsertedID.(primitive.ObjectID)
+	h.events.publish(ctx, sessionClosed, claimed)
+
+	if status == "paid" {
+		if profile := h.recordVisit(ctx, venue, &event); profile != nil {
+			h.recordLoyalty(ctx, venue, profile, &event)
+		}
+	}
+
+	return &event, nil
+}
+
+// reopenSession puts back a session claimed for closing when its closing event couldn't be
+// recorded, so the table can be closed again.
+func (h *TableHandler) reopenSession(session *structs.ActiveTable) {
+	if _, err := h.tablesRepo.TableActive(session); err != nil {
+		logger.Errorf("error reopening session of table %v: %v", session.TableCode, err)
+	}
+}
+
+// recordVisit adds a paid session to the profile of the guest, failures are only logged since
+// the table is already closed at this point.
+func (h *TableHandler) recordVisit(ctx context.Context, venue *structs.Venue, event *structs.Event) *structs.GuestProfile {
+	if event.Order.ClientID == "" {
+		return nil
+	}
+
+	profile, err := h.guestsRepo.GetOrCreateProfile(ctx, venue.TenantID, event.Order.ClientID)
+	if err != nil {
+		logger.Errorf("error fetching guest profile: %v", err)
+		return nil
+	}
+
+	_, err = h.guestsRepo.AddVisit(ctx, profile.ID, structs.Visit{
+		VenueID:      venue.ID,
+		VenueName:    venue.Name,
+		EventID:      event.ID,
+		ReceiptToken: event.ReceiptToken,
+		Total:        event.Total,
+		Items:        event.Order.OrderHistory,
+		ClosedAt:     event.ClosedAt,
+	})
+	if err != nil {
+		logger.Errorf("error recording guest visit: %v", err)
+	}
+	return profile
+}
+
+func (h *TableHandler) emailReceipt(ctx context.Context, event *structs.Event, to string) error {
+	if h.mailer == nil {
+		return fmt.Errorf("email is not configured")
+	}
+
+	var venue *structs.Venue
+	if !event.VenueID.IsZero() {
+		venue, _ = h.venuesRepo.GetVenueById(ctx, event.VenueID)
+	}
+	return sendReceipt(h.mailer, to, receipts.New(event, venue))
 }
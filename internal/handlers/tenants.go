@@ -12,7 +12,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/auth"
 	"vortex.studio/account/internal/keycloak"
+	"vortex.studio/account/internal/utils"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -85,14 +88,33 @@ func CreateTenantHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Creating tenant for business: %s with admin: %s", tenantRequest.BusinessName, tenantRequest.AdminUsername)
 
 	// Create a new Keycloak realm for the tenant
-	adminPassword := "tenant-admin-password" // TODO: Generate a new admin password per tenant
-	if err := keycloak.CreateKeycloakTenant(tenantRequest.BusinessName, tenantRequest.AdminUsername, adminPassword); err != nil {
+	adminPassword, err := keycloak.GeneratePassword()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate admin password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	authConfig := auth.ConfigFromEnv(utils.PublicBaseURL())
+	oidcClient := keycloak.OIDCClient{
+		ClientID:              authConfig.ClientID,
+		Secret:                authConfig.ClientSecret,
+		RedirectURI:           authConfig.RedirectURL,
+		PostLogoutRedirectURI: utils.PublicBaseURL() + "/admin",
+	}
+	if err := keycloak.CreateKeycloakTenant(tenantRequest.BusinessName, tenantRequest.AdminUsername, adminPassword, oidcClient); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create tenant: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// Tenants are created with the platform password rather than by staff, the entry starts the
+	// audit log of the new tenant
+	audit.Actor(r.Context(), tenantRequest.BusinessName, "", "platform admin")
+	audit.Describe(r.Context(), "tenant.create", tenantRequest.BusinessName)
+	audit.After(r.Context(), tenantRequest)
+
 	// Respond to the client
 	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf("Tenant '%s' created successfully", tenantRequest.BusinessName)))
+	// The password is temporary and only shown here, the admin has to change it when they first
+	// sign in
+	w.Write([]byte(fmt.Sprintf("Tenant '%s' created successfully, temporary password for %s: %s", tenantRequest.BusinessName, tenantRequest.AdminUsername, adminPassword)))
 }
 
 func getK8sSecretPassword(r *http.Request, namespace, secretName, key string) (string, error) {
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vortex.studio/account/internal/audit"
+	"vortex.studio/account/internal/pubsub"
+	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/structs"
+	"vortex.studio/account/internal/utils"
+)
+
+const (
+	// defaultSessionDuration is assumed until a venue has paid sessions to estimate from
+	defaultSessionDuration = 45 * time.Minute
+	sessionDurationWindow  = 30 * 24 * time.Hour
+)
+
+type WaitlistHandler struct {
+	waitlistRepo     *repo.WaitlistRepository
+	venuesRepo       *repo.VenueRepository
+	tablesRepo       *repo.ActiveTablesRepository
+	eventsRepo       *repo.EventsRepo
+	reservationsRepo *repo.ReservationsRepository
+	broker           pubsub.Bus
+	events           *sessionEvents
+}
+
+func NewWaitlistHandler(waitlistRepo *repo.WaitlistRepository, venuesRepo *repo.VenueRepository, tablesRepo *repo.ActiveTablesRepository, eventsRepo *repo.EventsRepo, reservationsRepo *repo.ReservationsRepository, broker pubsub.Bus) *WaitlistHandler {
+	return &WaitlistHandler{
+		waitlistRepo:     waitlistRepo,
+		venuesRepo:       venuesRepo,
+		tablesRepo:       tablesRepo,
+		eventsRepo:       eventsRepo,
+		reservationsRepo: reservationsRepo,
+		broker:           broker,
+		events:           newSessionEvents(broker, venuesRepo),
+	}
+}
+
+// WaitlistHandler is the page guests reach from the venue QR code. It lets them join the queue
+// and shows their position until they are seated.
+func (h *WaitlistHandler) WaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+	clientID := getOrCreateClientID(w, r)
+
+	if r.Method == http.MethodPost {
+		name := strings.TrimSpace(r.FormValue("name"))
+		partySize, err := strconv.Atoi(r.FormValue("partySize"))
+		if name == "" || err != nil || partySize < 1 {
+			http.Error(w, "Name and party size are required", http.StatusBadRequest)
+			return
+		}
+
+		// Joining twice keeps the original place in the queue
+		entry, err := h.waitlistRepo.GetLatestEntryForClient(r.Context(), venue.ID, clientID)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			logger.Errorf("error fetching waitlist entry: %v", err)
+			http.Error(w, "Error joining waitlist", http.StatusInternalServerError)
+			return
+		}
+		if entry == nil || entry.Status != structs.WaitlistWaiting {
+			_, err = h.waitlistRepo.AddEntry(r.Context(), &structs.WaitlistEntry{
+				VenueID:   venue.ID,
+				ClientID:  clientID,
+				Name:      name,
+				PartySize: partySize,
+				Phone:     strings.TrimSpace(r.FormValue("phone")),
+				Status:    structs.WaitlistWaiting,
+				CreatedAt: time.Now(),
+			})
+			if err != nil {
+				logger.Errorf("error adding waitlist entry: %v", err)
+				http.Error(w, "Error joining waitlist", http.StatusInternalServerError)
+				return
+			}
+			h.publish(venue.ID)
+		}
+		http.Redirect(w, r, fmt.Sprintf("/waitlist/%s", venue.ID.Hex()), http.StatusSeeOther)
+		return
+	}
+
+	status, err := h.guestStatus(r.Context(), venue, clientID)
+	if err != nil {
+		logger.Errorf("error fetching waitlist status: %v", err)
+		http.Error(w, "Error fetching waitlist", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := template.Must(template.New("waitlist.html").Funcs(templateFuncs).ParseFiles("templates/waitlist.html", "templates/waitlist-status.html"))
+	err = tmpl.Execute(w, status)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// StatusHandler renders the waitlist status of the guest, the page reloads it on every update.
+func (h *WaitlistHandler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.guestStatus(r.Context(), venue, getClientID(r))
+	if err != nil {
+		logger.Errorf("error fetching waitlist status: %v", err)
+		http.Error(w, "Error fetching waitlist", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := template.Must(template.New("waitlist-status.html").Funcs(templateFuncs).ParseFiles("templates/waitlist-status.html"))
+	tmpl.Execute(w, status)
+}
+
+// LeaveHandler takes the guest off the waitlist.
+func (h *WaitlistHandler) LeaveHandler(w http.ResponseWriter, r *http.Request) {
+	venue, ok := h.getVenue(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.waitlistRepo.GetLatestEntryForClient(r.Context(), venue.ID, getClientID(r))
+	if err == nil && entry.Status == structs.WaitlistWaiting {
+		if _, err := h.waitlistRepo.CancelEntry(r.Context(), entry.ID); err != nil {
+			logger.Errorf("error leaving waitlist: %v", err)
+			http.Error(w, "Error leaving waitlist", http.StatusInternalServerError)
+			return
+		}
+		h.publish(venue.ID)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/waitlist/%s", venue.ID.Hex()), http.StatusSeeOther)
+}
+
+// EventsHandler streams a server-sent "update" event to the guest page whenever the waitlist of
+// the venue changes.
+func (h *WaitlistHandler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	venueID, err := primitive.ObjectIDFromHex(mux.Vars(r)["venue"])
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return
+	}
+	streamEvents(w, r, h.broker, waitlistTopic(venueID), namedEvent)
+}
+
+// AdminWaitlistHandler shows the groups waiting at a venue and the tables they can be seated at.
+func (h *WaitlistHandler) AdminWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	venues, err := h.venuesRepo.GetVenuesForTenant(r.Context(), sessionTenant(session))
+	if err != nil {
+		logger.Errorf("error fetching venues: %v", err)
+		http.Error(w, "Error fetching venues", http.StatusInternalServerError)
+		return
+	}
+	if len(venues) == 0 {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	venue := venues[0]
+	for _, v := range venues {
+		if v.ID.Hex() == r.FormValue("venue") {
+			venue = v
+		}
+	}
+
+	queue, err := h.queue(r.Context(), &venue)
+	if err != nil {
+		logger.Errorf("error fetching waitlist: %v", err)
+		http.Error(w, "Error fetching waitlist", http.StatusInternalServerError)
+		return
+	}
+
+	joinURL := fmt.Sprintf("%s/waitlist/%s", utils.PublicBaseURL(), venue.ID.Hex())
+	qrCode, err := utils.GenerateQRCodeBase64(joinURL)
+	if err != nil {
+		logger.Errorf("error generating waitlist QR code: %v", err)
+	}
+
+	waitlistPage := structs.AdminWaitlistPage{
+		Title:      "Waitlist",
+		Venues:     venues,
+		Venue:      venue,
+		Entries:    queue.entries,
+		FreeTables: queue.freeTables,
+		JoinURL:    joinURL,
+		QRCode:     qrCode,
+		Error:      r.FormValue("error"),
+		CSRFToken:  csrfToken(session),
+	}
+	tmpl := template.Must(template.New("admin-waitlist.html").Funcs(templateFuncs).ParseFiles("templates/admin-waitlist.html"))
+	err = tmpl.Execute(w, waitlistPage)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SeatHandler opens a session for a waiting group on a free table. The session belongs to the
+// guest that joined the waitlist, so their phone can order as soon as they scan the table.
+func (h *WaitlistHandler) SeatHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	entry, venue, ok := h.getEntry(w, r)
+	if !ok {
+		return
+	}
+	if entry.Status != structs.WaitlistWaiting {
+		http.Error(w, "The group is no longer waiting", http.StatusConflict)
+		return
+	}
+
+	tableCode := r.FormValue("table")
+	if !venueHasTable(venue, tableCode) {
+		http.Error(w, "The table doesn't belong to this venue", http.StatusBadRequest)
+		return
+	}
+	if existing, err := h.tablesRepo.GetSessionForTable(tableCode); err == nil && existing != nil {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, adminWaitlistURL(venue, "Table "+tableCode+" is occupied"), http.StatusSeeOther)
+		return
+	}
+	if blocking, _ := reservationBlock(r.Context(), h.reservationsRepo, venue, tableCode, time.Now()); blocking != nil {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, adminWaitlistURL(venue, "Table "+tableCode+" is held for a booking"), http.StatusSeeOther)
+		return
+	}
+
+	seated := &structs.ActiveTable{
+		ClientID:  entry.ClientID,
+		TableCode: tableCode,
+		OpenedAt:  time.Now(),
+	}
+	_, err := h.tablesRepo.TableActive(seated)
+	if errors.Is(err, repo.ErrTableOccupied) {
+		audit.Discard(r.Context())
+		http.Redirect(w, r, adminWaitlistURL(venue, "Table "+tableCode+" is occupied"), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error creating session: %v", err)
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
+	h.events.publish(r.Context(), sessionOpened, seated)
+	if _, err := h.waitlistRepo.SeatEntry(r.Context(), entry.ID, tableCode); err != nil {
+		logger.Errorf("error seating waitlist entry: %v", err)
+		http.Error(w, "Error seating group", http.StatusInternalServerError)
+		return
+	}
+	h.publish(venue.ID)
+	audit.Describe(r.Context(), "waitlist.seat", codeTarget(venue, tableCode))
+	audit.Before(r.Context(), entry)
+	entry.Status, entry.TableCode = structs.WaitlistSeated, tableCode
+	audit.After(r.Context(), entry)
+
+	http.Redirect(w, r, adminWaitlistURL(venue, ""), http.StatusSeeOther)
+}
+
+// RemoveHandler takes a group off the waitlist, e.g. when they left without telling.
+func (h *WaitlistHandler) RemoveHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+	auth, ok := session.Values["authenticated"].(bool)
+	if !ok || !auth {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	entry, venue, ok := h.getEntry(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.waitlistRepo.CancelEntry(r.Context(), entry.ID); err != nil {
+		logger.Errorf("error removing waitlist entry: %v", err)
+		http.Error(w, "Error removing group", http.StatusInternalServerError)
+		return
+	}
+	h.publish(venue.ID)
+	audit.Describe(r.Context(), "waitlist.remove", venueTarget(venue)+" "+entry.Name)
+	audit.Before(r.Context(), entry)
+	entry.Status = structs.WaitlistCanceled
+	audit.After(r.Context(), entry)
+
+	http.Redirect(w, r, adminWaitlistURL(venue, ""), http.StatusSeeOther)
+}
+
+// guestStatus returns the waitlist page data of a guest: their place in the queue or the table
+// they were seated at.
+func (h *WaitlistHandler) guestStatus(ctx context.Context, venue *structs.Venue, clientID string) (*structs.WaitlistPage, error) {
+	status := &structs.WaitlistPage{
+		Title: "Waitlist",
+		Venue: *venue,
+	}
+
+	entry, err := h.waitlistRepo.GetLatestEntryForClient(ctx, venue.ID, clientID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status == structs.WaitlistCanceled {
+		return status, nil
+	}
+	status.Entry = entry
+	if entry.Status != structs.WaitlistWaiting {
+		return status, nil
+	}
+
+	queue, err := h.queue(ctx, venue)
+	if err != nil {
+		return nil, err
+	}
+	for _, waiting := range queue.entries {
+		if waiting.ID == entry.ID {
+			status.Position = waiting.Position
+			status.EstimatedWait = waiting.EstimatedWait
+		}
+	}
+	return status, nil
+}
+
+type waitlistQueue struct {
+	entries    []structs.QueuedEntry
+	freeTables []structs.TableCode
+}
+
+// queue returns the waiting groups of a venue with their position and estimated wait, and the
+// tables that are free to seat them at.
+func (h *WaitlistHandler) queue(ctx context.Context, venue *structs.Venue) (*waitlistQueue, error) {
+	waiting, err := h.waitlistRepo.GetWaiting(ctx, venue.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := h.tablesRepo.GetOpenSessionsForVenues(ctx, []structs.Venue{*venue})
+	if err != nil {
+		return nil, err
+	}
+
+	average, count, err := h.eventsRepo.GetAverageSessionDuration(ctx, venue.ID, time.Now().Add(-sessionDurationWindow))
+	if err != nil {
+		logger.Errorf("error fetching session durations: %v", err)
+	}
+	if count == 0 {
+		average = defaultSessionDuration
+	}
+
+	occupied := map[string]*structs.ActiveTable{}
+	for _, session := range sessions {
+		occupied[session.TableCode] = session
+	}
+
+	queue := &waitlistQueue{}
+	var remaining []time.Duration
+	for _, tableCode := range venue.ActiveTables() {
+		session, ok := occupied[tableCode.Code]
+		if !ok {
+			queue.freeTables = append(queue.freeTables, tableCode)
+			remaining = append(remaining, 0)
+			continue
+		}
+		left := average
+		if !session.OpenedAt.IsZero() {
+			left = average - time.Since(session.OpenedAt)
+		}
+		remaining = append(remaining, max(left, 0))
+	}
+
+	for i, entry := range waiting {
+		queue.entries = append(queue.entries, structs.QueuedEntry{
+			WaitlistEntry: entry,
+			Position:      i + 1,
+			EstimatedWait: estimateWait(remaining, i+1, average),
+		})
+	}
+	return queue, nil
+}
+
+// estimateWait returns how long the group at a position of the queue will wait, given how long
+// each table has left and how long sessions take on average. Tables are handed out in the order
+// they free up and every table serves a new group each average session.
+func estimateWait(remaining []time.Duration, position int, average time.Duration) time.Duration {
+	if len(remaining) == 0 || position < 1 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, remaining...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := position - 1
+	rounds := index / len(sorted)
+	return sorted[index%len(sorted)] + time.Duration(rounds)*average
+}
+
+func (h *WaitlistHandler) publish(venueID primitive.ObjectID) {
+	h.broker.Publish(waitlistTopic(venueID), "update")
+}
+
+func (h *WaitlistHandler) getVenue(w http.ResponseWriter, r *http.Request) (*structs.Venue, bool) {
+	venueID, err := primitive.ObjectIDFromHex(mux.Vars(r)["venue"])
+	if err != nil {
+		http.Error(w, "Invalid venue id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), venueID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Venue not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, false
+	}
+	return venue, true
+}
+
+func (h *WaitlistHandler) getEntry(w http.ResponseWriter, r *http.Request) (*structs.WaitlistEntry, *structs.Venue, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid waitlist entry id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	entry, err := h.waitlistRepo.GetEntryByID(r.Context(), id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Waitlist entry not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		logger.Errorf("error fetching waitlist entry: %v", err)
+		http.Error(w, "Error fetching waitlist entry", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	venue, err := h.venuesRepo.GetVenueById(r.Context(), entry.VenueID)
+	if err != nil {
+		logger.Errorf("error fetching venue: %v", err)
+		http.Error(w, "Error fetching venue", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if session, _ := store.Get(r, "session-name"); !ownsVenue(session, venue) {
+		http.Error(w, "Waitlist entry not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return entry, venue, true
+}
+
+func waitlistTopic(venueID primitive.ObjectID) string {
+	return "waitlist:" + venueID.Hex()
+}
+
+func adminWaitlistURL(venue *structs.Venue, message string) string {
+	query := url.Values{"venue": {venue.ID.Hex()}}
+	if message != "" {
+		query.Set("error", message)
+	}
+	return "/admin/waitlist?" + query.Encode()
+}
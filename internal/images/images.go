@@ -0,0 +1,102 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// FullSize is the longest side of the stored full size image.
+	FullSize = 1600
+	// ThumbnailSize is the longest side of generated thumbnails.
+	ThumbnailSize = 320
+
+	// MaxPixels is the largest width times height of an upload that is decoded, a small file
+	// can otherwise claim dimensions that take all the memory to decode.
+	MaxPixels = 50_000_000
+
+	jpegQuality = 85
+)
+
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// Resized holds the JPEG encoded versions of an uploaded image.
+type Resized struct {
+	Full      []byte
+	Thumbnail []byte
+}
+
+// Process decodes an uploaded image (JPEG, PNG or GIF) and re-encodes it as a full size JPEG
+// and a thumbnail, both scaled down to fit their maximum size while keeping the aspect ratio.
+// Images of more than MaxPixels are rejected with ErrTooLarge before they are decoded.
+func Process(r io.Reader) (*Resized, error) {
+	// The header read to check the dimensions is replayed in front of the rest for decoding
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, fmt.Errorf("%w: %vx%v", ErrTooLarge, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	full, err := encodeJPEG(fit(src, FullSize))
+	if err != nil {
+		return nil, err
+	}
+	thumb, err := encodeJPEG(fit(src, ThumbnailSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resized{Full: full, Thumbnail: thumb}, nil
+}
+
+// fit scales the image down so its longest side is at most maxSize pixels. Smaller images are
+// only copied onto an opaque canvas so transparent PNGs encode cleanly as JPEG.
+func fit(src image.Image, maxSize int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			h = h * maxSize / w
+			w = maxSize
+		} else {
+			w = w * maxSize / h
+			h = maxSize
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
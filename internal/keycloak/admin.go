@@ -0,0 +1,337 @@
+package keycloak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+// APIError is a failed call to the Keycloak admin API. It matches ErrNotFound and ErrConflict
+// with errors.Is.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("keycloak returned %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Realm is the representation of a realm, as sent when creating one.
+type Realm struct {
+	Realm   string                     `json:"realm"`
+	Enabled bool                       `json:"enabled"`
+	Users   []User                     `json:"users,omitempty"`
+	Roles   *RealmRoles                `json:"roles,omitempty"`
+	Clients []OIDCClientRepresentation `json:"clients,omitempty"`
+}
+
+type RealmRoles struct {
+	Realm []Role `json:"realm"`
+}
+
+type User struct {
+	ID               string       `json:"id,omitempty"`
+	Username         string       `json:"username"`
+	Email            string       `json:"email,omitempty"`
+	FirstName        string       `json:"firstName,omitempty"`
+	LastName         string       `json:"lastName,omitempty"`
+	Enabled          bool         `json:"enabled"`
+	EmailVerified    bool         `json:"emailVerified"`
+	RequiredActions  []string     `json:"requiredActions,omitempty"`
+	Credentials      []Credential `json:"credentials,omitempty"`
+	RealmRoles       []string     `json:"realmRoles,omitempty"`
+	CreatedTimestamp int64        `json:"createdTimestamp,omitempty"`
+}
+
+type Credential struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Temporary bool   `json:"temporary,omitempty"`
+}
+
+type Role struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type OIDCClientRepresentation struct {
+	ClientID                  string            `json:"clientId"`
+	Enabled                   bool              `json:"enabled"`
+	Protocol                  string            `json:"protocol"`
+	PublicClient              bool              `json:"publicClient"`
+	Secret                    string            `json:"secret,omitempty"`
+	StandardFlowEnabled       bool              `json:"standardFlowEnabled"`
+	DirectAccessGrantsEnabled bool              `json:"directAccessGrantsEnabled"`
+	RedirectURIs              []string          `json:"redirectUris,omitempty"`
+	Attributes                map[string]string `json:"attributes,omitempty"`
+	ProtocolMappers           []ProtocolMapper  `json:"protocolMappers,omitempty"`
+}
+
+type ProtocolMapper struct {
+	Name           string            `json:"name"`
+	Protocol       string            `json:"protocol"`
+	ProtocolMapper string            `json:"protocolMapper"`
+	Config         map[string]string `json:"config,omitempty"`
+}
+
+// AdminClient calls the Keycloak admin REST API with a service account of the master realm.
+type AdminClient struct {
+	baseURL      string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func NewAdminClient(baseURL, clientID, clientSecret string) *AdminClient {
+	return &AdminClient{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// NewAdminClientFromEnv configures the client from KEYCLOAK_URL, KEYCLOAK_CLIENT_ID and
+// KEYCLOAK_CLIENT_SECRET.
+func NewAdminClientFromEnv() (*AdminClient, error) {
+	keycloakURL := os.Getenv("KEYCLOAK_URL")
+	clientID := os.Getenv("KEYCLOAK_CLIENT_ID")
+	clientSecret := os.Getenv("KEYCLOAK_CLIENT_SECRET")
+
+	if keycloakURL == "" {
+		return nil, fmt.Errorf("KEYCLOAK_URL environment variable is not set")
+	}
+	if clientID == "" {
+		return nil, fmt.Errorf("KEYCLOAK_CLIENT_ID environment variable is not set")
+	}
+	if clientSecret == "" {
+		return nil, fmt.Errorf("KEYCLOAK_CLIENT_SECRET environment variable is not set")
+	}
+	return NewAdminClient(keycloakURL, clientID, clientSecret), nil
+}
+
+func (c *AdminClient) CreateRealm(ctx context.Context, realm Realm) error {
+	_, err := c.do(ctx, "POST", "/admin/realms", nil, realm, nil)
+	return err
+}
+
+func (c *AdminClient) ListUsers(ctx context.Context, realm string) ([]User, error) {
+	var users []User
+	query := url.Values{"briefRepresentation": {"false"}, "max": {"500"}}
+	_, err := c.do(ctx, "GET", realmPath(realm, "users"), query, nil, &users)
+	return users, err
+}
+
+func (c *AdminClient) GetUser(ctx context.Context, realm, id string) (*User, error) {
+	var user User
+	if _, err := c.do(ctx, "GET", realmPath(realm, "users", id), nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser creates a user and returns its id.
+func (c *AdminClient) CreateUser(ctx context.Context, realm string, user User) (string, error) {
+	header, err := c.do(ctx, "POST", realmPath(realm, "users"), nil, user, nil)
+	if err != nil {
+		return "", err
+	}
+	location := header.Get("Location")
+	if location == "" {
+		return "", errors.New("keycloak didn't return the location of the new user")
+	}
+	return path.Base(location), nil
+}
+
+// UpdateUser replaces the attributes of the user with the ID of user.
+func (c *AdminClient) UpdateUser(ctx context.Context, realm string, user User) error {
+	_, err := c.do(ctx, "PUT", realmPath(realm, "users", user.ID), nil, user, nil)
+	return err
+}
+
+func (c *AdminClient) DeleteUser(ctx context.Context, realm, id string) error {
+	_, err := c.do(ctx, "DELETE", realmPath(realm, "users", id), nil, nil, nil)
+	return err
+}
+
+// LogoutUser ends every session of the user in the realm.
+func (c *AdminClient) LogoutUser(ctx context.Context, realm, id string) error {
+	_, err := c.do(ctx, "POST", realmPath(realm, "users", id, "logout"), nil, nil, nil)
+	return err
+}
+
+func (c *AdminClient) GetRealmRole(ctx context.Context, realm, name string) (*Role, error) {
+	var role Role
+	if _, err := c.do(ctx, "GET", realmPath(realm, "roles", name), nil, nil, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetRoleUsers returns the users that have a realm role.
+func (c *AdminClient) GetRoleUsers(ctx context.Context, realm, role string) ([]User, error) {
+	var users []User
+	_, err := c.do(ctx, "GET", realmPath(realm, "roles", role, "users"), url.Values{"max": {"500"}}, nil, &users)
+	return users, err
+}
+
+func (c *AdminClient) GetUserRealmRoles(ctx context.Context, realm, userID string) ([]Role, error) {
+	var roles []Role
+	_, err := c.do(ctx, "GET", realmPath(realm, "users", userID, "role-mappings", "realm"), nil, nil, &roles)
+	return roles, err
+}
+
+// GetUserCompositeRealmRoles returns the effective realm roles of a user, the roles mapped to
+// them directly and the ones they get through composite roles and groups.
+func (c *AdminClient) GetUserCompositeRealmRoles(ctx context.Context, realm, userID string) ([]Role, error) {
+	var roles []Role
+	_, err := c.do(ctx, "GET", realmPath(realm, "users", userID, "role-mappings", "realm", "composite"), nil, nil, &roles)
+	return roles, err
+}
+
+func (c *AdminClient) AddUserRealmRoles(ctx context.Context, realm, userID string, roles []Role) error {
+	_, err := c.do(ctx, "POST", realmPath(realm, "users", userID, "role-mappings", "realm"), nil, roles, nil)
+	return err
+}
+
+func (c *AdminClient) RemoveUserRealmRoles(ctx context.Context, realm, userID string, roles []Role) error {
+	_, err := c.do(ctx, "DELETE", realmPath(realm, "users", userID, "role-mappings", "realm"), nil, roles, nil)
+	return err
+}
+
+// SendActionsEmail emails the user a link to perform the required actions, like setting a
+// password, and then continue to redirectURI through the OIDC client.
+func (c *AdminClient) SendActionsEmail(ctx context.Context, realm, userID string, actions []string, clientID, redirectURI string) error {
+	query := url.Values{"client_id": {clientID}, "redirect_uri": {redirectURI}}
+	_, err := c.do(ctx, "PUT", realmPath(realm, "users", userID, "execute-actions-email"), query, actions, nil)
+	return err
+}
+
+func (c *AdminClient) do(ctx context.Context, method, endpoint string, query url.Values, body, out interface{}) (http.Header, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with Keycloak: %w", err)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	reqURL := c.baseURL + endpoint
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// accessToken returns the service account token, fetching a new one shortly before the
+// current one expires.
+func (c *AdminClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/realms/master/protocol/openid-connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to fetch access token, status: %s, response: %s", resp.Status, string(respBody))
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	c.token = tokenResponse.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(tokenResponse.ExpiresIn)*time.Second - 30*time.Second)
+	return c.token, nil
+}
+
+func realmPath(realm string, parts ...string) string {
+	escaped := []string{"/admin/realms", url.PathEscape(realm)}
+	for _, part := range parts {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+	return strings.Join(escaped, "/")
+}
@@ -0,0 +1,397 @@
+package keycloak
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const fakeToken = "fake-admin-token"
+
+// ActionsEmail is an email the fake server would have sent for SendActionsEmail.
+type ActionsEmail struct {
+	Realm       string
+	UserID      string
+	Actions     []string
+	ClientID    string
+	RedirectURI string
+}
+
+// FakeServer is an in-memory stand-in for the parts of the Keycloak admin API AdminClient uses,
+// to be served with net/http/httptest.
+type FakeServer struct {
+	clientID     string
+	clientSecret string
+	router       *mux.Router
+
+	mu       sync.Mutex
+	realms   map[string]*fakeRealm
+	emails   []ActionsEmail
+	requests []string
+}
+
+type fakeRealm struct {
+	users     []*User
+	roles     map[string]Role
+	userRoles map[string]map[string]bool
+}
+
+func NewFakeServer(clientID, clientSecret string) *FakeServer {
+	s := &FakeServer{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		router:       mux.NewRouter(),
+		realms:       map[string]*fakeRealm{},
+	}
+
+	s.router.HandleFunc("/realms/master/protocol/openid-connect/token", s.token).Methods("POST")
+	admin := s.router.PathPrefix("/admin/realms").Subrouter()
+	admin.Use(s.authorize, s.record)
+	admin.HandleFunc("", s.createRealm).Methods("POST")
+	admin.HandleFunc("/{realm}/users", s.listUsers).Methods("GET")
+	admin.HandleFunc("/{realm}/users", s.createUser).Methods("POST")
+	admin.HandleFunc("/{realm}/users/{id}", s.getUser).Methods("GET")
+	admin.HandleFunc("/{realm}/users/{id}", s.updateUser).Methods("PUT")
+	admin.HandleFunc("/{realm}/users/{id}", s.deleteUser).Methods("DELETE")
+	admin.HandleFunc("/{realm}/users/{id}/logout", s.logoutUser).Methods("POST")
+	admin.HandleFunc("/{realm}/users/{id}/role-mappings/realm", s.userRoleMappings).Methods("GET", "POST", "DELETE")
+	// Roles of the fake have no composites, the effective roles of a user are the mapped ones
+	admin.HandleFunc("/{realm}/users/{id}/role-mappings/realm/composite", s.userRoleMappings).Methods("GET")
+	admin.HandleFunc("/{realm}/users/{id}/execute-actions-email", s.executeActionsEmail).Methods("PUT")
+	admin.HandleFunc("/{realm}/roles/{role}", s.getRole).Methods("GET")
+	admin.HandleFunc("/{realm}/roles/{role}/users", s.roleUsers).Methods("GET")
+	return s
+}
+
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// ActionsEmails returns the actions emails requested so far.
+func (s *FakeServer) ActionsEmails() []ActionsEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ActionsEmail(nil), s.emails...)
+}
+
+// Requests returns the admin API calls made so far as "METHOD /path", without the query.
+func (s *FakeServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *FakeServer) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.clientID || r.FormValue("client_secret") != s.clientSecret {
+		http.Error(w, `{"error":"unauthorized_client"}`, http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": fakeToken, "expires_in": 300})
+}
+
+func (s *FakeServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+			http.Error(w, "HTTP 401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *FakeServer) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *FakeServer) createRealm(w http.ResponseWriter, r *http.Request) {
+	var realm Realm
+	if err := json.NewDecoder(r.Body).Decode(&realm); err != nil || realm.Realm == "" {
+		http.Error(w, "invalid realm", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.realms[realm.Realm]; ok {
+		writeJSON(w, http.StatusConflict, map[string]string{"errorMessage": "Conflict detected. See logs for details"})
+		return
+	}
+
+	created := &fakeRealm{roles: map[string]Role{}, userRoles: map[string]map[string]bool{}}
+	if realm.Roles != nil {
+		for _, role := range realm.Roles.Realm {
+			role.ID = uuid.New().String()
+			created.roles[role.Name] = role
+		}
+	}
+	for _, user := range realm.Users {
+		roles := user.RealmRoles
+		user.RealmRoles = nil
+		user.Credentials = nil
+		user.ID = uuid.New().String()
+		user.Username = strings.ToLower(user.Username)
+		user.CreatedTimestamp = time.Now().UnixMilli()
+		created.users = append(created.users, &user)
+		created.userRoles[user.ID] = map[string]bool{}
+		for _, role := range roles {
+			created.userRoles[user.ID][role] = true
+		}
+	}
+	s.realms[realm.Realm] = created
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *FakeServer) listUsers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	realm, ok := s.realm(w, r)
+	if !ok {
+		return
+	}
+	users := []User{}
+	for _, user := range realm.users {
+		users = append(users, *user)
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *FakeServer) createUser(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.Username == "" {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	realm, ok := s.realm(w, r)
+	if !ok {
+		return
+	}
+	user.Username = strings.ToLower(user.Username)
+	for _, existing := range realm.users {
+		if existing.Username == user.Username {
+			writeJSON(w, http.StatusConflict, map[string]string{"errorMessage": "User exists with same username"})
+			return
+		}
+		if user.Email != "" && strings.EqualFold(existing.Email, user.Email) {
+			writeJSON(w, http.StatusConflict, map[string]string{"errorMessage": "User exists with same email"})
+			return
+		}
+	}
+
+	user.ID = uuid.New().String()
+	user.Credentials = nil
+	user.RealmRoles = nil
+	user.CreatedTimestamp = time.Now().UnixMilli()
+	realm.users = append(realm.users, &user)
+	realm.userRoles[user.ID] = map[string]bool{}
+
+	w.Header().Set("Location", r.URL.Path+"/"+user.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *FakeServer) getUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *FakeServer) updateUser(w http.ResponseWriter, r *http.Request) {
+	var update User
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	update.ID = user.ID
+	update.Username = user.Username
+	update.CreatedTimestamp = user.CreatedTimestamp
+	update.Credentials = nil
+	update.RealmRoles = nil
+	*user = update
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeServer) deleteUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	realm, user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	for i, existing := range realm.users {
+		if existing.ID == user.ID {
+			realm.users = append(realm.users[:i], realm.users[i+1:]...)
+			break
+		}
+	}
+	delete(realm.userRoles, user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeServer) logoutUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, _, ok := s.user(w, r); !ok {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeServer) userRoleMappings(w http.ResponseWriter, r *http.Request) {
+	var roles []Role
+	if r.Method != "GET" {
+		if err := json.NewDecoder(r.Body).Decode(&roles); err != nil {
+			http.Error(w, "invalid roles", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	realm, user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		mapped := []Role{}
+		for name := range realm.userRoles[user.ID] {
+			if role, ok := realm.roles[name]; ok {
+				mapped = append(mapped, role)
+			}
+		}
+		writeJSON(w, http.StatusOK, mapped)
+		return
+	case "POST":
+		for _, role := range roles {
+			if _, ok := realm.roles[role.Name]; !ok {
+				http.Error(w, "Role not found", http.StatusNotFound)
+				return
+			}
+		}
+		for _, role := range roles {
+			realm.userRoles[user.ID][role.Name] = true
+		}
+	case "DELETE":
+		for _, role := range roles {
+			delete(realm.userRoles[user.ID], role.Name)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeServer) executeActionsEmail(w http.ResponseWriter, r *http.Request) {
+	var actions []string
+	if err := json.NewDecoder(r.Body).Decode(&actions); err != nil {
+		http.Error(w, "invalid actions", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	if user.Email == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errorMessage": "User email missing"})
+		return
+	}
+	s.emails = append(s.emails, ActionsEmail{
+		Realm:       mux.Vars(r)["realm"],
+		UserID:      user.ID,
+		Actions:     actions,
+		ClientID:    r.URL.Query().Get("client_id"),
+		RedirectURI: r.URL.Query().Get("redirect_uri"),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FakeServer) getRole(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	realm, ok := s.realm(w, r)
+	if !ok {
+		return
+	}
+	role, ok := realm.roles[mux.Vars(r)["role"]]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Could not find role"})
+		return
+	}
+	writeJSON(w, http.StatusOK, role)
+}
+
+func (s *FakeServer) roleUsers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	realm, ok := s.realm(w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["role"]
+	if _, ok := realm.roles[name]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Could not find role"})
+		return
+	}
+	users := []User{}
+	for _, user := range realm.users {
+		if realm.userRoles[user.ID][name] {
+			users = append(users, *user)
+		}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// realm and user must be called with the lock held.
+func (s *FakeServer) realm(w http.ResponseWriter, r *http.Request) (*fakeRealm, bool) {
+	realm, ok := s.realms[mux.Vars(r)["realm"]]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Realm not found."})
+		return nil, false
+	}
+	return realm, true
+}
+
+func (s *FakeServer) user(w http.ResponseWriter, r *http.Request) (*fakeRealm, *User, bool) {
+	realm, ok := s.realm(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	for _, user := range realm.users {
+		if user.ID == mux.Vars(r)["id"] {
+			return realm, user, true
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+	return nil, nil, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
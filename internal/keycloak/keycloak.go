@@ -1,104 +1,99 @@
 package keycloak
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"strings"
 )
 
-func CreateKeycloakTenant(tenantName, adminUsername, adminPassword string) error {
-	// Get Keycloak Admin URL and credentials from environment variables
-	keycloakURL := os.Getenv("KEYCLOAK_URL")
-	clientID := os.Getenv("KEYCLOAK_CLIENT_ID")
-	clientSecret := os.Getenv("KEYCLOAK_CLIENT_SECRET")
+// OIDCClient is the OIDC client registered in every tenant realm, staff sign in to the service
+// through it.
+type OIDCClient struct {
+	ClientID              string
+	Secret                string
+	RedirectURI           string
+	PostLogoutRedirectURI string
+}
 
-	// Validate required environment variables
-	if keycloakURL == "" {
-		return fmt.Errorf("KEYCLOAK_URL environment variable is not set")
-	}
-	if clientID == "" {
-		return fmt.Errorf("KEYCLOAK_CLIENT_ID environment variable is not set")
-	}
-	if clientSecret == "" {
-		return fmt.Errorf("KEYCLOAK_CLIENT_SECRET environment variable is not set")
+// StaffRoles are the realm roles created in every tenant realm, the tenant admin is made its
+// owner.
+var StaffRoles = []Role{
+	{Name: "owner", Description: "Manages the business, its venues and staff"},
+	{Name: "manager", Description: "Manages venues, menus and settings"},
+	{Name: "waiter", Description: "Handles tables, the waitlist and reservations"},
+	{Name: "kitchen", Description: "Follows and reprints order tickets"},
+}
+
+// GeneratePassword returns a random password to hand to the owner of a new tenant.
+func GeneratePassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	// Authenticate with Keycloak to get an access token
-	token, err := getKeycloakAccessToken(keycloakURL, clientID, clientSecret)
+func CreateKeycloakTenant(tenantName, adminUsername, adminPassword string, oidcClient OIDCClient) error {
+	client, err := NewAdminClientFromEnv()
 	if err != nil {
-		return fmt.Errorf("failed to authenticate with Keycloak: %v", err)
+		return err
+	}
+	if oidcClient.ClientID == "" || oidcClient.Secret == "" {
+		return fmt.Errorf("OIDC client id and secret are required")
 	}
 
 	// Create a new realm
-	realm := map[string]interface{}{
-		"id":      tenantName,
-		"realm":   tenantName,
-		"enabled": true,
-		"users": []map[string]interface{}{
+	realm := Realm{
+		Realm:   tenantName,
+		Enabled: true,
+		Users: []User{
 			{
-				"username":    adminUsername,
-				"enabled":     true,
-				"credentials": []map[string]string{{"type": "password", "value": adminPassword}},
+				Username: adminUsername,
+				Enabled:  true,
+				// The password is only good for the first sign in, the owner picks their own
+				Credentials:     []Credential{{Type: "password", Value: adminPassword, Temporary: true}},
+				RequiredActions: []string{"UPDATE_PASSWORD"},
+				RealmRoles:      []string{"owner"},
+			},
+		},
+		Roles: &RealmRoles{Realm: StaffRoles},
+		Clients: []OIDCClientRepresentation{
+			{
+				ClientID:                  oidcClient.ClientID,
+				Enabled:                   true,
+				Protocol:                  "openid-connect",
+				PublicClient:              false,
+				Secret:                    oidcClient.Secret,
+				StandardFlowEnabled:       true,
+				DirectAccessGrantsEnabled: false,
+				RedirectURIs:              []string{oidcClient.RedirectURI},
+				Attributes: map[string]string{
+					"post.logout.redirect.uris": oidcClient.PostLogoutRedirectURI,
+				},
+				// Roles are read from the ID token, Keycloak only adds them to access tokens
+				// by default
+				ProtocolMappers: []ProtocolMapper{
+					{
+						Name:           "realm roles",
+						Protocol:       "openid-connect",
+						ProtocolMapper: "oidc-usermodel-realm-role-mapper",
+						Config: map[string]string{
+							"claim.name":           "realm_access.roles",
+							"multivalued":          "true",
+							"jsonType.label":       "String",
+							"id.token.claim":       "true",
+							"access.token.claim":   "true",
+							"userinfo.token.claim": "true",
+						},
+					},
+				},
 			},
 		},
 	}
 
-	body, err := json.Marshal(realm)
-	if err != nil {
-		return fmt.Errorf("failed to marshal realm data: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/admin/realms", keycloakURL), strings.NewReader(string(body)))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create realm, status: %s, response: %s", resp.Status, string(respBody))
+	if err := client.CreateRealm(context.Background(), realm); err != nil {
+		return fmt.Errorf("failed to create realm: %w", err)
 	}
-
 	return nil
 }
-
-func getKeycloakAccessToken(keycloakURL, clientID, clientSecret string) (string, error) {
-	data := fmt.Sprintf("client_id=%s&client_secret=%s&grant_type=client_credentials", clientID, clientSecret)
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/realms/master/protocol/openid-connect/token", keycloakURL), strings.NewReader(data))
-	if err != nil {
-		return "", fmt.Errorf("failed to create token request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send token request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to fetch access token, status: %s, response: %s", resp.Status, string(respBody))
-	}
-
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-		return "", fmt.Errorf("failed to decode token response: %v", err)
-	}
-
-	return tokenResponse.AccessToken, nil
-}
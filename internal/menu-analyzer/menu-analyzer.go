@@ -50,6 +50,8 @@ type analysisMessage struct {
 type AnalysisData struct {
 	ID                primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
 	VenueId           primitive.ObjectID     `json:"venueId,omitempty" bson:"venueId"`
+	TenantID          string                 `json:"tenantId,omitempty" bson:"tenant_id,omitempty"`
+	Name              string                 `json:"name,omitempty" bson:"name,omitempty"`
 	VisionResult      map[string]interface{} `json:"visionResult,omitempty" bson:"visionResult,omitempty"`
 	RawCategoryResult string                 `json:"rawCategoryResult,omitempty" bson:"rawCategoryResult,omitempty"`
 	CategoryResult    structs.MenuData       `json:"categoryResult,omitempty" bson:"categoryResult,omitempty"`
@@ -180,7 +182,7 @@ func mapCategoryResult(am *analysisMessage) stage {
 
 	am.AnalysisData.CategoryResult = categoryMap
 
-	return onSuccess
+	return translationAnalysis
 }
 
 // mapToJSON converts a map[string]interface{} to its JSON string representation.
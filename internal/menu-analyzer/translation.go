@@ -0,0 +1,153 @@
+package menu_analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vorticist/logger"
+	"vortex.studio/account/internal/structs"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// translationResult is the document the model is asked to return. Translated categories and items
+// are matched to the original menu by position.
+type translationResult struct {
+	Language     string                          `json:"language"`
+	Translations map[string]translatedCategories `json:"translations"`
+}
+
+type translatedCategories struct {
+	Categories []translatedCategory `json:"categories"`
+}
+
+type translatedCategory struct {
+	Name  string                    `json:"name"`
+	Items []structs.ItemTranslation `json:"items"`
+}
+
+// translationLanguages returns the languages configured in MENU_TRANSLATION_LANGUAGES, e.g. "en,es,fr".
+func translationLanguages() []string {
+	var langs []string
+	for _, lang := range strings.Split(os.Getenv("MENU_TRANSLATION_LANGUAGES"), ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// translationAnalysis detects the language of the categorized menu and machine-translates it
+// into the configured languages, when there are any. Problems are logged but never fail the
+// analysis, the menu is still usable in its original language.
+func translationAnalysis(am *analysisMessage) stage {
+	langs := translationLanguages()
+	if len(langs) == 0 && am.AnalysisData.CategoryResult.Language != "" {
+		return onSuccess
+	}
+	logger.Infof("translationAnalysis: %v", langs)
+
+	result, err := translateMenu(am.AnalysisData.CategoryResult, langs)
+	if err != nil {
+		logger.Errorf("error translating menu: %v", err)
+		return onSuccess
+	}
+
+	applyTranslations(&am.AnalysisData.CategoryResult, result)
+	return onSuccess
+}
+
+// translateMenu asks the model for the language of the menu and its translations into langs.
+// Without langs only the language is detected.
+func translateMenu(menu structs.MenuData, langs []string) (translationResult, error) {
+	var result translationResult
+	menuJSON, err := json.Marshal(menu)
+	if err != nil {
+		return result, fmt.Errorf("error marshalling menu: %w", err)
+	}
+
+	prompt := "Detect the language of this restaurant menu. " +
+		"Return only a raw json object with the shape {\"language\": \"<ISO 639-1 code of the menu>\"} and omit any additional comments or explanations."
+	if len(langs) > 0 {
+		prompt = fmt.Sprintf("Translate the category names, item names and item descriptions of this restaurant menu into these languages: %s. "+
+			"Return only a raw json object with the shape {\"language\": \"<ISO 639-1 code of the original menu>\", \"translations\": {\"<language code>\": {\"categories\": [{\"name\": \"...\", \"items\": [{\"name\": \"...\", \"description\": \"...\"}]}]}}}. "+
+			"Keep categories and items in the same order as the original, skip the original language and omit any additional comments or explanations.",
+			strings.Join(langs, ", "))
+	}
+
+	client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	resp, err := client.CreateChatCompletion(
+		context.Background(),
+		openai.ChatCompletionRequest{
+			Model: openai.GPT4o,
+			Messages: []openai.ChatCompletionMessage{
+				{
+					Role:    openai.ChatMessageRoleUser,
+					Content: prompt,
+				},
+				{
+					Role:    openai.ChatMessageRoleUser,
+					Content: string(menuJSON),
+				},
+			},
+		},
+	)
+	if err != nil {
+		return result, fmt.Errorf("ChatCompletion error: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return result, fmt.Errorf("ChatCompletion returned no choices")
+	}
+
+	raw := resp.Choices[0].Message.Content
+	raw = strings.Replace(raw, "```json", "", -1)
+	raw = strings.Replace(raw, "```", "", -1)
+
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return result, fmt.Errorf("error parsing translation result: %w", err)
+	}
+	return result, nil
+}
+
+func applyTranslations(menu *structs.MenuData, result translationResult) {
+	if menu.Language == "" {
+		menu.Language = strings.ToLower(result.Language)
+	}
+
+	for lang, translated := range result.Translations {
+		lang = strings.ToLower(lang)
+		if lang == menu.Language {
+			continue
+		}
+
+		for ci := range menu.Categories {
+			if ci >= len(translated.Categories) {
+				break
+			}
+			category := &menu.Categories[ci]
+			tc := translated.Categories[ci]
+
+			if tc.Name != "" {
+				if category.Translations == nil {
+					category.Translations = map[string]string{}
+				}
+				category.Translations[lang] = tc.Name
+			}
+
+			for ii := range category.Items {
+				if ii >= len(tc.Items) {
+					break
+				}
+				item := &category.Items[ii]
+				if item.Translations == nil {
+					item.Translations = map[string]structs.ItemTranslation{}
+				}
+				item.Translations[lang] = tc.Items[ii]
+			}
+		}
+	}
+}
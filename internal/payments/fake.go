@@ -0,0 +1,115 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeProvider is a local stand-in for a hosted checkout provider. Its checkout page is served by
+// this service and completing it produces a webhook request signed exactly like a real one.
+type FakeProvider struct {
+	baseURL       string
+	webhookSecret string
+
+	mu        sync.Mutex
+	checkouts map[string]CheckoutRequest
+}
+
+func NewFakeProvider(baseURL string) *FakeProvider {
+	return &FakeProvider{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		webhookSecret: uuid.New().String(),
+		checkouts:     map[string]CheckoutRequest{},
+	}
+}
+
+func (p *FakeProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	id := "fake_" + uuid.New().String()
+
+	p.mu.Lock()
+	p.checkouts[id] = req
+	p.mu.Unlock()
+
+	return &Checkout{ID: id, URL: fmt.Sprintf("%s/payments/fake/%s", p.baseURL, id)}, nil
+}
+
+// Checkout returns a pending checkout created by CreateCheckout.
+func (p *FakeProvider) Checkout(id string) (CheckoutRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.checkouts[id]
+	return req, ok
+}
+
+// CompleteCheckout marks a checkout as paid and returns the signed webhook request the provider
+// would send for it.
+func (p *FakeProvider) CompleteCheckout(id string) (*http.Request, error) {
+	p.mu.Lock()
+	req, ok := p.checkouts[id]
+	delete(p.checkouts, id)
+	p.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown checkout: %s", id)
+	}
+
+	payload, err := json.Marshal(fakeWebhookPayload{
+		Type:       EventCheckoutCompleted,
+		CheckoutID: id,
+		Reference:  req.Reference,
+		Amount:     req.Amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := http.NewRequest("POST", p.baseURL+"/payments/webhook", strings.NewReader(string(payload)))
+	if err != nil {
+		return nil, err
+	}
+	webhook.Header.Set("Content-Type", "application/json")
+	webhook.Header.Set("X-Fake-Signature", sign(p.webhookSecret, time.Now(), payload))
+	return webhook, nil
+}
+
+// CancelCheckout discards a pending checkout.
+func (p *FakeProvider) CancelCheckout(id string) {
+	p.mu.Lock()
+	delete(p.checkouts, id)
+	p.mu.Unlock()
+}
+
+func (p *FakeProvider) ParseWebhook(r *http.Request) (*WebhookEvent, error) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	if err := verifySignature(p.webhookSecret, r.Header.Get("X-Fake-Signature"), payload, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event fakeWebhookPayload
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook event: %w", err)
+	}
+	return &WebhookEvent{
+		Type:       event.Type,
+		CheckoutID: event.CheckoutID,
+		Reference:  event.Reference,
+		Amount:     event.Amount,
+	}, nil
+}
+
+type fakeWebhookPayload struct {
+	Type       string  `json:"type"`
+	CheckoutID string  `json:"checkout_id"`
+	Reference  string  `json:"reference"`
+	Amount     float64 `json:"amount"`
+}
@@ -0,0 +1,120 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHostedCheckoutURL = "https://api.stripe.com"
+
+// HostedCheckoutProvider talks to a provider exposing a Stripe-compatible Checkout Sessions API:
+// checkouts are created with a form encoded POST to /v1/checkout/sessions and completion is
+// reported through webhooks signed with a shared secret.
+type HostedCheckoutProvider struct {
+	apiURL        string
+	apiKey        string
+	webhookSecret string
+	client        *http.Client
+}
+
+func NewHostedCheckoutProvider(apiURL, apiKey, webhookSecret string) *HostedCheckoutProvider {
+	if apiURL == "" {
+		apiURL = defaultHostedCheckoutURL
+	}
+	return &HostedCheckoutProvider{
+		apiURL:        strings.TrimSuffix(apiURL, "/"),
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *HostedCheckoutProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("success_url", req.SuccessURL)
+	form.Set("cancel_url", req.CancelURL)
+	form.Set("client_reference_id", req.Reference)
+	form.Set("metadata[reference]", req.Reference)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", req.Currency)
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(ToMinorUnits(req.Amount), 10))
+	form.Set("line_items[0][price_data][product_data][name]", req.Description)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/v1/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkout request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send checkout request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to create checkout, status: %s, response: %s", resp.Status, string(respBody))
+	}
+
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode checkout response: %w", err)
+	}
+
+	return &Checkout{ID: session.ID, URL: session.URL}, nil
+}
+
+func (p *HostedCheckoutProvider) ParseWebhook(r *http.Request) (*WebhookEvent, error) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	if err := verifySignature(p.webhookSecret, r.Header.Get("Stripe-Signature"), payload, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID                string `json:"id"`
+				ClientReferenceID string `json:"client_reference_id"`
+				AmountTotal       int64  `json:"amount_total"`
+				PaymentStatus     string `json:"payment_status"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook event: %w", err)
+	}
+
+	webhookEvent := &WebhookEvent{
+		Type:       event.Type,
+		CheckoutID: event.Data.Object.ID,
+		Reference:  event.Data.Object.ClientReferenceID,
+		Amount:     float64(event.Data.Object.AmountTotal) / 100,
+	}
+	if event.Type == "checkout.session.completed" && event.Data.Object.PaymentStatus == "paid" {
+		webhookEvent.Type = EventCheckoutCompleted
+	}
+	return webhookEvent, nil
+}
+
+// ToMinorUnits converts an amount to cents.
+func ToMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
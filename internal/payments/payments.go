@@ -0,0 +1,135 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventCheckoutCompleted is the webhook event type sent once a checkout has been paid.
+const EventCheckoutCompleted = "checkout.completed"
+
+// signatureTolerance is how old a webhook signature may be before it is rejected.
+const signatureTolerance = 5 * time.Minute
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// CheckoutRequest describes the amount a guest is asked to pay. Reference is our own identifier
+// of what is being paid and comes back in the webhook event.
+type CheckoutRequest struct {
+	Reference   string
+	Amount      float64
+	Currency    string
+	Description string
+	SuccessURL  string
+	CancelURL   string
+}
+
+// Checkout is a payment page created by the provider the guest is redirected to.
+type Checkout struct {
+	ID  string
+	URL string
+}
+
+// WebhookEvent is a verified notification from the provider.
+type WebhookEvent struct {
+	Type       string
+	CheckoutID string
+	Reference  string
+	Amount     float64
+}
+
+// PaymentProvider creates hosted checkouts and verifies the webhooks they trigger.
+type PaymentProvider interface {
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error)
+	// ParseWebhook verifies the signature of a webhook request and decodes its event.
+	ParseWebhook(r *http.Request) (*WebhookEvent, error)
+}
+
+// NewProviderFromEnv configures the provider selected by PAYMENT_PROVIDER ("hosted" or "fake").
+// It returns nil when online payments are disabled. baseURL is the public URL of this service,
+// used by the fake provider to host its checkout page.
+func NewProviderFromEnv(baseURL string) (PaymentProvider, error) {
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "":
+		return nil, nil
+	case "hosted":
+		apiKey := os.Getenv("PAYMENT_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("PAYMENT_API_KEY environment variable is not set")
+		}
+		webhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+		if webhookSecret == "" {
+			return nil, fmt.Errorf("PAYMENT_WEBHOOK_SECRET environment variable is not set")
+		}
+		return NewHostedCheckoutProvider(os.Getenv("PAYMENT_API_URL"), apiKey, webhookSecret), nil
+	case "fake":
+		return NewFakeProvider(baseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider: %s", os.Getenv("PAYMENT_PROVIDER"))
+	}
+}
+
+// Currency returns the currency configured in PAYMENT_CURRENCY, defaulting to "usd".
+func Currency() string {
+	if currency := os.Getenv("PAYMENT_CURRENCY"); currency != "" {
+		return strings.ToLower(currency)
+	}
+	return "usd"
+}
+
+// sign computes the webhook signature header value for a payload, "t=<unix time>,v1=<hex hmac>".
+func sign(secret string, timestamp time.Time, payload []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, computeSignature(secret, ts, payload))
+}
+
+func computeSignature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks a "t=...,v1=..." signature header against the payload.
+func verifySignature(secret, header string, payload []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := computeSignature(secret, timestamp, payload)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
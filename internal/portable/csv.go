@@ -0,0 +1,218 @@
+package portable
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"vortex.studio/account/internal/structs"
+)
+
+// menuColumns are the columns of menu CSV files every item has. Translations go in extra
+// columns named after the translated column and the language, like "name:en".
+var menuColumns = []string{"category", "name", "description", "price", "station"}
+
+// translatedColumns are the columns translations can be given for.
+var translatedColumns = []string{"category", "name", "description"}
+
+// WriteMenuCSV writes the items of a menu as CSV, one row per item in menu order.
+func WriteMenuCSV(w io.Writer, menu structs.MenuData) error {
+	var langs []string
+	for _, lang := range menu.Languages() {
+		if lang != menu.Language {
+			langs = append(langs, lang)
+		}
+	}
+
+	header := append([]string{}, menuColumns...)
+	for _, lang := range langs {
+		for _, column := range translatedColumns {
+			header = append(header, column+":"+lang)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	for _, category := range menu.Categories {
+		for _, item := range category.Items {
+			row := []string{
+				category.Name,
+				item.Name,
+				item.Description,
+				strconv.FormatFloat(item.Price, 'f', -1, 64),
+				category.Station,
+			}
+			for _, lang := range langs {
+				translation := item.Translations[lang]
+				row = append(row, category.Translations[lang], translation.Name, translation.Description)
+			}
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadMenuCSV reads a menu written by WriteMenuCSV. Rows of the same category are grouped in
+// the order the category first appears. Every rejected row is returned with its line, the menu
+// is only usable when there are none.
+func ReadMenuCSV(r io.Reader) (structs.MenuData, []structs.ImportProblem) {
+	var menu structs.MenuData
+	var problems []structs.ImportProblem
+	add := func(row int, field, message string, args ...interface{}) {
+		problems = append(problems, structs.ImportProblem{Row: row, Field: field, Message: fmt.Sprintf(message, args...)})
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		add(0, "", "the file is empty")
+		return menu, problems
+	}
+	if err != nil {
+		add(1, "", "the header can't be read: %v", err)
+		return menu, problems
+	}
+
+	columns := map[string]int{}
+	var langs []string
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, seen := columns[column]; seen {
+			add(1, column, "the column appears twice")
+			continue
+		}
+		columns[column] = i
+		name, lang, translated := strings.Cut(column, ":")
+		switch {
+		case !translated:
+			if !knownColumn(menuColumns, name) {
+				add(1, column, "unknown column")
+			}
+		case lang == "" || !knownColumn(translatedColumns, name):
+			add(1, column, "unknown column")
+		case !knownColumn(langs, lang):
+			langs = append(langs, lang)
+		}
+	}
+	for _, column := range []string{"category", "name", "price"} {
+		if _, ok := columns[column]; !ok {
+			add(1, column, "the column is missing")
+		}
+	}
+	if len(problems) > 0 {
+		return menu, problems
+	}
+
+	categories := map[string]int{}
+	items := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				add(parseErr.StartLine, "", "the row can't be read: %v", parseErr.Err)
+				continue
+			}
+			add(0, "", "the file can't be read: %v", err)
+			break
+		}
+		row, _ := reader.FieldPos(0)
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		categoryName, name := value("category"), value("name")
+		if categoryName == "" && name == "" && value("price") == "" {
+			// Blank lines spreadsheets leave at the end
+			continue
+		}
+		rejected := false
+		if categoryName == "" {
+			add(row, "category", "items need a category")
+			rejected = true
+		}
+		if name == "" {
+			add(row, "name", "items need a name")
+			rejected = true
+		}
+		price, err := strconv.ParseFloat(value("price"), 64)
+		if err != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+			add(row, "price", "%q isn't a price", value("price"))
+			rejected = true
+		} else if price < 0 {
+			add(row, "price", "the price can't be negative")
+			rejected = true
+		}
+		key := categoryName + "\x00" + name
+		if first, ok := items[key]; ok && name != "" {
+			add(row, "name", "%v is already in %v on row %v", name, categoryName, first)
+			rejected = true
+		}
+		if rejected {
+			continue
+		}
+		items[key] = row
+
+		ci, ok := categories[categoryName]
+		if !ok {
+			ci = len(menu.Categories)
+			categories[categoryName] = ci
+			menu.Categories = append(menu.Categories, structs.Category{Name: categoryName})
+		}
+		category := &menu.Categories[ci]
+		if station := value("station"); station != "" {
+			station = strings.ToLower(station)
+			if category.Station != "" && category.Station != station {
+				add(row, "station", "%v is prepared at %v on an earlier row", categoryName, category.Station)
+				continue
+			}
+			category.Station = station
+		}
+
+		item := structs.MenuItem{Name: name, Description: value("description"), Price: price}
+		for _, lang := range langs {
+			if translated := value("category:" + lang); translated != "" {
+				if category.Translations == nil {
+					category.Translations = map[string]string{}
+				}
+				category.Translations[lang] = translated
+			}
+			translation := structs.ItemTranslation{Name: value("name:" + lang), Description: value("description:" + lang)}
+			if translation.Name == "" && translation.Description == "" {
+				continue
+			}
+			if item.Translations == nil {
+				item.Translations = map[string]structs.ItemTranslation{}
+			}
+			item.Translations[lang] = translation
+		}
+		category.Items = append(category.Items, item)
+	}
+
+	if len(problems) == 0 && len(menu.Categories) == 0 {
+		add(0, "", "the file has no items")
+	}
+	return menu, problems
+}
+
+func knownColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
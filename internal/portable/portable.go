@@ -0,0 +1,312 @@
+// Package portable moves venues and menus in and out of the service: venue documents in JSON or
+// YAML with the tables, settings and menu of a venue, and CSV files with the items of a menu.
+//
+// Documents leave out whatever only means something in the tenant they come from, like ids,
+// table codes and images. Importing them creates a new venue with new table codes.
+package portable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+	"vortex.studio/account/internal/qr"
+	"vortex.studio/account/internal/structs"
+)
+
+// Version is the version of the documents this package writes and the newest it reads.
+const Version = 1
+
+// Formats are the formats venue documents can be written in.
+var Formats = []string{"json", "yaml"}
+
+// Document is a venue with its tables, settings and menu.
+type Document struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Venue      Venue            `json:"venue"`
+	Menu       structs.MenuData `json:"menu"`
+}
+
+type Venue struct {
+	Name         string                      `json:"name"`
+	Description  string                      `json:"description,omitempty"`
+	Address      string                      `json:"address,omitempty"`
+	Phone        string                      `json:"phone,omitempty"`
+	TaxID        string                      `json:"tax_id,omitempty"`
+	TaxRate      float64                     `json:"tax_rate,omitempty"`
+	Timezone     string                      `json:"timezone,omitempty"`
+	Tables       []Table                     `json:"tables,omitempty"`
+	Floor        structs.FloorPlan           `json:"floor"`
+	Reservations structs.ReservationSettings `json:"reservations"`
+	Pickup       structs.PickupSettings      `json:"pickup"`
+	Printers     []Printer                   `json:"printers,omitempty"`
+	QR           structs.QRSettings          `json:"qr"`
+	// MenuOverrides are the prices and availability of the items of the menu at this venue
+	MenuOverrides []structs.MenuOverride `json:"menu_overrides,omitempty"`
+}
+
+// Table is a table of a venue without its code, imported tables get a new one.
+type Table struct {
+	Label   string               `json:"label"`
+	Section string               `json:"section,omitempty"`
+	Retired bool                 `json:"retired,omitempty"`
+	Layout  *structs.TableLayout `json:"layout,omitempty"`
+}
+
+type Printer struct {
+	Name    string `json:"name"`
+	Station string `json:"station"`
+	Address string `json:"address"`
+}
+
+// FromVenue returns the document of a venue and its menu.
+func FromVenue(venue structs.Venue, menu structs.MenuData) Document {
+	doc := Document{
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Venue: Venue{
+			Name:         venue.Name,
+			Description:  venue.Description,
+			Address:      venue.Address,
+			Phone:        venue.Phone,
+			TaxID:        venue.TaxID,
+			TaxRate:      venue.TaxRate,
+			Timezone:     venue.Timezone,
+			Floor:        venue.Floor,
+			Reservations: venue.Reservations,
+			Pickup:       venue.Pickup,
+			QR:           venue.QR,
+
+			MenuOverrides: venue.MenuOverrides,
+		},
+		Menu: withoutImages(menu),
+	}
+	// The logo is the venue image, which isn't part of the document
+	doc.Venue.QR.Logo = false
+	for _, table := range venue.TableCodes {
+		doc.Venue.Tables = append(doc.Venue.Tables, Table{
+			Label:   table.Name(),
+			Section: table.Section,
+			Retired: table.Retired,
+			Layout:  table.Layout,
+		})
+	}
+	for _, printer := range venue.Printers {
+		doc.Venue.Printers = append(doc.Venue.Printers, Printer{Name: printer.Name, Station: printer.Station, Address: printer.Address})
+	}
+	return doc
+}
+
+// Write encodes a document in one of the Formats.
+func Write(w io.Writer, doc Document, format string) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+	case "yaml":
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %v", format)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Read decodes a document written in any of the Formats. Fields the document format doesn't
+// know are rejected rather than silently dropped.
+func Read(r io.Reader) (Document, error) {
+	var doc Document
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return doc, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return doc, fmt.Errorf("the document is empty")
+	}
+	// JSON is valid YAML, so both formats go through the same decoder
+	if err := yaml.Unmarshal(data, &doc, yaml.DisallowUnknownFields); err != nil {
+		return doc, fmt.Errorf("the document can't be read: %w", err)
+	}
+	return doc, nil
+}
+
+// Validate returns everything wrong with a document, nothing when it can be imported.
+func (d Document) Validate() []structs.ImportProblem {
+	var problems []structs.ImportProblem
+	add := func(field, message string, args ...interface{}) {
+		problems = append(problems, structs.ImportProblem{Field: field, Message: fmt.Sprintf(message, args...)})
+	}
+
+	if d.Version < 1 || d.Version > Version {
+		add("version", "unsupported version %v, expected %v", d.Version, Version)
+	}
+
+	venue := d.Venue
+	if strings.TrimSpace(venue.Name) == "" {
+		add("venue.name", "the venue needs a name")
+	}
+	if venue.TaxRate < 0 || math.IsNaN(venue.TaxRate) {
+		add("venue.tax_rate", "the tax rate can't be negative")
+	}
+
+	columns, rows := venue.Floor.Size()
+	if columns > structs.MaxFloorSize || rows > structs.MaxFloorSize {
+		add("venue.floor", "the floor plan can have at most %v columns and rows", structs.MaxFloorSize)
+	}
+	labels := map[string]bool{}
+	cells := map[[2]int]string{}
+	for i, table := range venue.Tables {
+		field := fmt.Sprintf("venue.tables.%d", i)
+		label := strings.TrimSpace(table.Label)
+		if label == "" {
+			add(field+".label", "tables need a label")
+		} else if !table.Retired {
+			if labels[label] {
+				add(field+".label", "another table is already called %v", label)
+			}
+			labels[label] = true
+		}
+		if table.Layout == nil || table.Retired {
+			continue
+		}
+		if table.Layout.X < 0 || table.Layout.Y < 0 || table.Layout.X >= columns || table.Layout.Y >= rows {
+			add(field+".layout", "table %v is off the floor plan", label)
+			continue
+		}
+		if !validShape(table.Layout.Shape) {
+			add(field+".layout.shape", "table %v has an unknown shape %v", label, table.Layout.Shape)
+		}
+		if table.Layout.Seats < 0 {
+			add(field+".layout.seats", "table %v can't have negative seats", label)
+		}
+		cell := [2]int{table.Layout.X, table.Layout.Y}
+		if other, taken := cells[cell]; taken {
+			add(field+".layout", "table %v is on the same spot as table %v", label, other)
+		}
+		cells[cell] = label
+	}
+
+	if venue.Timezone != "" {
+		if _, err := time.LoadLocation(venue.Timezone); err != nil {
+			add("venue.timezone", "unknown time zone %v", venue.Timezone)
+		}
+	}
+
+	reservations := venue.Reservations
+	if reservations.Timezone != "" {
+		if _, err := time.LoadLocation(reservations.Timezone); err != nil {
+			add("venue.reservations.timezone", "unknown time zone %v", reservations.Timezone)
+		}
+	}
+	if reservations.Enabled || reservations.OpensAt != "" || reservations.ClosesAt != "" {
+		_, opens := time.Parse("15:04", reservations.OpensAt)
+		_, closes := time.Parse("15:04", reservations.ClosesAt)
+		if opens != nil || closes != nil {
+			add("venue.reservations", "opening hours must be HH:MM times")
+		}
+	}
+	if reservations.SlotMinutes < 0 || reservations.DurationMinutes < 0 || reservations.HoldMinutes < 0 {
+		add("venue.reservations", "minutes can't be negative")
+	} else if reservations.Enabled && (reservations.SlotMinutes == 0 || reservations.DurationMinutes == 0) {
+		add("venue.reservations", "slot and booking length must be at least one minute")
+	}
+	if venue.Pickup.PrepMinutes < 0 {
+		add("venue.pickup.prep_minutes", "the preparation time can't be negative")
+	}
+
+	if venue.QR.Size != 0 && (venue.QR.Size < qr.MinSize || venue.QR.Size > qr.MaxSize) {
+		add("venue.qr.size", "the size must be between %v and %v pixels", qr.MinSize, qr.MaxSize)
+	}
+	if _, err := qr.VenueOptions(venue.QR, nil); err != nil {
+		add("venue.qr", "%v", err)
+	}
+
+	for i, printer := range venue.Printers {
+		if strings.TrimSpace(printer.Name) == "" || strings.TrimSpace(printer.Address) == "" {
+			add(fmt.Sprintf("venue.printers.%d", i), "printers need a name and an address")
+		}
+	}
+
+	for i, override := range venue.MenuOverrides {
+		if override.Price != nil && (*override.Price < 0 || math.IsNaN(*override.Price) || math.IsInf(*override.Price, 0)) {
+			add(fmt.Sprintf("venue.menu_overrides.%d.price", i), "the price of %v can't be negative", override.Item)
+		}
+	}
+
+	for _, problem := range ValidateMenu(d.Menu) {
+		problem.Field = "menu." + problem.Field
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// ValidateMenu returns everything wrong with a menu, nothing when it can be used.
+func ValidateMenu(menu structs.MenuData) []structs.ImportProblem {
+	var problems []structs.ImportProblem
+	add := func(field, message string, args ...interface{}) {
+		problems = append(problems, structs.ImportProblem{Field: field, Message: fmt.Sprintf(message, args...)})
+	}
+
+	categories := map[string]bool{}
+	for i, category := range menu.Categories {
+		field := fmt.Sprintf("categories.%d", i)
+		name := strings.TrimSpace(category.Name)
+		if name == "" {
+			add(field+".name", "categories need a name")
+		} else if categories[name] {
+			add(field+".name", "category %v appears twice", name)
+		}
+		categories[name] = true
+
+		items := map[string]bool{}
+		for j, item := range category.Items {
+			field := fmt.Sprintf("%s.items.%d", field, j)
+			itemName := strings.TrimSpace(item.Name)
+			if itemName == "" {
+				add(field+".name", "items need a name")
+			} else if items[itemName] {
+				add(field+".name", "%v appears twice in %v", itemName, name)
+			}
+			items[itemName] = true
+			if item.Price < 0 || math.IsNaN(item.Price) || math.IsInf(item.Price, 0) {
+				add(field+".price", "the price of %v can't be negative", itemName)
+			}
+		}
+	}
+	return problems
+}
+
+func validShape(shape string) bool {
+	for _, s := range structs.TableShapes {
+		if s == shape {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutImages returns a copy of a menu without the images of its items.
+func withoutImages(menu structs.MenuData) structs.MenuData {
+	categories := make([]structs.Category, len(menu.Categories))
+	for i, category := range menu.Categories {
+		items := make([]structs.MenuItem, len(category.Items))
+		for j, item := range category.Items {
+			item.Image, item.Thumbnail = "", ""
+			items[j] = item
+		}
+		category.Items = items
+		categories[i] = category
+	}
+	menu.Categories = categories
+	return menu
+}
@@ -0,0 +1,110 @@
+package printing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"vortex.studio/account/internal/structs"
+)
+
+// lineWidth is the number of characters of font A on 80mm paper
+const lineWidth = 48
+
+var (
+	cmdInit        = []byte{0x1b, 0x40}
+	cmdCodePage858 = []byte{0x1b, 0x74, 19}
+	cmdAlignLeft   = []byte{0x1b, 0x61, 0}
+	cmdAlignCenter = []byte{0x1b, 0x61, 1}
+	cmdBoldOn      = []byte{0x1b, 0x45, 1}
+	cmdBoldOff     = []byte{0x1b, 0x45, 0}
+	cmdSizeNormal  = []byte{0x1d, 0x21, 0x00}
+	cmdSizeDouble  = []byte{0x1d, 0x21, 0x11}
+	cmdSizeTall    = []byte{0x1d, 0x21, 0x01}
+	cmdFeedAndCut  = []byte{0x1d, 0x56, 0x42, 3}
+)
+
+// encoder converts text to code page 858, the Western European page most thermal printers ship
+// with, replacing characters it lacks.
+var encoder = encoding.ReplaceUnsupported(charmap.CodePage858.NewEncoder())
+
+// Render returns the ESC/POS commands that print a ticket and cut the paper.
+func Render(ticket structs.Ticket) []byte {
+	var b bytes.Buffer
+	b.Write(cmdInit)
+	b.Write(cmdCodePage858)
+
+	b.Write(cmdAlignCenter)
+	if ticket.Reprint {
+		b.Write(cmdBoldOn)
+		writeLine(&b, "*** REPRINT ***")
+		b.Write(cmdBoldOff)
+	}
+	b.Write(cmdSizeDouble)
+	writeLine(&b, strings.ToUpper(ticket.Station))
+	if ticket.OrderNumber != "" {
+		writeLine(&b, "PICKUP #"+ticket.OrderNumber)
+	} else {
+		writeLine(&b, "TABLE "+ticket.TableCode)
+	}
+	b.Write(cmdSizeNormal)
+	if ticket.CustomerName != "" {
+		writeLine(&b, ticket.CustomerName)
+	}
+	writeLine(&b, ticket.VenueName)
+	writeLine(&b, ticket.PlacedAt.Format("2006-01-02 15:04"))
+
+	b.Write(cmdAlignLeft)
+	writeLine(&b, strings.Repeat("-", lineWidth))
+	b.Write(cmdSizeTall)
+	for _, item := range ticket.Items {
+		writeLine(&b, fmt.Sprintf("%3dx %s", item.Amount, item.Name))
+	}
+	b.Write(cmdSizeNormal)
+	writeLine(&b, strings.Repeat("-", lineWidth))
+
+	b.Write(cmdFeedAndCut)
+	return b.Bytes()
+}
+
+func writeLine(b *bytes.Buffer, text string) {
+	encoded, err := encoder.String(text)
+	if err != nil {
+		encoded = text
+	}
+	b.WriteString(encoded)
+	b.WriteByte('\n')
+}
+
+// Decode returns the text of ESC/POS commands produced by Render, dropping the control sequences.
+// It is meant for inspecting tickets sent to the fake printer.
+func Decode(data []byte) string {
+	var text []byte
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case 0x1b:
+			// ESC @ takes no argument, the rest of the commands used take one
+			if i+1 < len(data) && data[i+1] == 0x40 {
+				i++
+			} else {
+				i += 2
+			}
+		case 0x1d:
+			// GS V 66 n takes two arguments, GS ! n takes one
+			if i+1 < len(data) && data[i+1] == 0x56 {
+				i += 3
+			} else {
+				i += 2
+			}
+		default:
+			text = append(text, data[i])
+		}
+	}
+	decoded, err := charmap.CodePage858.NewDecoder().Bytes(text)
+	if err != nil {
+		return string(text)
+	}
+	return string(decoded)
+}
@@ -0,0 +1,108 @@
+package printing
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// FakePrinter is a network printer stand-in that keeps what it receives, for tests and local
+// development. Every connection is one ticket.
+type FakePrinter struct {
+	addr string
+
+	mu       sync.Mutex
+	listener net.Listener
+	tickets  [][]byte
+
+	// OnTicket is called with every ticket received
+	OnTicket func(data []byte)
+}
+
+// NewFakePrinter starts a fake printer listening at address, e.g. ":9100" or "127.0.0.1:0".
+func NewFakePrinter(address string) (*FakePrinter, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	p := &FakePrinter{addr: listener.Addr().String(), listener: listener}
+	go p.serve(listener)
+	return p, nil
+}
+
+// Addr returns the address the printer listens at.
+func (p *FakePrinter) Addr() string {
+	return p.addr
+}
+
+// Tickets returns the raw tickets received so far.
+func (p *FakePrinter) Tickets() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte{}, p.tickets...)
+}
+
+// SetFailing makes the printer refuse connections, like a printer that is switched off or
+// unplugged, until it is set back. Sends to a failing printer fail when connecting, a printer
+// that took the connection can't make the sender notice anything went wrong.
+func (p *FakePrinter) SetFailing(fail bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fail {
+		if p.listener == nil {
+			return nil
+		}
+		err := p.listener.Close()
+		p.listener = nil
+		return err
+	}
+
+	if p.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	go p.serve(listener)
+	return nil
+}
+
+func (p *FakePrinter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	err := p.listener.Close()
+	p.listener = nil
+	return err
+}
+
+func (p *FakePrinter) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *FakePrinter) handle(conn net.Conn) {
+	defer conn.Close()
+
+	data, err := io.ReadAll(conn)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	p.tickets = append(p.tickets, data)
+	onTicket := p.OnTicket
+	p.mu.Unlock()
+	if onTicket != nil {
+		onTicket(data)
+	}
+}
@@ -0,0 +1,39 @@
+package printing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultPort is the raw TCP port ("JetDirect") of network receipt printers
+const DefaultPort = "9100"
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+// Send writes data to the printer listening at address. Addresses without a port use DefaultPort.
+func Send(ctx context.Context, address string, data []byte) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, DefaultPort)
+	}
+
+	// Unreachable printers have to fail fast, the queue is waiting on them
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to printer %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write to printer %s: %w", address, err)
+	}
+	return nil
+}
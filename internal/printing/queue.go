@@ -0,0 +1,109 @@
+package printing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vorticist/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	// MaxAttempts is how many times a job is sent before it is marked failed
+	MaxAttempts = 5
+
+	pollInterval = 2 * time.Second
+	retryDelay   = 5 * time.Second
+	batchSize    = 20
+	// claimLease is how long a claimed job is left to its worker, long enough to send a whole
+	// batch to one printer. Jobs of a worker that stopped are sent again once it ends.
+	claimLease = batchSize * (dialTimeout + writeTimeout)
+)
+
+// JobStore keeps the print queue.
+type JobStore interface {
+	// ClaimDueJob atomically takes the oldest due job until lease ends, nil when none is due.
+	ClaimDueJob(ctx context.Context, now time.Time, lease time.Duration) (*structs.PrintJob, error)
+	MarkPrinted(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	MarkAttemptFailed(ctx context.Context, id primitive.ObjectID, attempts int, lastError string, next time.Time, failed bool) error
+}
+
+// Worker sends queued jobs to their printers, retrying failed jobs with an increasing delay.
+type Worker struct {
+	jobs JobStore
+	send func(ctx context.Context, address string, data []byte) error
+}
+
+func NewWorker(jobs JobStore) *Worker {
+	return &Worker{jobs: jobs, send: Send}
+}
+
+// Run processes the queue until the context is canceled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.processDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processDue claims a batch of due jobs and sends them, the jobs of each printer in order and
+// the printers in parallel, so an unreachable printer only holds up its own tickets.
+func (w *Worker) processDue(ctx context.Context) {
+	byPrinter := map[string][]structs.PrintJob{}
+	for i := 0; i < batchSize; i++ {
+		job, err := w.jobs.ClaimDueJob(ctx, time.Now(), claimLease)
+		if err != nil {
+			logger.Errorf("error claiming print job: %v", err)
+			break
+		}
+		if job == nil {
+			break
+		}
+		byPrinter[job.Address] = append(byPrinter[job.Address], *job)
+	}
+
+	var wg sync.WaitGroup
+	for _, jobs := range byPrinter {
+		wg.Add(1)
+		go func(jobs []structs.PrintJob) {
+			defer wg.Done()
+			for _, job := range jobs {
+				// The rest of the jobs of a printer that failed are sent again when their
+				// claim ends, without using up an attempt
+				if !w.process(ctx, job) {
+					return
+				}
+			}
+		}(jobs)
+	}
+	wg.Wait()
+}
+
+// process sends a job and reports whether it was printed.
+func (w *Worker) process(ctx context.Context, job structs.PrintJob) bool {
+	err := w.send(ctx, job.Address, Render(job.Ticket))
+	if err == nil {
+		if err := w.jobs.MarkPrinted(ctx, job.ID, time.Now()); err != nil {
+			logger.Errorf("error updating print job %v: %v", job.ID.Hex(), err)
+		}
+		return true
+	}
+
+	attempts := job.Attempts + 1
+	failed := attempts >= MaxAttempts
+	next := time.Now().Add(retryDelay * time.Duration(1<<(attempts-1)))
+	logger.Errorf("error printing job %v on %v (attempt %v): %v", job.ID.Hex(), job.PrinterName, attempts, err)
+	if err := w.jobs.MarkAttemptFailed(ctx, job.ID, attempts, err.Error(), next, failed); err != nil {
+		logger.Errorf("error updating print job %v: %v", job.ID.Hex(), err)
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package printing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"vortex.studio/account/internal/structs"
+)
+
+// memJobs is a JobStore in memory that updates jobs the way the print jobs repository does.
+type memJobs struct {
+	mu   sync.Mutex
+	jobs []structs.PrintJob
+}
+
+func (m *memJobs) ClaimDueJob(ctx context.Context, now time.Time, lease time.Duration) (*structs.PrintJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, job := range m.jobs {
+		due := job.Status == structs.PrintPending || job.Status == structs.PrintSending
+		if due && !job.NextAttemptAt.After(now) {
+			m.jobs[i].Status = structs.PrintSending
+			m.jobs[i].NextAttemptAt = now.Add(lease)
+			claimed := m.jobs[i]
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memJobs) MarkPrinted(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	m.update(id, func(job *structs.PrintJob) {
+		job.Status = structs.PrintPrinted
+		job.PrintedAt = at
+		job.Attempts++
+	})
+	return nil
+}
+
+func (m *memJobs) MarkAttemptFailed(ctx context.Context, id primitive.ObjectID, attempts int, lastError string, next time.Time, failed bool) error {
+	m.update(id, func(job *structs.PrintJob) {
+		job.Status = structs.PrintPending
+		if failed {
+			job.Status = structs.PrintFailed
+		}
+		job.Attempts = attempts
+		job.LastError = lastError
+		job.NextAttemptAt = next
+	})
+	return nil
+}
+
+func (m *memJobs) update(id primitive.ObjectID, change func(job *structs.PrintJob)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.jobs {
+		if m.jobs[i].ID == id {
+			change(&m.jobs[i])
+		}
+	}
+}
+
+// job returns the job at index i as it is now.
+func (m *memJobs) job(i int) structs.PrintJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[i]
+}
+
+// makeDue makes the job at index i due now, as if its retry delay had passed.
+func (m *memJobs) makeDue(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[i].NextAttemptAt = time.Time{}
+}
+
+func newTestPrinter(t *testing.T) (*FakePrinter, <-chan []byte) {
+	t.Helper()
+	printer, err := NewFakePrinter("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { printer.Close() })
+	received := make(chan []byte, 10)
+	printer.OnTicket = func(data []byte) { received <- data }
+	return printer, received
+}
+
+// waitTicket returns the next ticket the printer receives.
+func waitTicket(t *testing.T, received <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("the printer received no ticket")
+		return nil
+	}
+}
+
+var testTicket = structs.Ticket{
+	VenueName: "Café Niño",
+	Station:   "bar",
+	TableCode: "abc",
+	Items:     []structs.TicketItem{{Name: "Piña colada", Amount: 2}, {Name: "Water", Amount: 1}},
+	PlacedAt:  time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC),
+}
+
+func TestWorkerPrintsJob(t *testing.T) {
+	printer, received := newTestPrinter(t)
+	jobs := &memJobs{jobs: []structs.PrintJob{
+		{ID: primitive.NewObjectID(), Address: printer.Addr(), PrinterName: "Bar", Ticket: testTicket, Status: structs.PrintPending},
+	}}
+
+	NewWorker(jobs).processDue(context.Background())
+
+	data := waitTicket(t, received)
+	if !bytes.Equal(data, Render(testTicket)) {
+		t.Errorf("printer received\n%q\nwant\n%q", data, Render(testTicket))
+	}
+	if !bytes.HasPrefix(data, cmdInit) || !bytes.HasSuffix(data, cmdFeedAndCut) {
+		t.Errorf("ticket doesn't initialize the printer and cut the paper: %q", data)
+	}
+	// Code page 858 has the accented letters of the ticket
+	if !bytes.Contains(data, []byte("Pi\xa4a colada")) {
+		t.Errorf("ticket isn't encoded in code page 858: %q", data)
+	}
+	text := Decode(data)
+	for _, want := range []string{"Café Niño", "BAR", "2x Piña colada", "1x Water"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("ticket is missing %q:\n%s", want, text)
+		}
+	}
+
+	job := jobs.job(0)
+	if job.Status != structs.PrintPrinted || job.Attempts != 1 || job.PrintedAt.IsZero() {
+		t.Errorf("job = %+v, want it printed on the first attempt", job)
+	}
+}
+
+func TestWorkerRetriesFailedSend(t *testing.T) {
+	printer, received := newTestPrinter(t)
+	if err := printer.SetFailing(true); err != nil {
+		t.Fatal(err)
+	}
+	jobs := &memJobs{jobs: []structs.PrintJob{
+		{ID: primitive.NewObjectID(), Address: printer.Addr(), PrinterName: "Bar", Ticket: testTicket, Status: structs.PrintPending},
+	}}
+	worker := NewWorker(jobs)
+
+	before := time.Now()
+	worker.processDue(context.Background())
+	job := jobs.job(0)
+	if job.Status != structs.PrintPending || job.Attempts != 1 || job.LastError == "" {
+		t.Fatalf("job = %+v, want it pending after a failed attempt", job)
+	}
+	if job.NextAttemptAt.Before(before.Add(retryDelay)) {
+		t.Errorf("retry at %v, want it at least %v later", job.NextAttemptAt, retryDelay)
+	}
+
+	// Not due yet, nothing is sent
+	worker.processDue(context.Background())
+	if job := jobs.job(0); job.Attempts != 1 {
+		t.Fatalf("job was retried before its delay, attempts = %v", job.Attempts)
+	}
+
+	if err := printer.SetFailing(false); err != nil {
+		t.Fatal(err)
+	}
+	jobs.makeDue(0)
+	worker.processDue(context.Background())
+	if data := waitTicket(t, received); !bytes.Equal(data, Render(testTicket)) {
+		t.Errorf("printer received %q, want the ticket", data)
+	}
+	job = jobs.job(0)
+	if job.Status != structs.PrintPrinted || job.Attempts != 2 {
+		t.Errorf("job = %+v, want it printed on the second attempt", job)
+	}
+	if tickets := printer.Tickets(); len(tickets) != 1 {
+		t.Errorf("printer received %v tickets, want 1", len(tickets))
+	}
+}
+
+func TestWorkerGivesUp(t *testing.T) {
+	printer, _ := newTestPrinter(t)
+	if err := printer.SetFailing(true); err != nil {
+		t.Fatal(err)
+	}
+	jobs := &memJobs{jobs: []structs.PrintJob{
+		{ID: primitive.NewObjectID(), Address: printer.Addr(), PrinterName: "Bar", Ticket: testTicket, Status: structs.PrintPending},
+	}}
+	worker := NewWorker(jobs)
+
+	for i := 0; i < MaxAttempts; i++ {
+		jobs.makeDue(0)
+		worker.processDue(context.Background())
+	}
+	job := jobs.job(0)
+	if job.Status != structs.PrintFailed || job.Attempts != MaxAttempts {
+		t.Errorf("job = %+v, want it failed after %v attempts", job, MaxAttempts)
+	}
+
+	// Failed jobs are left for staff to reprint
+	jobs.makeDue(0)
+	worker.processDue(context.Background())
+	if job := jobs.job(0); job.Attempts != MaxAttempts {
+		t.Errorf("failed job was sent again, attempts = %v", job.Attempts)
+	}
+}
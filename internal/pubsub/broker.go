@@ -0,0 +1,61 @@
+package pubsub
+
+import "sync"
+
+// Bus delivers messages published on a topic to the subscribers of the topic. Broker keeps them
+// in memory, which is enough while the service runs as a single instance. A Bus backed by
+// MongoDB change streams or a message queue can replace it once there are several.
+type Bus interface {
+	// Subscribe returns a channel receiving the messages of a topic and a function that ends
+	// the subscription and closes the channel.
+	Subscribe(topic string) (<-chan string, func())
+	Publish(topic, message string)
+}
+
+// Broker fans out messages published on a topic to every current subscriber of the topic. It
+// lives in memory, subscribers only receive messages published while they are subscribed.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan string]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[string]map[chan string]struct{}{}}
+}
+
+// Subscribe returns a channel receiving the messages of a topic and a function that ends the
+// subscription. Slow subscribers miss messages instead of blocking publishers.
+func (b *Broker) Subscribe(topic string) (<-chan string, func()) {
+	ch := make(chan string, 8)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan string]struct{}{}
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *Broker) Publish(topic, message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
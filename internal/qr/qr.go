@@ -0,0 +1,261 @@
+// Package qr renders the QR codes guests scan at the tables.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"sync"
+
+	_ "image/jpeg"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/draw"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	// DefaultSize is the side in pixels of PNG codes when the venue doesn't choose one
+	DefaultSize = 512
+	MinSize     = 128
+	MaxSize     = 2048
+
+	// logoFraction is the part of the side of the code the logo covers, small enough for the
+	// high recovery level to restore the modules under it
+	logoFraction = 4
+)
+
+// Recoveries are the error correction levels venues can choose from, from the fewest modules to
+// the most damage a code survives.
+var Recoveries = []string{"low", "medium", "high", "highest"}
+
+var recoveryLevels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+// Options are how a QR code is drawn. The zero value is a black on white code with medium
+// error correction.
+type Options struct {
+	// Size is the side of PNG codes in pixels, SVG codes scale to whatever they are drawn at
+	Size       int
+	Recovery   qrcode.RecoveryLevel
+	Foreground color.Color
+	Background color.Color
+	// Logo is drawn in the middle of the code, which raises the recovery level to high
+	Logo image.Image
+}
+
+// VenueOptions returns the options for the QR codes of a venue. The logo is the encoded venue
+// image, only used when the venue puts it on its codes.
+func VenueOptions(settings structs.QRSettings, logo []byte) (Options, error) {
+	opts := Options{Size: settings.Size, Recovery: qrcode.Medium}
+	if settings.Recovery != "" {
+		level, ok := ParseRecovery(settings.Recovery)
+		if !ok {
+			return Options{}, fmt.Errorf("unknown recovery level %v", settings.Recovery)
+		}
+		opts.Recovery = level
+	}
+	if settings.Foreground != "" {
+		c, err := ParseColor(settings.Foreground)
+		if err != nil {
+			return Options{}, err
+		}
+		opts.Foreground = c
+	}
+	if settings.Background != "" {
+		c, err := ParseColor(settings.Background)
+		if err != nil {
+			return Options{}, err
+		}
+		opts.Background = c
+	}
+	if settings.Logo && len(logo) > 0 {
+		img, _, err := image.Decode(bytes.NewReader(logo))
+		if err != nil {
+			return Options{}, fmt.Errorf("failed to decode logo: %w", err)
+		}
+		opts.Logo = img
+	}
+	return opts, nil
+}
+
+// ParseRecovery returns the error correction level with the given name.
+func ParseRecovery(name string) (qrcode.RecoveryLevel, bool) {
+	level, ok := recoveryLevels[name]
+	return level, ok
+}
+
+// ParseColor reads a "#rrggbb" color.
+func ParseColor(hex string) (color.RGBA, error) {
+	var c color.RGBA
+	if len(hex) != 7 || hex[0] != '#' {
+		return c, fmt.Errorf("invalid color %v, expected #rrggbb", hex)
+	}
+	if _, err := fmt.Sscanf(strings.ToLower(hex), "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid color %v, expected #rrggbb", hex)
+	}
+	c.A = 0xff
+	return c, nil
+}
+
+// Luminance returns the relative luminance of a color, from 0 for black to 1 for white.
+func Luminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+}
+
+// PNG renders a QR code for the content as a square PNG.
+func PNG(content string, opts Options) ([]byte, error) {
+	code, err := opts.encode(content)
+	if err != nil {
+		return nil, err
+	}
+	size := opts.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+
+	img := code.Image(size)
+	if opts.Logo != nil {
+		canvas := image.NewRGBA(img.Bounds())
+		draw.Draw(canvas, canvas.Bounds(), img, image.Point{}, draw.Src)
+		side := canvas.Bounds().Dx()
+		box := side / logoFraction
+		origin := (side - box) / 2
+		draw.Draw(canvas, image.Rect(origin, origin, origin+box, origin+box), image.NewUniform(code.BackgroundColor), image.Point{}, draw.Src)
+		pad := box / 10
+		logo := fit(opts.Logo, box-2*pad)
+		at := image.Pt(origin+(box-logo.Bounds().Dx())/2, origin+(box-logo.Bounds().Dy())/2)
+		draw.Draw(canvas, logo.Bounds().Add(at), logo, image.Point{}, draw.Over)
+		img = canvas
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders a QR code for the content as a scalable SVG, one unit per module including the
+// quiet zone around it.
+func SVG(content string, opts Options) ([]byte, error) {
+	code, err := opts.encode(content)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := code.Bitmap()
+	size := len(bitmap)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/><path fill="%s" d="`, hexColor(code.BackgroundColor), hexColor(code.ForegroundColor))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if opts.Logo != nil {
+		box := float64(size) / logoFraction
+		origin := (float64(size) - box) / 2
+		pad := box / 10
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, fit(opts.Logo, 256)); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`, origin, origin, box, box, hexColor(code.BackgroundColor))
+		fmt.Fprintf(&buf, `<image x="%.2f" y="%.2f" width="%.2f" height="%.2f" preserveAspectRatio="xMidYMid meet" href="data:image/png;base64,%s"/>`,
+			origin+pad, origin+pad, box-2*pad, box-2*pad, base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+
+	buf.WriteString(`</svg>`)
+	return buf.Bytes(), nil
+}
+
+func (o Options) encode(content string) (*qrcode.QRCode, error) {
+	level := o.Recovery
+	if o.Logo != nil && level < qrcode.High {
+		level = qrcode.High
+	}
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	if o.Foreground != nil {
+		code.ForegroundColor = o.Foreground
+	}
+	if o.Background != nil {
+		code.BackgroundColor = o.Background
+	}
+	return code, nil
+}
+
+// fit scales an image to fit a square of side pixels keeping its aspect ratio.
+func fit(src image.Image, side int) image.Image {
+	b := src.Bounds()
+	w, h := side, side
+	if b.Dx() >= b.Dy() {
+		h = b.Dy() * side / b.Dx()
+	} else {
+		w = b.Dx() * side / b.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+func hexColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+// Cache keeps rendered QR codes in memory. Once it holds max codes the oldest are dropped.
+type Cache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string][]byte
+	order   []string
+}
+
+func NewCache(max int) *Cache {
+	return &Cache{max: max, entries: map[string][]byte{}}
+}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[key]
+	return data, ok
+}
+
+func (c *Cache) Add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	for len(c.order) >= c.max {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = data
+	c.order = append(c.order, key)
+}
@@ -0,0 +1,117 @@
+package receipts
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-pdf/fpdf"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	pageWidth = 80.0 // thermal receipt paper width in mm
+	margin    = 5.0
+	// maxPageHeight is the tallest page PDF viewers handle, longer receipts go on to more pages
+	maxPageHeight = 5000.0
+)
+
+// WritePDF renders the receipt on narrow PDF pages. The receipt is laid out on a page as long as
+// it can be first to measure how tall it comes out, receipts that fit on it get a single page
+// sized to their content and longer ones continue on more pages of maxPageHeight.
+func WritePDF(w io.Writer, receipt *structs.Receipt) error {
+	measure := newPage(maxPageHeight)
+	draw(measure, receipt)
+	if err := measure.Error(); err != nil {
+		return err
+	}
+	height := maxPageHeight
+	if measure.PageNo() == 1 {
+		height = measure.GetY() + margin
+	}
+
+	pdf := newPage(height)
+	draw(pdf, receipt)
+	return pdf.Output(w)
+}
+
+func newPage(height float64) *fpdf.Fpdf {
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		UnitStr: "mm",
+		Size:    fpdf.SizeType{Wd: pageWidth, Ht: height},
+	})
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(true, margin)
+	pdf.AddPage()
+	return pdf
+}
+
+func draw(pdf *fpdf.Fpdf, receipt *structs.Receipt) {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	contentWidth := pageWidth - 2*margin
+
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.MultiCell(contentWidth, 6, tr(receipt.VenueName), "", "C", false)
+	pdf.SetFont("Helvetica", "", 8)
+	for _, line := range []string{receipt.VenueAddress, receipt.VenuePhone, taxIDLine(receipt.VenueTaxID)} {
+		if line != "" {
+			pdf.MultiCell(contentWidth, 4, tr(line), "", "C", false)
+		}
+	}
+	pdf.Ln(2)
+
+	pdf.CellFormat(contentWidth/2, 4, tr("Receipt #"+receipt.Number), "", 0, "L", false, 0, "")
+	pdf.CellFormat(contentWidth/2, 4, receipt.ClosedAt.Format("2006-01-02 15:04"), "", 1, "R", false, 0, "")
+	pdf.CellFormat(contentWidth, 4, tr("Table "+receipt.TableCode), "", 1, "L", false, 0, "")
+	separator(pdf, contentWidth)
+
+	for _, line := range receipt.Lines {
+		pdf.MultiCell(contentWidth, 4, tr(line.Name), "", "L", false)
+		pdf.CellFormat(contentWidth/2, 4, fmt.Sprintf("  %d x $%.2f", line.Amount, line.UnitPrice), "", 0, "L", false, 0, "")
+		pdf.CellFormat(contentWidth/2, 4, fmt.Sprintf("$%.2f", line.Total), "", 1, "R", false, 0, "")
+	}
+	separator(pdf, contentWidth)
+
+	amountRow(pdf, contentWidth, "Subtotal", receipt.Subtotal)
+	if receipt.Discount > 0 {
+		amountRow(pdf, contentWidth, tr(receipt.DiscountName), -receipt.Discount)
+	}
+	if receipt.Tax > 0 {
+		amountRow(pdf, contentWidth, fmt.Sprintf("Tax (%.2f%%)", receipt.TaxRate), receipt.Tax)
+	}
+	if receipt.Tip > 0 {
+		amountRow(pdf, contentWidth, "Tip", receipt.Tip)
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	amountRow(pdf, contentWidth, "Total", receipt.Total)
+	pdf.SetFont("Helvetica", "", 8)
+
+	if receipt.PaymentMethod != "" {
+		pdf.Ln(2)
+		pdf.CellFormat(contentWidth, 4, tr("Paid with "+receipt.PaymentMethod), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+	pdf.CellFormat(contentWidth, 4, "Thank you!", "", 1, "C", false, 0, "")
+}
+
+func taxIDLine(taxID string) string {
+	if taxID == "" {
+		return ""
+	}
+	return "Tax ID: " + taxID
+}
+
+func separator(pdf *fpdf.Fpdf, width float64) {
+	pdf.Ln(1)
+	x, y := pdf.GetXY()
+	pdf.Line(x, y, x+width, y)
+	pdf.Ln(2)
+}
+
+func amountRow(pdf *fpdf.Fpdf, width float64, label string, amount float64) {
+	formatted := fmt.Sprintf("$%.2f", amount)
+	if amount < 0 {
+		formatted = fmt.Sprintf("-$%.2f", -amount)
+	}
+	pdf.CellFormat(width/2, 5, label, "", 0, "L", false, 0, "")
+	pdf.CellFormat(width/2, 5, formatted, "", 1, "R", false, 0, "")
+}
@@ -0,0 +1,91 @@
+package receipts
+
+import (
+	"math"
+
+	"vortex.studio/account/internal/structs"
+)
+
+// Totals are the amounts of a bill.
+type Totals struct {
+	Subtotal float64
+	Discount float64
+	Tax      float64
+	Tip      float64
+	Total    float64
+}
+
+// Calculate adds up the ordered items, takes the discount off and applies the tax rate
+// (a percentage, prices are tax exclusive) and tip. Amounts are rounded to cents.
+func Calculate(items []structs.OrderItem, taxRate, discount, tip float64) Totals {
+	var subtotal float64
+	for _, item := range items {
+		if item.MenuItem == nil {
+			continue
+		}
+		subtotal += item.Price * float64(item.Amount)
+	}
+	subtotal = round(subtotal)
+	discount = round(math.Min(math.Max(discount, 0), subtotal))
+	tax := round((subtotal - discount) * taxRate / 100)
+	tip = round(tip)
+
+	return Totals{
+		Subtotal: subtotal,
+		Discount: discount,
+		Tax:      tax,
+		Tip:      tip,
+		Total:    round(subtotal - discount + tax + tip),
+	}
+}
+
+// New builds the receipt of a recorded event. The venue may be nil if it was deleted since.
+func New(event *structs.Event, venue *structs.Venue) *structs.Receipt {
+	receipt := &structs.Receipt{
+		Number:        event.ReceiptNumber,
+		Token:         event.ReceiptToken,
+		Status:        event.Status,
+		ClosedAt:      event.ClosedAt,
+		TableCode:     event.Order.TableCode,
+		PaymentMethod: event.PaymentMethod,
+		Subtotal:      event.Subtotal,
+		Discount:      event.Discount,
+		TaxRate:       event.TaxRate,
+		Tax:           event.Tax,
+		Tip:           event.Tip,
+		Total:         event.Total,
+	}
+
+	if event.Order.Redemption != nil {
+		receipt.DiscountName = event.Order.Redemption.Name
+	}
+
+	if venue != nil {
+		receipt.VenueName = venue.Name
+		receipt.VenueAddress = venue.Address
+		receipt.VenuePhone = venue.Phone
+		receipt.VenueTaxID = venue.TaxID
+		// Events recorded before the rate was kept with them were taxed at the rate the venue had
+		if event.TaxRate == 0 && event.Tax != 0 {
+			receipt.TaxRate = venue.TaxRate
+		}
+	}
+
+	for _, item := range event.Order.OrderHistory {
+		if item.MenuItem == nil {
+			continue
+		}
+		receipt.Lines = append(receipt.Lines, structs.ReceiptLine{
+			Name:      item.Name,
+			Amount:    item.Amount,
+			UnitPrice: item.Price,
+			Total:     round(item.Price * float64(item.Amount)),
+		})
+	}
+
+	return receipt
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
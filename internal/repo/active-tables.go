@@ -2,8 +2,12 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 	"vortex.studio/account/internal/structs"
 )
 
@@ -17,8 +21,26 @@ func NewActiveTablesRepository(db *mongo.Database) *ActiveTablesRepository {
 	}
 }
 
+// ErrTableOccupied is returned when a session would be put on a table that already has one.
+var ErrTableOccupied = errors.New("table already has a session")
+
+// EnsureIndexes creates the unique index on the table codes of sessions, which keeps two sessions
+// from being opened or moved onto the same table at once.
+func (sr *ActiveTablesRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := sr.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "table_code", Value: 1}},
+		Options: options.Index().SetName("table_code").SetUnique(true),
+	})
+	return err
+}
+
+// TableActive opens a session, it fails with ErrTableOccupied when the table already has one.
 func (sr *ActiveTablesRepository) TableActive(session *structs.ActiveTable) (*mongo.InsertOneResult, error) {
-	return sr.Collection.InsertOne(context.Background(), session)
+	result, err := sr.Collection.InsertOne(context.Background(), session)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTableOccupied
+	}
+	return result, err
 }
 
 func (sr *ActiveTablesRepository) GetSessionForTable(code string) (*structs.ActiveTable, error) {
@@ -30,12 +52,185 @@ func (sr *ActiveTablesRepository) GetSessionForTable(code string) (*structs.Acti
 	return &session, nil
 }
 
-func (sr *ActiveTablesRepository) UpdateSession(session *structs.ActiveTable) (*mongo.UpdateResult, error) {
-	return sr.Collection.UpdateOne(context.Background(), bson.M{"table_code": session.TableCode}, bson.M{"$set": session})
+func (sr *ActiveTablesRepository) GetSessionByID(ctx context.Context, id primitive.ObjectID) (*structs.ActiveTable, error) {
+	var session structs.ActiveTable
+	err := sr.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetPickupSessionForClient returns the open pickup order of a guest at a venue.
+func (sr *ActiveTablesRepository) GetPickupSessionForClient(ctx context.Context, venueID primitive.ObjectID, clientID string) (*structs.ActiveTable, error) {
+	filter := bson.M{"mode": structs.ModePickup, "venue_id": venueID, "client_id": clientID}
+	var session structs.ActiveTable
+	err := sr.Collection.FindOne(ctx, filter).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// AddToCart appends an item to the pre-order of a session. Nothing is matched once the session
+// was closed or merged into another one.
+func (sr *ActiveTablesRepository) AddToCart(ctx context.Context, id primitive.ObjectID, item structs.OrderItem) (*mongo.UpdateResult, error) {
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"pre_order": appendItems("$pre_order", []structs.OrderItem{item}),
+	}}}}
+	return sr.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
 }
 
-func (sr *ActiveTablesRepository) GetOpenSessions(ctx context.Context) ([]*structs.ActiveTable, error) {
-	cursor, err := sr.Collection.Find(ctx, bson.M{})
+// PlaceOrder moves the pre-order of a session to its order history and returns the session as it
+// was before, so its pre-order is exactly what was placed. Pickup orders aren't ready before
+// earliest, which is zero for tables. It fails with mongo.ErrNoDocuments once the session was
+// closed or merged into another one.
+func (sr *ActiveTablesRepository) PlaceOrder(ctx context.Context, id primitive.ObjectID, at, earliest time.Time) (*structs.ActiveTable, error) {
+	preOrder := bson.M{"$ifNull": bson.A{"$pre_order", bson.A{}}}
+	set := bson.M{
+		"order_history": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$order_history", bson.A{}}}, preOrder}},
+		"pre_order":     bson.M{"$literal": bson.A{}},
+		"ordered_at":    bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{bson.M{"$size": preOrder}, 0}}, at, "$ordered_at"}},
+	}
+	if !earliest.IsZero() {
+		set["ready_at"] = bson.M{"$max": bson.A{"$ready_at", earliest}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	var session structs.ActiveTable
+	err := sr.Collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, mongo.Pipeline{{{Key: "$set", Value: set}}}, opts).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// StartPayment records the checkout started for a session, as long as no order was placed since
+// its bill was computed from orders placed and no charge is waiting for review. Nothing is
+// matched otherwise.
+func (sr *ActiveTablesRepository) StartPayment(ctx context.Context, id primitive.ObjectID, orders int, payment *structs.Payment) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "order_history": bson.M{"$size": orders}, "payment.charged": bson.M{"$exists": false}}
+	return sr.Collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"payment": payment}})
+}
+
+// SetRedemption applies a reward to the bill of a session.
+func (sr *ActiveTablesRepository) SetRedemption(ctx context.Context, id primitive.ObjectID, redemption *structs.Redemption) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"redemption": redemption}})
+}
+
+// MoveSession points a session to another table code as long as it is still on the table it was
+// read from and has no online payment in progress, nothing is matched otherwise. It fails with
+// ErrTableOccupied when the other table has a session.
+func (sr *ActiveTablesRepository) MoveSession(ctx context.Context, session *structs.ActiveTable, code string) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": session.ID, "table_code": session.TableCode, "payment": bson.M{"$exists": false}}
+	result, err := sr.Collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"table_code": code}})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTableOccupied
+	}
+	return result, err
+}
+
+// TakeSession removes a session to merge it into another one, as long as it is still on the
+// table it was read from and has no online payment in progress, and returns it as it was when
+// removed.
+func (sr *ActiveTablesRepository) TakeSession(ctx context.Context, session *structs.ActiveTable) (*structs.ActiveTable, error) {
+	filter := bson.M{"_id": session.ID, "table_code": session.TableCode, "payment": bson.M{"$exists": false}}
+	var taken structs.ActiveTable
+	err := sr.Collection.FindOneAndDelete(ctx, filter).Decode(&taken)
+	if err != nil {
+		return nil, err
+	}
+	return &taken, nil
+}
+
+// MergeSession appends the orders of a session taken off its table and the guests given to the
+// session with the given id, as long as it has no online payment in progress, and returns the
+// combined session.
+func (sr *ActiveTablesRepository) MergeSession(ctx context.Context, id primitive.ObjectID, source *structs.ActiveTable, clients []string) (*structs.ActiveTable, error) {
+	if clients == nil {
+		clients = []string{}
+	}
+	filter := bson.M{"_id": id, "payment": bson.M{"$exists": false}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"order_history":      appendItems("$order_history", source.OrderHistory),
+		"pre_order":          appendItems("$pre_order", source.PreOrder),
+		"additional_clients": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$additional_clients", bson.A{}}}, bson.M{"$literal": clients}}},
+	}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var session structs.ActiveTable
+	err := sr.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// appendItems returns the pipeline expression appending order items to an array field, which is
+// null on sessions that haven't ordered yet.
+func appendItems(field string, items []structs.OrderItem) bson.M {
+	if items == nil {
+		items = []structs.OrderItem{}
+	}
+	return bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{field, bson.A{}}}, bson.M{"$literal": items}}}
+}
+
+// RequestBill records that the guests of a table asked for the bill.
+func (sr *ActiveTablesRepository) RequestBill(ctx context.Context, code string, at time.Time) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"table_code": code}, bson.M{"$set": bson.M{"bill_requested_at": at}})
+}
+
+// ClearBillRequest withdraws the bill request of a table, when its guests order again.
+func (sr *ActiveTablesRepository) ClearBillRequest(ctx context.Context, code string) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"table_code": code}, bson.M{"$unset": bson.M{"bill_requested_at": ""}})
+}
+
+// SetServed records that staff brought the food ordered by a table.
+func (sr *ActiveTablesRepository) SetServed(ctx context.Context, code string, at time.Time) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"table_code": code}, bson.M{"$set": bson.M{"served_at": at}})
+}
+
+// ClearOrderHistory empties the orders placed at a session.
+func (sr *ActiveTablesRepository) ClearOrderHistory(ctx context.Context, id primitive.ObjectID) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"order_history": []structs.OrderItem{}}})
+}
+
+// ClearRedemption removes the reward the guest applied to the bill of a session.
+func (sr *ActiveTablesRepository) ClearRedemption(ctx context.Context, id primitive.ObjectID) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"redemption": ""}})
+}
+
+// ClearPayment drops the checkout a session started once its bill changes, unless the provider
+// already charged it.
+func (sr *ActiveTablesRepository) ClearPayment(ctx context.Context, id primitive.ObjectID) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "payment.charged": bson.M{"$exists": false}}
+	return sr.Collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"payment": ""}})
+}
+
+// FlagPayment records a completed checkout that didn't cover the bill of a session.
+func (sr *ActiveTablesRepository) FlagPayment(ctx context.Context, id primitive.ObjectID, payment *structs.Payment) (*mongo.UpdateResult, error) {
+	return sr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"payment": payment}})
+}
+
+// GetOpenSessionsForVenues returns the open sessions on the tables of the venues and their pickup
+// orders.
+func (sr *ActiveTablesRepository) GetOpenSessionsForVenues(ctx context.Context, venues []structs.Venue) ([]*structs.ActiveTable, error) {
+	venueIDs := bson.A{}
+	codes := bson.A{}
+	labels := map[string]string{}
+	locations := map[primitive.ObjectID]*time.Location{}
+	for _, venue := range venues {
+		venueIDs = append(venueIDs, venue.ID)
+		locations[venue.ID] = venue.Location()
+		for _, code := range venue.TableCodes {
+			codes = append(codes, code.Code)
+			labels[code.Code] = code.Name()
+		}
+	}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"venue_id": bson.M{"$in": venueIDs}},
+		bson.M{"table_code": bson.M{"$in": codes}},
+	}}
+
+	cursor, err := sr.Collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -44,10 +239,30 @@ func (sr *ActiveTablesRepository) GetOpenSessions(ctx context.Context) ([]*struc
 	if err := cursor.All(ctx, &sessions); err != nil {
 		return nil, err
 	}
+	for _, session := range sessions {
+		session.TableLabel = labels[session.TableCode]
+		// Pickup times show in the time zone of the venue rather than the UTC they're stored in
+		if loc, ok := locations[session.VenueID]; ok && session.IsPickup() {
+			session.ReadyAt = session.ReadyAt.In(loc)
+		}
+	}
 
 	return sessions, nil
 }
 
-func (sr *ActiveTablesRepository) DeleteSession(code string) (*mongo.DeleteResult, error) {
-	return sr.Collection.DeleteOne(context.Background(), bson.M{"table_code": code})
+// CloseSession removes a session to close it, as long as no order was placed since it was read
+// with the given number of orders placed, and returns it as it was when removed. Nothing is found
+// when another request closed it first.
+func (sr *ActiveTablesRepository) CloseSession(ctx context.Context, id primitive.ObjectID, orders int) (*structs.ActiveTable, error) {
+	history := bson.M{"$size": orders}
+	if orders == 0 {
+		// Sessions nothing was ordered at may have no order history at all
+		history = bson.M{"$in": bson.A{nil, bson.A{}}}
+	}
+	var closed structs.ActiveTable
+	err := sr.Collection.FindOneAndDelete(ctx, bson.M{"_id": id, "order_history": history}).Decode(&closed)
+	if err != nil {
+		return nil, err
+	}
+	return &closed, nil
 }
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"vortex.studio/account/internal/structs"
+)
+
+// AuditRepository keeps the audit log. Entries are only ever added, there is no way to change or
+// remove them.
+type AuditRepository struct {
+	*Repository
+}
+
+func NewAuditRepository(db *mongo.Database) *AuditRepository {
+	return &AuditRepository{
+		Repository: &Repository{
+			Collection: db.Collection("audit_log"),
+		},
+	}
+}
+
+func (ar *AuditRepository) RecordEntry(ctx context.Context, entry *structs.AuditEntry) error {
+	_, err := ar.Collection.InsertOne(ctx, entry)
+	return err
+}
+
+// FindEntries returns the latest entries of a tenant matching the filter, newest first.
+func (ar *AuditRepository) FindEntries(ctx context.Context, tenantID string, filter structs.AuditFilter, limit int64) ([]structs.AuditEntry, error) {
+	query := bson.M{"tenant_id": tenantID}
+	if filter.Actor != "" {
+		query["$or"] = bson.A{
+			bson.M{"actor": containing(filter.Actor)},
+			bson.M{"actor_id": filter.Actor},
+		}
+	}
+	if filter.Action != "" {
+		query["action"] = containing(filter.Action)
+	}
+	if filter.Target != "" {
+		query["target"] = containing(filter.Target)
+	}
+	at := bson.M{}
+	if !filter.From.IsZero() {
+		at["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		at["$lt"] = filter.To
+	}
+	if len(at) > 0 {
+		query["at"] = at
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := ar.Collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []structs.AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// containing matches strings containing the text, ignoring case.
+func containing(text string) bson.M {
+	return bson.M{"$regex": regexp.QuoteMeta(text), "$options": "i"}
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"vortex.studio/account/internal/structs"
+)
+
+type FeedbackRepository struct {
+	*Repository
+}
+
+func NewFeedbackRepository(db *mongo.Database) *FeedbackRepository {
+	return &FeedbackRepository{
+		Repository: &Repository{
+			Collection: db.Collection("feedback"),
+		},
+	}
+}
+
+func (fr *FeedbackRepository) CreateFeedback(ctx context.Context, feedback *structs.Feedback) (*mongo.InsertOneResult, error) {
+	return fr.Collection.InsertOne(ctx, feedback)
+}
+
+func (fr *FeedbackRepository) GetFeedbackByEventID(ctx context.Context, eventID primitive.ObjectID) (*structs.Feedback, error) {
+	var feedback structs.Feedback
+	err := fr.Collection.FindOne(ctx, bson.M{"event_id": eventID}).Decode(&feedback)
+	if err != nil {
+		return nil, err
+	}
+	return &feedback, nil
+}
+
+// GetVenueRatings returns the average visit rating of every venue, keyed by venue id.
+func (fr *FeedbackRepository) GetVenueRatings(ctx context.Context) (map[primitive.ObjectID]structs.RatingSummary, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$venue_id",
+			"average": bson.M{"$avg": "$rating"},
+			"count":   bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := fr.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		VenueID primitive.ObjectID `bson:"_id"`
+		Average float64            `bson:"average"`
+		Count   int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	ratings := map[primitive.ObjectID]structs.RatingSummary{}
+	for _, result := range results {
+		ratings[result.VenueID] = structs.RatingSummary{Average: result.Average, Count: result.Count}
+	}
+	return ratings, nil
+}
+
+// GetDishRatings returns the average rating of every rated dish of a venue, best rated first.
+func (fr *FeedbackRepository) GetDishRatings(ctx context.Context, venueID primitive.ObjectID) ([]structs.RatingSummary, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"venue_id": venueID}}},
+		{{Key: "$unwind", Value: "$dishes"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$dishes.name",
+			"average": bson.M{"$avg": "$dishes.rating"},
+			"count":   bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{"name": "$_id", "average": 1, "count": 1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "average", Value: -1}, {Key: "count", Value: -1}}}},
+	}
+	cursor, err := fr.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var ratings []structs.RatingSummary
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+// GetLowScores returns the most recent feedback rated at or below the threshold.
+func (fr *FeedbackRepository) GetLowScores(ctx context.Context, threshold int, limit int64) ([]structs.Feedback, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	cursor, err := fr.Collection.Find(ctx, bson.M{"rating": bson.M{"$lte": threshold}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var feedback []structs.Feedback
+	if err := cursor.All(ctx, &feedback); err != nil {
+		return nil, err
+	}
+	return feedback, nil
+}
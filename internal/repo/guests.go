@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+	"vortex.studio/account/internal/structs"
+)
+
+type GuestProfilesRepository struct {
+	*Repository
+	// ledger is the loyalty ledger, its entries follow the profile they belong to when it is
+	// merged into an account
+	ledger *mongo.Collection
+}
+
+func NewGuestProfilesRepository(db *mongo.Database) *GuestProfilesRepository {
+	return &GuestProfilesRepository{
+		Repository: &Repository{
+			Collection: db.Collection("guest_profiles"),
+		},
+		ledger: db.Collection("loyalty_ledger"),
+	}
+}
+
+func (gr *GuestProfilesRepository) GetProfileByClientID(ctx context.Context, tenantID, clientID string) (*structs.GuestProfile, error) {
+	var profile structs.GuestProfile
+	err := gr.Collection.FindOne(ctx, bson.M{"tenant_id": tenantID, "client_ids": clientID}).Decode(&profile)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (gr *GuestProfilesRepository) GetProfileByEmail(ctx context.Context, tenantID, email string) (*structs.GuestProfile, error) {
+	var profile structs.GuestProfile
+	err := gr.Collection.FindOne(ctx, bson.M{"tenant_id": tenantID, "email": email}).Decode(&profile)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (gr *GuestProfilesRepository) GetProfileByLinkToken(ctx context.Context, token string) (*structs.GuestProfile, error) {
+	filter := bson.M{"link_token": token, "link_token_expiry": bson.M{"$gt": time.Now()}}
+	var profile structs.GuestProfile
+	err := gr.Collection.FindOne(ctx, filter).Decode(&profile)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetOrCreateProfile returns the profile of a client in a tenant, creating an anonymous one the
+// first time the client is seen.
+func (gr *GuestProfilesRepository) GetOrCreateProfile(ctx context.Context, tenantID, clientID string) (*structs.GuestProfile, error) {
+	profile, err := gr.GetProfileByClientID(ctx, tenantID, clientID)
+	if err == nil {
+		return profile, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	profile = &structs.GuestProfile{
+		TenantID:  tenantID,
+		ClientIDs: []string{clientID},
+		Visits:    []structs.Visit{},
+		CreatedAt: time.Now(),
+	}
+	result, err := gr.Collection.InsertOne(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+	profile.ID = result.InsertedID.(primitive.ObjectID)
+	return profile, nil
+}
+
+func (gr *GuestProfilesRepository) AddVisit(ctx context.Context, id primitive.ObjectID, visit structs.Visit) (*mongo.UpdateResult, error) {
+	return gr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$push": bson.M{"visits": visit}})
+}
+
+func (gr *GuestProfilesRepository) UpdateAccount(ctx context.Context, id primitive.ObjectID, name, email string) (*mongo.UpdateResult, error) {
+	return gr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": name, "email": email}})
+}
+
+func (gr *GuestProfilesRepository) SetLinkToken(ctx context.Context, id primitive.ObjectID, token string, expiry time.Time) (*mongo.UpdateResult, error) {
+	update := bson.M{"$set": bson.M{"link_token": token, "link_token_expiry": expiry}}
+	return gr.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+}
+
+// LinkClient attaches a device to an account profile, consuming the link token. Visits and
+// loyalty points of the anonymous profile the device had so far are moved over to the account, a
+// device linked to a different account is just detached from it.
+func (gr *GuestProfilesRepository) LinkClient(ctx context.Context, profile *structs.GuestProfile, clientID string) error {
+	var visits []structs.Visit
+	previous, err := gr.GetProfileByClientID(ctx, profile.TenantID, clientID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if previous != nil {
+		if previous.ID == profile.ID {
+			return nil
+		}
+		if !previous.IsAccount() {
+			visits = previous.Visits
+		}
+	}
+
+	update := bson.M{
+		"$addToSet": bson.M{"client_ids": clientID},
+		"$unset":    bson.M{"link_token": "", "link_token_expiry": ""},
+	}
+	if len(visits) > 0 {
+		update["$push"] = bson.M{"visits": bson.M{"$each": visits}}
+	}
+	if _, err := gr.Collection.UpdateOne(ctx, bson.M{"_id": profile.ID}, update); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		if previous.IsAccount() {
+			_, err = gr.Collection.UpdateOne(ctx, bson.M{"_id": previous.ID}, bson.M{"$pull": bson.M{"client_ids": clientID}})
+		} else {
+			// The points of the anonymous profile move to the account along with its visits
+			_, err = gr.ledger.UpdateMany(ctx, bson.M{"profile_id": previous.ID}, bson.M{"$set": bson.M{"profile_id": profile.ID}})
+			if err != nil {
+				return err
+			}
+			_, err = gr.Collection.DeleteOne(ctx, bson.M{"_id": previous.ID})
+		}
+	}
+	return err
+}
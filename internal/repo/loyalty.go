@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"vortex.studio/account/internal/structs"
+)
+
+type LoyaltyRepository struct {
+	*Repository
+	ledger *mongo.Collection
+}
+
+func NewLoyaltyRepository(db *mongo.Database) *LoyaltyRepository {
+	return &LoyaltyRepository{
+		Repository: &Repository{
+			Collection: db.Collection("loyalty_programs"),
+		},
+		ledger: db.Collection("loyalty_ledger"),
+	}
+}
+
+func (lr *LoyaltyRepository) GetProgram(ctx context.Context, tenantID string) (*structs.LoyaltyProgram, error) {
+	var program structs.LoyaltyProgram
+	err := lr.Collection.FindOne(ctx, bson.M{"tenant_id": tenantID}).Decode(&program)
+	if err != nil {
+		return nil, err
+	}
+	return &program, nil
+}
+
+func (lr *LoyaltyRepository) SaveProgramSettings(ctx context.Context, tenantID string, enabled bool, earnRate float64) (*mongo.UpdateResult, error) {
+	update := bson.M{
+		"$set":         bson.M{"enabled": enabled, "earn_rate": earnRate},
+		"$setOnInsert": bson.M{"rewards": []structs.Reward{}},
+	}
+	return lr.Collection.UpdateOne(ctx, bson.M{"tenant_id": tenantID}, update, options.Update().SetUpsert(true))
+}
+
+func (lr *LoyaltyRepository) AddReward(ctx context.Context, tenantID string, reward structs.Reward) (*mongo.UpdateResult, error) {
+	update := bson.M{"$push": bson.M{"rewards": reward}}
+	return lr.Collection.UpdateOne(ctx, bson.M{"tenant_id": tenantID}, update, options.Update().SetUpsert(true))
+}
+
+func (lr *LoyaltyRepository) RemoveReward(ctx context.Context, tenantID, rewardID string) (*mongo.UpdateResult, error) {
+	update := bson.M{"$pull": bson.M{"rewards": bson.M{"id": rewardID}}}
+	return lr.Collection.UpdateOne(ctx, bson.M{"tenant_id": tenantID}, update)
+}
+
+// AddLedgerEntry appends to the ledger, entries are never updated or removed.
+func (lr *LoyaltyRepository) AddLedgerEntry(ctx context.Context, entry *structs.LedgerEntry) (*mongo.InsertOneResult, error) {
+	return lr.ledger.InsertOne(ctx, entry)
+}
+
+// GetBalance adds up every ledger entry of a guest profile.
+func (lr *LoyaltyRepository) GetBalance(ctx context.Context, profileID primitive.ObjectID) (int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"profile_id": profileID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "balance": bson.M{"$sum": "$points"}}}},
+	}
+	cursor, err := lr.ledger.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Balance int `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Balance, nil
+}
+
+func (lr *LoyaltyRepository) GetLedgerEntries(ctx context.Context, profileID primitive.ObjectID) ([]structs.LedgerEntry, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := lr.ledger.Find(ctx, bson.M{"profile_id": profileID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []structs.LedgerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
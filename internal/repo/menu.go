@@ -2,13 +2,19 @@ package repo
 
 import (
 	"context"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	menuanalyzer "vortex.studio/account/internal/menu-analyzer"
 	"vortex.studio/account/internal/structs"
 )
 
+// menuProjection leaves out what the menu analysis kept of the menu file, menus are only read
+// for their items.
+var menuProjection = bson.M{"visionResult": 0, "rawCategoryResult": 0}
+
 type MenuRepository struct {
 	*Repository
 }
@@ -25,12 +31,73 @@ func (mr *MenuRepository) CreateMenu(menu *menuanalyzer.AnalysisData) (*mongo.In
 	return mr.Collection.InsertOne(context.Background(), menu)
 }
 
-func (mr *MenuRepository) GetMenuByVenueID(venueID primitive.ObjectID) (*structs.MenuData, error) {
-	var menu menuanalyzer.AnalysisData
-	err := mr.Collection.FindOne(context.Background(), bson.M{"venueId": venueID}).Decode(&menu)
+func (mr *MenuRepository) GetMenu(ctx context.Context, id primitive.ObjectID) (*structs.Menu, error) {
+	var menu structs.Menu
+	opts := options.FindOne().SetProjection(menuProjection)
+	err := mr.Collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&menu)
+	if err != nil {
+		return nil, err
+	}
+	return &menu, nil
+}
+
+// GetMenuForVenue returns the menu a venue uses, the one created for it when it hasn't picked one.
+func (mr *MenuRepository) GetMenuForVenue(ctx context.Context, venue *structs.Venue) (*structs.Menu, error) {
+	if !venue.MenuID.IsZero() {
+		return mr.GetMenu(ctx, venue.MenuID)
+	}
+	var menu structs.Menu
+	opts := options.FindOne().SetProjection(menuProjection)
+	err := mr.Collection.FindOne(ctx, bson.M{"venueId": venue.ID}, opts).Decode(&menu)
 	if err != nil {
 		return nil, err
 	}
-	return &menu.CategoryResult, nil
+	return &menu, nil
+}
+
+func (mr *MenuRepository) GetMenusForTenant(ctx context.Context, tenantID string) ([]structs.Menu, error) {
+	opts := options.Find().SetProjection(menuProjection).SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := mr.Collection.Find(ctx, bson.M{"tenant_id": tenantID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var menus []structs.Menu
+	if err := cursor.All(ctx, &menus); err != nil {
+		return nil, err
+	}
+	return menus, nil
+}
+
+// AdoptMenu makes a menu created before menus could be shared a menu of the tenant.
+func (mr *MenuRepository) AdoptMenu(ctx context.Context, id primitive.ObjectID, tenantID, name string) (*mongo.UpdateResult, error) {
+	return mr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"tenant_id": tenantID, "name": name}})
+}
+
+func (mr *MenuRepository) RenameMenu(ctx context.Context, id primitive.ObjectID, name string) (*mongo.UpdateResult, error) {
+	return mr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": name}})
+}
+
+func (mr *MenuRepository) SetItemImage(ctx context.Context, menuID primitive.ObjectID, categoryIndex, itemIndex int, image, thumbnail string) (*mongo.UpdateResult, error) {
+	itemPath := fmt.Sprintf("categoryResult.categories.%d.items.%d", categoryIndex, itemIndex)
+	update := bson.M{"$set": bson.M{
+		itemPath + ".image":     image,
+		itemPath + ".thumbnail": thumbnail,
+	}}
+	return mr.Collection.UpdateOne(ctx, bson.M{"_id": menuID}, update)
+}
+
+func (mr *MenuRepository) SetCategoryStation(ctx context.Context, menuID primitive.ObjectID, categoryIndex int, station string) (*mongo.UpdateResult, error) {
+	path := fmt.Sprintf("categoryResult.categories.%d.station", categoryIndex)
+	return mr.Collection.UpdateOne(ctx, bson.M{"_id": menuID}, bson.M{"$set": bson.M{path: station}})
+}
+
+// ReplaceMenu replaces the items of a menu, for every venue using it.
+func (mr *MenuRepository) ReplaceMenu(ctx context.Context, menuID primitive.ObjectID, menu structs.MenuData) (*mongo.UpdateResult, error) {
+	return mr.Collection.UpdateOne(ctx, bson.M{"_id": menuID}, bson.M{"$set": bson.M{"categoryResult": menu}})
+}
 
+func (mr *MenuRepository) DeleteMenu(ctx context.Context, id primitive.ObjectID) (*mongo.DeleteResult, error) {
+	return mr.Collection.DeleteOne(ctx, bson.M{"_id": id})
 }
@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"vortex.studio/account/internal/structs"
+)
+
+type PrintJobsRepository struct {
+	*Repository
+}
+
+func NewPrintJobsRepository(db *mongo.Database) *PrintJobsRepository {
+	return &PrintJobsRepository{
+		Repository: &Repository{
+			Collection: db.Collection("print_jobs"),
+		},
+	}
+}
+
+func (pr *PrintJobsRepository) AddJob(ctx context.Context, job *structs.PrintJob) (*mongo.InsertOneResult, error) {
+	return pr.Collection.InsertOne(ctx, job)
+}
+
+func (pr *PrintJobsRepository) GetJobByID(ctx context.Context, id primitive.ObjectID) (*structs.PrintJob, error) {
+	var job structs.PrintJob
+	err := pr.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetRecentJobs returns the latest jobs of a venue, newest first.
+func (pr *PrintJobsRepository) GetRecentJobs(ctx context.Context, venueID primitive.ObjectID, limit int64) ([]structs.PrintJob, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	return pr.find(ctx, bson.M{"venue_id": venueID}, opts)
+}
+
+// ClaimDueJob marks the oldest due job as sending until lease ends and returns it, nil when no
+// job is due. Jobs still sending once their lease ended are due again.
+func (pr *PrintJobsRepository) ClaimDueJob(ctx context.Context, now time.Time, lease time.Duration) (*structs.PrintJob, error) {
+	filter := bson.M{
+		"status":          bson.M{"$in": bson.A{structs.PrintPending, structs.PrintSending}},
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"status": structs.PrintSending, "next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"created_at": 1}).SetReturnDocument(options.After)
+
+	var job structs.PrintJob
+	err := pr.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (pr *PrintJobsRepository) MarkPrinted(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	update := bson.M{"$set": bson.M{"status": structs.PrintPrinted, "printed_at": at}, "$inc": bson.M{"attempts": 1}}
+	_, err := pr.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (pr *PrintJobsRepository) MarkAttemptFailed(ctx context.Context, id primitive.ObjectID, attempts int, lastError string, next time.Time, failed bool) error {
+	status := structs.PrintPending
+	if failed {
+		status = structs.PrintFailed
+	}
+	update := bson.M{"$set": bson.M{
+		"status":          status,
+		"attempts":        attempts,
+		"last_error":      lastError,
+		"next_attempt_at": next,
+	}}
+	_, err := pr.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (pr *PrintJobsRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]structs.PrintJob, error) {
+	cursor, err := pr.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []structs.PrintJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
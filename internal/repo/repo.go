@@ -2,7 +2,12 @@ package repo
 
 import (
 	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 	"vortex.studio/account/internal/structs"
 )
 
@@ -12,6 +17,7 @@ type Repository struct {
 
 type EventsRepo struct {
 	*Repository
+	counters *mongo.Collection
 }
 
 func NewEventsRepo(db *mongo.Database) *EventsRepo {
@@ -19,9 +25,95 @@ func NewEventsRepo(db *mongo.Database) *EventsRepo {
 		Repository: &Repository{
 			Collection: db.Collection("events"),
 		},
+		counters: db.Collection("counters"),
 	}
 }
 
 func (er *EventsRepo) RecordEvent(event *structs.Event) (*mongo.InsertOneResult, error) {
 	return er.Collection.InsertOne(context.Background(), event)
 }
+
+// NextReceiptNumber atomically increments the receipt counter of a venue and returns the new
+// receipt number.
+func (er *EventsRepo) NextReceiptNumber(ctx context.Context, venueID primitive.ObjectID) (string, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	id := fmt.Sprintf("receipts:%s", venueID.Hex())
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := er.counters.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"seq": 1}}, opts).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", counter.Seq), nil
+}
+
+// NextPickupNumber returns the next pickup order number of a venue, numbers start over every day.
+func (er *EventsRepo) NextPickupNumber(ctx context.Context, venueID primitive.ObjectID, day string) (string, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	id := fmt.Sprintf("pickup:%s:%s", venueID.Hex(), day)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := er.counters.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"seq": 1}}, opts).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%03d", counter.Seq), nil
+}
+
+func (er *EventsRepo) GetEventByReceiptToken(ctx context.Context, token string) (*structs.Event, error) {
+	var event structs.Event
+	err := er.Collection.FindOne(ctx, bson.M{"receipt_token": token}).Decode(&event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// GetLatestEventForClient returns the most recently closed session a guest had on a table.
+func (er *EventsRepo) GetLatestEventForClient(ctx context.Context, tableCode, clientID string) (*structs.Event, error) {
+	filter := bson.M{"order.table_code": tableCode, "order.client_id": clientID}
+	opts := options.FindOne().SetSort(bson.M{"closed_at": -1})
+	var event structs.Event
+	err := er.Collection.FindOne(ctx, filter, opts).Decode(&event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// GetAverageSessionDuration returns how long the paid sessions of a venue closed since the given
+// time stayed open on average, and how many sessions the average is based on.
+func (er *EventsRepo) GetAverageSessionDuration(ctx context.Context, venueID primitive.ObjectID, since time.Time) (time.Duration, int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"venue_id":        venueID,
+			"status":          "paid",
+			"closed_at":       bson.M{"$gte": since},
+			"order.opened_at": bson.M{"$exists": true},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"average": bson.M{"$avg": bson.M{"$subtract": bson.A{"$closed_at", "$order.opened_at"}}},
+			"count":   bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := er.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Average float64 `bson:"average"`
+		Count   int     `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, 0, err
+	}
+	if len(results) == 0 {
+		return 0, 0, nil
+	}
+	return time.Duration(results[0].Average) * time.Millisecond, results[0].Count, nil
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"vortex.studio/account/internal/structs"
+)
+
+type ReservationsRepository struct {
+	*Repository
+}
+
+func NewReservationsRepository(db *mongo.Database) *ReservationsRepository {
+	return &ReservationsRepository{
+		Repository: &Repository{
+			Collection: db.Collection("reservations"),
+		},
+	}
+}
+
+// ErrTableBooked is returned when a reservation would hold a table another booked reservation
+// holds at the same time.
+var ErrTableBooked = errors.New("table is already booked at that time")
+
+// EnsureIndexes creates the unique index on the minutes booked reservations hold their table,
+// which keeps two bookings from taking a table at once however close together they are made.
+// Bookings made before the index existed don't have minutes and are left out of it.
+func (rr *ReservationsRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := rr.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "table_code", Value: 1}, {Key: "minutes", Value: 1}},
+		Options: options.Index().SetName("booked_table_minutes").SetUnique(true).SetPartialFilterExpression(bson.M{
+			"status":  structs.ReservationBooked,
+			"minutes": bson.M{"$exists": true},
+		}),
+	})
+	return err
+}
+
+// CreateReservation saves a booking along with the minutes it holds its table, it fails with
+// ErrTableBooked when another booking holds the table at the same time.
+func (rr *ReservationsRepository) CreateReservation(ctx context.Context, reservation *structs.Reservation) (*mongo.InsertOneResult, error) {
+	reservation.Minutes = bookedMinutes(reservation.StartsAt, reservation.EndsAt)
+	result, err := rr.Collection.InsertOne(ctx, reservation)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTableBooked
+	}
+	return result, err
+}
+
+func (rr *ReservationsRepository) GetReservationByID(ctx context.Context, id primitive.ObjectID) (*structs.Reservation, error) {
+	var reservation structs.Reservation
+	err := rr.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (rr *ReservationsRepository) GetReservationByReference(ctx context.Context, venueID primitive.ObjectID, reference string) (*structs.Reservation, error) {
+	var reservation structs.Reservation
+	err := rr.Collection.FindOne(ctx, bson.M{"venue_id": venueID, "reference": reference}).Decode(&reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// GetReservationsForVenue returns the reservations of a venue starting in [from, to), sorted by
+// start time.
+func (rr *ReservationsRepository) GetReservationsForVenue(ctx context.Context, venueID primitive.ObjectID, from, to time.Time) ([]structs.Reservation, error) {
+	filter := bson.M{
+		"venue_id":  venueID,
+		"starts_at": bson.M{"$gte": from, "$lt": to},
+	}
+	return rr.find(ctx, filter)
+}
+
+// GetConflicts returns the booked reservations of a table overlapping [start, end), ignoring
+// the reservation being changed.
+func (rr *ReservationsRepository) GetConflicts(ctx context.Context, tableCode string, start, end time.Time, exclude primitive.ObjectID) ([]structs.Reservation, error) {
+	filter := bson.M{
+		"table_code": tableCode,
+		"status":     structs.ReservationBooked,
+		"starts_at":  bson.M{"$lt": end},
+		"ends_at":    bson.M{"$gt": start},
+	}
+	if !exclude.IsZero() {
+		filter["_id"] = bson.M{"$ne": exclude}
+	}
+	return rr.find(ctx, filter)
+}
+
+// GetNextReservationForTable returns the first booked reservation of a table that hasn't ended
+// at the given time.
+func (rr *ReservationsRepository) GetNextReservationForTable(ctx context.Context, tableCode string, at time.Time) (*structs.Reservation, error) {
+	filter := bson.M{
+		"table_code": tableCode,
+		"status":     structs.ReservationBooked,
+		"ends_at":    bson.M{"$gt": at},
+	}
+	opts := options.FindOne().SetSort(bson.M{"starts_at": 1})
+	var reservation structs.Reservation
+	err := rr.Collection.FindOne(ctx, filter, opts).Decode(&reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// SetTable moves a reservation to another table, it fails with ErrTableBooked when another
+// booking holds that table at the same time.
+func (rr *ReservationsRepository) SetTable(ctx context.Context, id primitive.ObjectID, tableCode string) (*mongo.UpdateResult, error) {
+	result, err := rr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"table_code": tableCode}})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTableBooked
+	}
+	return result, err
+}
+
+// MoveTableReservations moves every reservation of a table to another table code.
+func (rr *ReservationsRepository) MoveTableReservations(ctx context.Context, tableCode, newTableCode string) (*mongo.UpdateResult, error) {
+	return rr.Collection.UpdateMany(ctx, bson.M{"table_code": tableCode}, bson.M{"$set": bson.M{"table_code": newTableCode}})
+}
+
+func (rr *ReservationsRepository) SetStatus(ctx context.Context, id primitive.ObjectID, status string) (*mongo.UpdateResult, error) {
+	return rr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": status}})
+}
+
+func (rr *ReservationsRepository) find(ctx context.Context, filter bson.M) ([]structs.Reservation, error) {
+	opts := options.Find().SetSort(bson.M{"starts_at": 1})
+	cursor, err := rr.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var reservations []structs.Reservation
+	if err := cursor.All(ctx, &reservations); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// bookedMinutes returns the start of every minute of [start, end).
+func bookedMinutes(start, end time.Time) []time.Time {
+	var minutes []time.Time
+	for minute := start.UTC().Truncate(time.Minute); minute.Before(end); minute = minute.Add(time.Minute) {
+		minutes = append(minutes, minute)
+	}
+	return minutes
+}
@@ -2,11 +2,10 @@ package repo
 
 import (
 	"context"
-	"github.com/vorticist/logger"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"vortex.studio/account/internal/structs"
-	"vortex.studio/account/internal/utils"
 )
 
 type VenueRepository struct {
@@ -25,8 +24,8 @@ func (vr *VenueRepository) CreateVenue(venue *structs.Venue) (*mongo.InsertOneRe
 	return vr.Collection.InsertOne(context.Background(), venue)
 }
 
-func (vr *VenueRepository) GetAllVenues(ctx context.Context) ([]structs.Venue, error) {
-	cursor, err := vr.Collection.Find(ctx, bson.M{})
+func (vr *VenueRepository) GetVenuesForTenant(ctx context.Context, tenantID string) ([]structs.Venue, error) {
+	cursor, err := vr.Collection.Find(ctx, bson.M{"tenant_id": tenantID})
 	if err != nil {
 		return nil, err
 	}
@@ -37,21 +36,10 @@ func (vr *VenueRepository) GetAllVenues(ctx context.Context) ([]structs.Venue, e
 		return nil, err
 	}
 
-	for _, venue := range venues {
-		for i, code := range venue.TableCodes {
-			qrCodeStr, err := utils.GenerateQRCodeBase64(code.Code)
-			if err != nil {
-				logger.Errorf("error generating QR code: %v", err)
-				continue
-			}
-
-			venue.TableCodes[i].Base64 = qrCodeStr
-		}
-	}
-
 	return venues, nil
 }
-func (vr *VenueRepository) GetVenueById(ctx context.Context, id int) (*structs.Venue, error) {
+
+func (vr *VenueRepository) GetVenueById(ctx context.Context, id primitive.ObjectID) (*structs.Venue, error) {
 	var venue structs.Venue
 	err := vr.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&venue)
 	if err != nil {
@@ -81,3 +69,95 @@ func (vr *VenueRepository) DeleteVenue(ctx context.Context, venue structs.Venue)
 	_, err := vr.Collection.DeleteOne(ctx, filter)
 	return err
 }
+
+func (vr *VenueRepository) SetReservationSettings(ctx context.Context, id primitive.ObjectID, settings structs.ReservationSettings) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"reservations": settings}})
+}
+
+// SetPickupSettings saves the pickup settings of a venue along with the time zone its pickup
+// times are in.
+func (vr *VenueRepository) SetPickupSettings(ctx context.Context, id primitive.ObjectID, settings structs.PickupSettings, timezone string) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"pickup": settings, "timezone": timezone}})
+}
+
+// SetQRSettings saves the QR settings of a venue and points the URLs of all its tables at
+// baseURL, their codes are kept so printed QR codes of the old URL keep working when it still
+// reaches the service.
+func (vr *VenueRepository) SetQRSettings(ctx context.Context, id primitive.ObjectID, settings structs.QRSettings, baseURL string) (*mongo.UpdateResult, error) {
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"qr": bson.M{"$literal": settings},
+		"table_codes": bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$table_codes", bson.A{}}},
+			"in": bson.M{"$mergeObjects": bson.A{
+				"$$this",
+				bson.M{"code_url": bson.M{"$concat": bson.A{baseURL + "/table/", "$$this.code"}}},
+			}},
+		}},
+	}}}}
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+}
+
+// SetMenu switches the menu of a venue, dropping the overrides it had for the previous one.
+func (vr *VenueRepository) SetMenu(ctx context.Context, id, menuID primitive.ObjectID) (*mongo.UpdateResult, error) {
+	update := bson.M{"$set": bson.M{"menu_id": menuID}, "$unset": bson.M{"menu_overrides": ""}}
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+}
+
+func (vr *VenueRepository) SetMenuOverrides(ctx context.Context, id primitive.ObjectID, overrides []structs.MenuOverride) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"menu_overrides": overrides}})
+}
+
+// CountVenuesWithMenu returns how many venues picked a menu.
+func (vr *VenueRepository) CountVenuesWithMenu(ctx context.Context, menuID primitive.ObjectID) (int64, error) {
+	return vr.Collection.CountDocuments(ctx, bson.M{"menu_id": menuID})
+}
+
+func (vr *VenueRepository) AddPrinter(ctx context.Context, id primitive.ObjectID, printer structs.Printer) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$push": bson.M{"printers": printer}})
+}
+
+func (vr *VenueRepository) RemovePrinter(ctx context.Context, id primitive.ObjectID, printerID string) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$pull": bson.M{"printers": bson.M{"id": printerID}}})
+}
+
+// AddTables appends tables to a venue, the existing ones are left untouched.
+func (vr *VenueRepository) AddTables(ctx context.Context, id primitive.ObjectID, tables []structs.TableCode) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$push": bson.M{"table_codes": bson.M{"$each": tables}}})
+}
+
+// RenameTable sets the label and section of a table of a venue.
+func (vr *VenueRepository) RenameTable(ctx context.Context, id primitive.ObjectID, code, label, section string) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "table_codes.code": code}
+	update := bson.M{"$set": bson.M{"table_codes.$.label": label, "table_codes.$.section": section}}
+	return vr.Collection.UpdateOne(ctx, filter, update)
+}
+
+// SetTableRetired retires a table of a venue or puts it back in use.
+func (vr *VenueRepository) SetTableRetired(ctx context.Context, id primitive.ObjectID, code string, retired bool) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "table_codes.code": code}
+	return vr.Collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"table_codes.$.retired": retired}})
+}
+
+// ReplaceTableCode gives a table of a venue a new code and URL, keeping its label and section.
+func (vr *VenueRepository) ReplaceTableCode(ctx context.Context, id primitive.ObjectID, code, newCode, codeURL string) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "table_codes.code": code}
+	update := bson.M{"$set": bson.M{"table_codes.$.code": newCode, "table_codes.$.code_url": codeURL}}
+	return vr.Collection.UpdateOne(ctx, filter, update)
+}
+
+func (vr *VenueRepository) SetFloorPlan(ctx context.Context, id primitive.ObjectID, floor structs.FloorPlan) (*mongo.UpdateResult, error) {
+	return vr.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"floor": floor}})
+}
+
+// SetTableLayout places a table of a venue on its floor plan and sets its section.
+func (vr *VenueRepository) SetTableLayout(ctx context.Context, id primitive.ObjectID, code string, layout structs.TableLayout, section string) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "table_codes.code": code}
+	update := bson.M{"$set": bson.M{"table_codes.$.layout": layout, "table_codes.$.section": section}}
+	return vr.Collection.UpdateOne(ctx, filter, update)
+}
+
+// RemoveTableLayout takes a table of a venue off its floor plan.
+func (vr *VenueRepository) RemoveTableLayout(ctx context.Context, id primitive.ObjectID, code string) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "table_codes.code": code}
+	return vr.Collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"table_codes.$.layout": ""}})
+}
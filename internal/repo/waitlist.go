@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"vortex.studio/account/internal/structs"
+)
+
+type WaitlistRepository struct {
+	*Repository
+}
+
+func NewWaitlistRepository(db *mongo.Database) *WaitlistRepository {
+	return &WaitlistRepository{
+		Repository: &Repository{
+			Collection: db.Collection("waitlist"),
+		},
+	}
+}
+
+func (wr *WaitlistRepository) AddEntry(ctx context.Context, entry *structs.WaitlistEntry) (*mongo.InsertOneResult, error) {
+	return wr.Collection.InsertOne(ctx, entry)
+}
+
+func (wr *WaitlistRepository) GetEntryByID(ctx context.Context, id primitive.ObjectID) (*structs.WaitlistEntry, error) {
+	var entry structs.WaitlistEntry
+	err := wr.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetLatestEntryForClient returns the most recent entry a guest has on the waitlist of a venue.
+func (wr *WaitlistRepository) GetLatestEntryForClient(ctx context.Context, venueID primitive.ObjectID, clientID string) (*structs.WaitlistEntry, error) {
+	opts := options.FindOne().SetSort(bson.M{"created_at": -1})
+	var entry structs.WaitlistEntry
+	err := wr.Collection.FindOne(ctx, bson.M{"venue_id": venueID, "client_id": clientID}, opts).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetWaiting returns the groups still waiting at a venue in the order they joined.
+func (wr *WaitlistRepository) GetWaiting(ctx context.Context, venueID primitive.ObjectID) ([]structs.WaitlistEntry, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := wr.Collection.Find(ctx, bson.M{"venue_id": venueID, "status": structs.WaitlistWaiting}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []structs.WaitlistEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (wr *WaitlistRepository) SeatEntry(ctx context.Context, id primitive.ObjectID, tableCode string) (*mongo.UpdateResult, error) {
+	update := bson.M{"$set": bson.M{
+		"status":     structs.WaitlistSeated,
+		"table_code": tableCode,
+		"seated_at":  time.Now(),
+	}}
+	return wr.Collection.UpdateOne(ctx, bson.M{"_id": id, "status": structs.WaitlistWaiting}, update)
+}
+
+func (wr *WaitlistRepository) CancelEntry(ctx context.Context, id primitive.ObjectID) (*mongo.UpdateResult, error) {
+	update := bson.M{"$set": bson.M{"status": structs.WaitlistCanceled}}
+	return wr.Collection.UpdateOne(ctx, bson.M{"_id": id, "status": structs.WaitlistWaiting}, update)
+}
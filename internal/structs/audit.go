@@ -0,0 +1,50 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a change a staff member made. Route is the method and path template of the
+// request, Action names the change and Target is what it was made to.
+type AuditEntry struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID string             `json:"tenant_id" bson:"tenant_id"`
+	ActorID  string             `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Actor    string             `json:"actor" bson:"actor"`
+	Action   string             `json:"action" bson:"action"`
+	Target   string             `json:"target,omitempty" bson:"target,omitempty"`
+	Route    string             `json:"route" bson:"route"`
+	Changes  []AuditChange      `json:"changes,omitempty" bson:"changes,omitempty"`
+	At       time.Time          `json:"at" bson:"at"`
+}
+
+// AuditChange is a field that changed, named by its path in the changed object. Before is empty
+// for added fields and After for removed ones.
+type AuditChange struct {
+	Field  string `json:"field" bson:"field"`
+	Before string `json:"before,omitempty" bson:"before,omitempty"`
+	After  string `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditFilter narrows down the audit log, empty fields match every entry. Actor, Action and
+// Target match entries containing them.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+}
+
+type AdminAuditPage struct {
+	Title   string
+	Filter  AuditFilter
+	Entries []AuditEntry
+	// Limited is set when there are more matching entries than the page shows
+	Limited bool
+	// From and To are the dates searched for as they were entered
+	From string
+	To   string
+}
@@ -0,0 +1,32 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Feedback is what a guest thought of a closed session, rated from 1 to 5.
+type Feedback struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	VenueID   primitive.ObjectID `json:"venue_id" bson:"venue_id"`
+	EventID   primitive.ObjectID `json:"event_id" bson:"event_id"`
+	TableCode string             `json:"table_code" bson:"table_code"`
+	ClientID  string             `json:"client_id" bson:"client_id"`
+	Rating    int                `json:"rating" bson:"rating"`
+	Dishes    []DishRating       `json:"dishes,omitempty" bson:"dishes,omitempty"`
+	Comment   string             `json:"comment,omitempty" bson:"comment,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type DishRating struct {
+	Name   string `json:"name" bson:"name"`
+	Rating int    `json:"rating" bson:"rating"`
+}
+
+// RatingSummary is the average rating of a venue or dish.
+type RatingSummary struct {
+	Name    string  `json:"name" bson:"name"`
+	Average float64 `json:"average" bson:"average"`
+	Count   int     `json:"count" bson:"count"`
+}
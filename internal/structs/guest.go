@@ -0,0 +1,38 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuestProfile remembers a returning guest across the venues of a tenant. It starts anonymous,
+// keyed on the client_id cookie, and can be upgraded to an account with a name and email which
+// lets the guest link more devices to it.
+type GuestProfile struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID        string             `json:"tenant_id" bson:"tenant_id"`
+	ClientIDs       []string           `json:"client_ids" bson:"client_ids"`
+	Name            string             `json:"name,omitempty" bson:"name,omitempty"`
+	Email           string             `json:"email,omitempty" bson:"email,omitempty"`
+	Visits          []Visit            `json:"visits" bson:"visits"`
+	LinkToken       string             `json:"-" bson:"link_token,omitempty"`
+	LinkTokenExpiry time.Time          `json:"-" bson:"link_token_expiry,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Visit is a closed session of a guest.
+type Visit struct {
+	VenueID      primitive.ObjectID `json:"venue_id" bson:"venue_id"`
+	VenueName    string             `json:"venue_name" bson:"venue_name"`
+	EventID      primitive.ObjectID `json:"event_id" bson:"event_id"`
+	ReceiptToken string             `json:"receipt_token,omitempty" bson:"receipt_token,omitempty"`
+	Total        float64            `json:"total" bson:"total"`
+	Items        []OrderItem        `json:"items" bson:"items"`
+	ClosedAt     time.Time          `json:"closed_at" bson:"closed_at"`
+}
+
+// IsAccount reports whether the guest upgraded the profile to an account.
+func (p *GuestProfile) IsAccount() bool {
+	return p.Email != ""
+}
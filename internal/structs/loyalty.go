@@ -0,0 +1,68 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RewardFreeItem = "free_item"
+	RewardDiscount = "discount"
+
+	LedgerEarn   = "earn"
+	LedgerRedeem = "redeem"
+)
+
+// LoyaltyProgram is the per-tenant configuration of the loyalty program. Guests earn EarnRate
+// points for every currency unit they pay, tips excluded.
+type LoyaltyProgram struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID string             `json:"tenant_id" bson:"tenant_id"`
+	Enabled  bool               `json:"enabled" bson:"enabled"`
+	EarnRate float64            `json:"earn_rate" bson:"earn_rate"`
+	Rewards  []Reward           `json:"rewards" bson:"rewards"`
+}
+
+// Reward is an entry of the reward catalog. Free item rewards take one unit of ItemName off the
+// bill, discount rewards take Amount off.
+type Reward struct {
+	ID       string  `json:"id" bson:"id"`
+	Name     string  `json:"name" bson:"name"`
+	Type     string  `json:"type" bson:"type"`
+	Cost     int     `json:"cost" bson:"cost"`
+	ItemName string  `json:"item_name,omitempty" bson:"item_name,omitempty"`
+	Amount   float64 `json:"amount,omitempty" bson:"amount,omitempty"`
+}
+
+// LedgerEntry is an append-only change of a guest's points balance.
+type LedgerEntry struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID    string             `json:"tenant_id" bson:"tenant_id"`
+	ProfileID   primitive.ObjectID `json:"profile_id" bson:"profile_id"`
+	Kind        string             `json:"kind" bson:"kind"`
+	Points      int                `json:"points" bson:"points"`
+	EventID     primitive.ObjectID `json:"event_id,omitempty" bson:"event_id,omitempty"`
+	RewardID    string             `json:"reward_id,omitempty" bson:"reward_id,omitempty"`
+	Description string             `json:"description" bson:"description"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Redemption is a reward the guest chose to apply to the bill of an ActiveTable. The points are
+// only taken from the balance once the table is closed as paid.
+type Redemption struct {
+	RewardID string  `json:"reward_id" bson:"reward_id"`
+	Name     string  `json:"name" bson:"name"`
+	Points   int     `json:"points" bson:"points"`
+	Discount float64 `json:"discount" bson:"discount"`
+}
+
+// Reward returns the catalog reward with the given id.
+func (p *LoyaltyProgram) Reward(id string) (Reward, bool) {
+	for _, reward := range p.Rewards {
+		if reward.ID == id {
+			return reward, true
+		}
+	}
+	return Reward{}, false
+}
@@ -1,23 +1,175 @@
 package structs
 
+import (
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Menu is a menu of a tenant, any number of its venues can use it. VenueID is the venue it was
+// created for, menus created before they could be shared only have that and no tenant or name.
+type Menu struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Name     string             `json:"name,omitempty" bson:"name,omitempty"`
+	VenueID  primitive.ObjectID `json:"venue_id,omitempty" bson:"venueId"`
+	Data     MenuData           `json:"menu" bson:"categoryResult"`
+}
+
+// MenuOverride changes an item of the menu of a venue for that venue only. Items are matched by
+// the names of their category and themselves, Price replaces the price of the menu when set and
+// Unavailable items aren't offered.
+type MenuOverride struct {
+	Category    string   `json:"category" bson:"category"`
+	Item        string   `json:"item" bson:"item"`
+	Price       *float64 `json:"price,omitempty" bson:"price,omitempty"`
+	Unavailable bool     `json:"unavailable,omitempty" bson:"unavailable,omitempty"`
+}
+
 type MenuData struct {
+	// Language is the language code of the original menu text, e.g. "es".
+	Language   string     `json:"language,omitempty" bson:"language,omitempty"`
 	Categories []Category `json:"categories"`
 }
 
-// Category represents a category in the menu, like Food, Drinks, or Amenities.
+// Category represents a category in the menu, like Food, Drinks, or Amenities. Station is where
+// its items are prepared, e.g. "bar", and defaults to the kitchen.
 type Category struct {
-	Name  string     `json:"name"`
-	Items []MenuItem `json:"items"`
+	Name         string            `json:"name"`
+	Items        []MenuItem        `json:"items"`
+	Translations map[string]string `json:"translations,omitempty" bson:"translations,omitempty"`
+	Station      string            `json:"station,omitempty" bson:"station,omitempty"`
 }
 
 // MenuItem represents a single item in the menu.
 type MenuItem struct {
-	Name        string  `json:"name" bson:"name"`
-	Description string  `json:"description,omitempty" bson:"description,omitempty"`
-	Price       float64 `json:"price" bson:"price"`
+	Name         string                     `json:"name" bson:"name"`
+	Description  string                     `json:"description,omitempty" bson:"description,omitempty"`
+	Price        float64                    `json:"price" bson:"price"`
+	Image        string                     `json:"image,omitempty" bson:"image,omitempty"`
+	Thumbnail    string                     `json:"thumbnail,omitempty" bson:"thumbnail,omitempty"`
+	Translations map[string]ItemTranslation `json:"translations,omitempty" bson:"translations,omitempty"`
+}
+
+// ItemTranslation holds the translated texts of a menu item for a single language.
+type ItemTranslation struct {
+	Name        string `json:"name" bson:"name"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
 }
 
 type OrderItem struct {
 	*MenuItem
 	Amount int `json:"amount" bson:"amount"`
 }
+
+// StationForItem returns the station the item with the given name is prepared at.
+func (m MenuData) StationForItem(name string) string {
+	for _, category := range m.Categories {
+		for _, item := range category.Items {
+			if item.Name == name && category.Station != "" {
+				return category.Station
+			}
+		}
+	}
+	return DefaultStation
+}
+
+// Item returns the item with the given name.
+func (m MenuData) Item(name string) (MenuItem, bool) {
+	for _, category := range m.Categories {
+		for _, item := range category.Items {
+			if item.Name == name {
+				return item, true
+			}
+		}
+	}
+	return MenuItem{}, false
+}
+
+// Languages returns every language the menu can be displayed in, starting with the original one.
+func (m MenuData) Languages() []string {
+	seen := map[string]bool{}
+	var langs []string
+	if m.Language != "" {
+		seen[m.Language] = true
+		langs = append(langs, m.Language)
+	}
+
+	var translated []string
+	for _, category := range m.Categories {
+		for lang := range category.Translations {
+			if !seen[lang] {
+				seen[lang] = true
+				translated = append(translated, lang)
+			}
+		}
+		for _, item := range category.Items {
+			for lang := range item.Translations {
+				if !seen[lang] {
+					seen[lang] = true
+					translated = append(translated, lang)
+				}
+			}
+		}
+	}
+	sort.Strings(translated)
+
+	return append(langs, translated...)
+}
+
+// LocalizedName returns the category name in the given language, falling back to the original text.
+func (c Category) LocalizedName(lang string) string {
+	if name, ok := c.Translations[lang]; ok && name != "" {
+		return name
+	}
+	return c.Name
+}
+
+// LocalizedName returns the item name in the given language, falling back to the original text.
+func (i MenuItem) LocalizedName(lang string) string {
+	if t, ok := i.Translations[lang]; ok && t.Name != "" {
+		return t.Name
+	}
+	return i.Name
+}
+
+// LocalizedDescription returns the item description in the given language, falling back to the original text.
+func (i MenuItem) LocalizedDescription(lang string) string {
+	if t, ok := i.Translations[lang]; ok && t.Description != "" {
+		return t.Description
+	}
+	return i.Description
+}
+
+// WithOverrides returns a copy of the menu as a venue offers it, with the prices of its overrides
+// and without its unavailable items. Categories left without items are dropped.
+func (m MenuData) WithOverrides(overrides []MenuOverride) MenuData {
+	if len(overrides) == 0 {
+		return m
+	}
+	byItem := map[[2]string]MenuOverride{}
+	for _, override := range overrides {
+		byItem[[2]string{override.Category, override.Item}] = override
+	}
+
+	var categories []Category
+	for _, category := range m.Categories {
+		var items []MenuItem
+		for _, item := range category.Items {
+			override, ok := byItem[[2]string{category.Name, item.Name}]
+			if ok && override.Unavailable {
+				continue
+			}
+			if ok && override.Price != nil {
+				item.Price = *override.Price
+			}
+			items = append(items, item)
+		}
+		if len(items) > 0 {
+			category.Items = items
+			categories = append(categories, category)
+		}
+	}
+	m.Categories = categories
+	return m
+}
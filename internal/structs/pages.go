@@ -1,19 +1,306 @@
 package structs
 
+import "time"
+
 type AdminPage struct {
 	Title        string
 	Venues       []Venue
 	OpenSessions []*ActiveTable
+	// Menus are the menus of the tenant venues can use
+	Menus []Menu
+	// Can holds the permissions of the signed in staff member
+	Can map[string]bool
+	// CSRFToken has to be sent back with every form and HTMX request that changes something
+	CSRFToken string
 }
 
 type MenuPage struct {
-	Title     string
-	Menu      MenuData
-	TableCode string
+	Title       string
+	Venue       Venue
+	Menu        MenuData
+	TableCode   string
+	Language    string
+	Languages   []string
+	Suggestions []MenuItem
+	Reservation *Reservation
 }
 
 type OrderPage struct {
 	Title        string
 	Session      *ActiveTable
 	CurrentTotal float64
+	CanPayOnline bool
+	Loyalty      *LoyaltySummary
+}
+
+// LoyaltySummary is the points balance of the guest of a table and the rewards they can apply
+// to its bill.
+type LoyaltySummary struct {
+	VenueID    string
+	Balance    int
+	Rewards    []Reward
+	Redemption *Redemption
+}
+
+// VenueMenuPage shows the menu a venue uses with the overrides of the venue. SharedWith are the
+// other venues using the menu, which changes to the menu apply to as well.
+type VenueMenuPage struct {
+	Title      string
+	Venue      Venue
+	MenuName   string
+	Menu       MenuData
+	SharedWith []string
+	CSRFToken  string
+}
+
+type ReceiptPage struct {
+	Title    string
+	Receipt  *Receipt
+	CanEmail bool
+}
+
+type FakeCheckoutPage struct {
+	Title       string
+	ID          string
+	Description string
+	Amount      float64
+	Currency    string
+}
+
+type FeedbackPage struct {
+	Title     string
+	Token     string
+	VenueName string
+	Dishes    []string
+	Submitted bool
+}
+
+type AdminFeedbackPage struct {
+	Title     string
+	Venues    []VenueRatings
+	LowScores []LowScore
+	Threshold int
+}
+
+type VenueRatings struct {
+	Venue  Venue
+	Rating RatingSummary
+	Dishes []RatingSummary
+}
+
+type LowScore struct {
+	Feedback  Feedback
+	VenueName string
+}
+
+type GuestPage struct {
+	Title    string
+	Venue    Venue
+	Profile  *GuestProfile
+	Visits   []Visit
+	CanEmail bool
+	Message  string
+}
+
+type GuestLinkPage struct {
+	Title   string
+	Token   string
+	Email   string
+	VenueID string
+}
+
+type PointsPage struct {
+	Title   string
+	Venue   Venue
+	Balance int
+	Entries []LedgerEntry
+	Rewards []Reward
+}
+
+type AdminLoyaltyPage struct {
+	Title     string
+	Program   *LoyaltyProgram
+	CSRFToken string
+}
+
+type BookingSlot struct {
+	Time      string
+	Available bool
+}
+
+type BookingPage struct {
+	Title string
+	Venue Venue
+	Date  string
+	Today string
+	Slots []BookingSlot
+	Error string
+}
+
+// BookingDetailsPage shows a reservation, times are displayed in Location, the time zone of the
+// venue.
+type BookingDetailsPage struct {
+	Title       string
+	Venue       Venue
+	Reservation *Reservation
+	Location    *time.Location
+	URL         string
+}
+
+type TableSchedule struct {
+	Code         string
+	Label        string
+	Reservations []Reservation
+}
+
+type ReservationsCalendarPage struct {
+	Title     string
+	Venues    []Venue
+	Venue     Venue
+	Date      string
+	Previous  string
+	Next      string
+	Location  *time.Location
+	Tables    []TableSchedule
+	Can       map[string]bool
+	CSRFToken string
+}
+
+// ReservedPage is shown to walk-in guests of a table that is held for a booking.
+type ReservedPage struct {
+	Title       string
+	TableCode   string
+	Reservation *Reservation
+	Location    *time.Location
+	Error       string
+}
+
+// QueuedEntry is a waiting group with its place in the queue.
+type QueuedEntry struct {
+	WaitlistEntry
+	Position      int
+	EstimatedWait time.Duration
+}
+
+// WaitlistPage is the waitlist status of a guest, Entry is nil until they join.
+type WaitlistPage struct {
+	Title         string
+	Venue         Venue
+	Entry         *WaitlistEntry
+	Position      int
+	EstimatedWait time.Duration
+}
+
+type AdminWaitlistPage struct {
+	Title      string
+	Venues     []Venue
+	Venue      Venue
+	Entries    []QueuedEntry
+	FreeTables []TableCode
+	JoinURL    string
+	QRCode     string
+	Error      string
+	CSRFToken  string
+}
+
+// PickupPage starts a pickup order, Earliest is the first time it can be collected.
+type PickupPage struct {
+	Title    string
+	Venue    Venue
+	Earliest time.Time
+	Error    string
+}
+
+// AdminMenu is a menu of the tenant with the names of the venues using it.
+type AdminMenu struct {
+	Menu
+	Venues []string
+}
+
+type AdminMenusPage struct {
+	Title     string
+	Menus     []AdminMenu
+	Error     string
+	CSRFToken string
+}
+
+type AdminPrintingPage struct {
+	Title     string
+	Venues    []Venue
+	Venue     Venue
+	Menu      MenuData
+	Jobs      []PrintJob
+	Error     string
+	Can       map[string]bool
+	CSRFToken string
+}
+
+type LoginPage struct {
+	Title  string
+	Tenant string
+	Error  string
+}
+
+type StaffPage struct {
+	Title   string
+	Members []StaffMember
+	Roles   []string
+	// UserID is the staff member viewing the page, who can't change their own account
+	UserID    string
+	Error     string
+	Notice    string
+	CSRFToken string
+}
+
+// SessionCard is an open session on the admin dashboard. Expanded keeps its details open when
+// the card is refreshed.
+type SessionCard struct {
+	*ActiveTable
+	Expanded bool
+}
+
+// FloorTable is a table on the floor plan with the session open at it, if any.
+type FloorTable struct {
+	TableCode
+	State   string
+	Session *ActiveTable
+}
+
+// FloorCell is a cell of the floor plan grid, Table is nil for empty cells.
+type FloorCell struct {
+	X     int
+	Y     int
+	Table *FloorTable
+}
+
+// FloorPage is the floor plan of a venue, Grid holds its cells row by row. Unplaced are the
+// tables that aren't on the floor plan yet. In Edit mode tables can be moved around.
+type FloorPage struct {
+	Title     string
+	Venues    []Venue
+	Venue     Venue
+	Columns   int
+	Rows      int
+	Grid      [][]FloorCell
+	Unplaced  []FloorTable
+	Shapes    []string
+	Edit      bool
+	Can       map[string]bool
+	CSRFToken string
+}
+
+// ImportProblem is why part of an imported file was rejected. Row is the line of CSV files, zero
+// for venue documents, and Field what was wrong.
+type ImportProblem struct {
+	Row     int    `json:"row,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportReportPage lists the problems of a rejected import, Back is where to try again.
+type ImportReportPage struct {
+	Title    string
+	File     string
+	Problems []ImportProblem
+	Back     string
 }
@@ -0,0 +1,59 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultStation = "kitchen"
+
+	PrintPending = "pending"
+	PrintSending = "sending"
+	PrintPrinted = "printed"
+	PrintFailed  = "failed"
+)
+
+// Printer is a network ticket printer of a venue. Tickets of the items of a Station are sent to
+// every printer of that station, Address is the "host:port" of its raw TCP interface.
+type Printer struct {
+	ID      string `json:"id" bson:"id"`
+	Name    string `json:"name" bson:"name"`
+	Station string `json:"station" bson:"station"`
+	Address string `json:"address" bson:"address"`
+}
+
+// Ticket is an order as printed for a station.
+type Ticket struct {
+	VenueName    string       `json:"venue_name" bson:"venue_name"`
+	Station      string       `json:"station" bson:"station"`
+	TableCode    string       `json:"table_code" bson:"table_code"`
+	OrderNumber  string       `json:"order_number,omitempty" bson:"order_number,omitempty"`
+	CustomerName string       `json:"customer_name,omitempty" bson:"customer_name,omitempty"`
+	Items        []TicketItem `json:"items" bson:"items"`
+	PlacedAt     time.Time    `json:"placed_at" bson:"placed_at"`
+	Reprint      bool         `json:"reprint,omitempty" bson:"reprint,omitempty"`
+}
+
+type TicketItem struct {
+	Name   string `json:"name" bson:"name"`
+	Amount int    `json:"amount" bson:"amount"`
+}
+
+// PrintJob is a ticket queued for a printer. Failed attempts are retried at NextAttemptAt until
+// the job runs out of attempts and is marked failed. Jobs a worker claimed are sending until
+// NextAttemptAt, when they are due again if the worker didn't finish them.
+type PrintJob struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	VenueID       primitive.ObjectID `json:"venue_id" bson:"venue_id"`
+	PrinterName   string             `json:"printer_name" bson:"printer_name"`
+	Address       string             `json:"address" bson:"address"`
+	Ticket        Ticket             `json:"ticket" bson:"ticket"`
+	Status        string             `json:"status" bson:"status"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	LastError     string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	PrintedAt     time.Time          `json:"printed_at,omitempty" bson:"printed_at,omitempty"`
+}
@@ -0,0 +1,35 @@
+package structs
+
+import "time"
+
+// ReceiptLine is a single itemized row of a receipt.
+type ReceiptLine struct {
+	Name      string
+	Amount    int
+	UnitPrice float64
+	Total     float64
+}
+
+// Receipt is the itemized bill of a closed table, ready to be rendered as HTML or PDF.
+type Receipt struct {
+	Number        string
+	Token         string
+	Status        string
+	ClosedAt      time.Time
+	TableCode     string
+	PaymentMethod string
+
+	VenueName    string
+	VenueAddress string
+	VenuePhone   string
+	VenueTaxID   string
+
+	Lines        []ReceiptLine
+	Subtotal     float64
+	Discount     float64
+	DiscountName string
+	TaxRate      float64
+	Tax          float64
+	Tip          float64
+	Total        float64
+}
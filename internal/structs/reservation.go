@@ -0,0 +1,93 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ReservationBooked   = "booked"
+	ReservationSeated   = "seated"
+	ReservationCanceled = "canceled"
+)
+
+// ReservationSettings configure the bookable slots of a venue. OpensAt and ClosesAt are "15:04"
+// times in Timezone, bookings start every SlotMinutes and hold the table for DurationMinutes.
+// Walk-in sessions are blocked on a reserved table from HoldMinutes before the booking starts.
+type ReservationSettings struct {
+	Enabled         bool   `json:"enabled" bson:"enabled"`
+	Timezone        string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	OpensAt         string `json:"opens_at" bson:"opens_at"`
+	ClosesAt        string `json:"closes_at" bson:"closes_at"`
+	SlotMinutes     int    `json:"slot_minutes" bson:"slot_minutes"`
+	DurationMinutes int    `json:"duration_minutes" bson:"duration_minutes"`
+	HoldMinutes     int    `json:"hold_minutes" bson:"hold_minutes"`
+}
+
+// Reservation is a booking of a table of a venue. Reference is the short code the guest uses to
+// claim the table and manage the booking. Minutes are the starts of the minutes it holds its
+// table, which are unique per table among booked reservations.
+type Reservation struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	VenueID   primitive.ObjectID `json:"venue_id" bson:"venue_id"`
+	TableCode string             `json:"table_code" bson:"table_code"`
+	Reference string             `json:"reference" bson:"reference"`
+	Name      string             `json:"name" bson:"name"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+	Phone     string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	PartySize int                `json:"party_size" bson:"party_size"`
+	StartsAt  time.Time          `json:"starts_at" bson:"starts_at"`
+	EndsAt    time.Time          `json:"ends_at" bson:"ends_at"`
+	Status    string             `json:"status" bson:"status"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	Minutes   []time.Time        `json:"-" bson:"minutes,omitempty"`
+}
+
+// Location returns the time zone the venue takes bookings in.
+func (s ReservationSettings) Location() *time.Location {
+	if s.Timezone != "" {
+		if loc, err := time.LoadLocation(s.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// Duration returns how long a booking holds its table.
+func (s ReservationSettings) Duration() time.Duration {
+	return time.Duration(s.DurationMinutes) * time.Minute
+}
+
+// Hold returns how long before a booking walk-in sessions are blocked on its table.
+func (s ReservationSettings) Hold() time.Duration {
+	return time.Duration(s.HoldMinutes) * time.Minute
+}
+
+// Slots returns the start times bookings can be made for on the given day. The last slot leaves
+// room for a full booking before closing time.
+func (s ReservationSettings) Slots(day time.Time) []time.Time {
+	loc := s.Location()
+	day = day.In(loc)
+	opens, err := time.ParseInLocation("15:04", s.OpensAt, loc)
+	if err != nil {
+		return nil
+	}
+	closes, err := time.ParseInLocation("15:04", s.ClosesAt, loc)
+	if err != nil || s.SlotMinutes <= 0 {
+		return nil
+	}
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), opens.Hour(), opens.Minute(), 0, 0, loc)
+	end := time.Date(day.Year(), day.Month(), day.Day(), closes.Hour(), closes.Minute(), 0, 0, loc)
+	if !end.After(start) {
+		// Venues open past midnight close the next day
+		end = end.AddDate(0, 0, 1)
+	}
+
+	var slots []time.Time
+	for slot := start; !slot.Add(s.Duration()).After(end); slot = slot.Add(time.Duration(s.SlotMinutes) * time.Minute) {
+		slots = append(slots, slot)
+	}
+	return slots
+}
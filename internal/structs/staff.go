@@ -0,0 +1,29 @@
+package structs
+
+// StaffMember is a user of the Keycloak realm of a tenant. Role is their staff role, empty when
+// they have none. Pending members haven't finished setting up their account.
+type StaffMember struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Enabled   bool   `json:"enabled"`
+	Pending   bool   `json:"pending"`
+}
+
+// StaffInvite is a new staff member to add to a tenant.
+type StaffInvite struct {
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Role      string `json:"role"`
+}
+
+// StaffUpdate changes the role or status of a staff member, nil fields are left as they are.
+type StaffUpdate struct {
+	Role    *string `json:"role"`
+	Enabled *bool   `json:"enabled"`
+}
@@ -1,31 +1,273 @@
 package structs
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Venue is a location of a tenant. TaxRate is the sales tax percentage applied to its receipts,
+// e.g. 16 for 16%. Timezone is the IANA name of the time zone the venue keeps its hours in.
 type Venue struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID    string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
 	Name        string             `json:"name" bson:"name"`
 	Description string             `json:"description" bson:"description"`
 	Image       string             `json:"image" bson:"image"`
+	Thumbnail   string             `json:"thumbnail,omitempty" bson:"thumbnail,omitempty"`
+	Address     string             `json:"address,omitempty" bson:"address,omitempty"`
+	Phone       string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	TaxID       string             `json:"tax_id,omitempty" bson:"tax_id,omitempty"`
+	TaxRate     float64            `json:"tax_rate,omitempty" bson:"tax_rate,omitempty"`
+	Timezone    string             `json:"timezone,omitempty" bson:"timezone,omitempty"`
 	TableCodes  []TableCode        `json:"table_codes" bson:"table_codes"`
+
+	Reservations ReservationSettings `json:"reservations" bson:"reservations"`
+	Pickup       PickupSettings      `json:"pickup" bson:"pickup"`
+	Printers     []Printer           `json:"printers,omitempty" bson:"printers,omitempty"`
+	Floor        FloorPlan           `json:"floor" bson:"floor"`
+	QR           QRSettings          `json:"qr" bson:"qr"`
+
+	// MenuID is the menu the venue uses, venues created before menus could be shared use the
+	// menu created for them while it is zero
+	MenuID        primitive.ObjectID `json:"menu_id,omitempty" bson:"menu_id,omitempty"`
+	MenuOverrides []MenuOverride     `json:"menu_overrides,omitempty" bson:"menu_overrides,omitempty"`
+}
+
+const (
+	DefaultFloorColumns = 10
+	DefaultFloorRows    = 6
+	// MaxFloorSize is the most columns or rows a floor plan can have
+	MaxFloorSize = 40
+)
+
+// FloorPlan is the grid the tables of a venue are placed on.
+type FloorPlan struct {
+	Columns int `json:"columns" bson:"columns"`
+	Rows    int `json:"rows" bson:"rows"`
 }
 
+// Size returns the columns and rows of the floor plan, venues that haven't set one get the
+// default grid.
+func (f FloorPlan) Size() (int, int) {
+	columns, rows := f.Columns, f.Rows
+	if columns <= 0 {
+		columns = DefaultFloorColumns
+	}
+	if rows <= 0 {
+		rows = DefaultFloorRows
+	}
+	return columns, rows
+}
+
+const (
+	ShapeSquare = "square"
+	ShapeRound  = "round"
+	ShapeLong   = "long"
+)
+
+var TableShapes = []string{ShapeSquare, ShapeRound, ShapeLong}
+
+// TableLayout places a table on the floor plan of its venue, X and Y are the zero based column
+// and row of the grid cell it stands on.
+type TableLayout struct {
+	X     int    `json:"x" bson:"x"`
+	Y     int    `json:"y" bson:"y"`
+	Shape string `json:"shape" bson:"shape"`
+	Seats int    `json:"seats" bson:"seats"`
+}
+
+// PickupSettings configure takeaway ordering of a venue. PrepMinutes is how long after the
+// order is placed it is ready to collect at the earliest.
+type PickupSettings struct {
+	Enabled     bool `json:"enabled" bson:"enabled"`
+	PrepMinutes int  `json:"prep_minutes" bson:"prep_minutes"`
+}
+
+// QRSettings configure the QR codes of the tables of a venue. Domain is the base URL the codes
+// point at, for businesses serving the menu on their own domain, and the service URL when
+// empty. Recovery is the error correction level, Foreground and Background "#rrggbb" colors and
+// Logo puts the venue image in the middle of the code. Zero values use the defaults.
+type QRSettings struct {
+	Domain     string `json:"domain,omitempty" bson:"domain,omitempty"`
+	Size       int    `json:"size,omitempty" bson:"size,omitempty"`
+	Recovery   string `json:"recovery,omitempty" bson:"recovery,omitempty"`
+	Foreground string `json:"foreground,omitempty" bson:"foreground,omitempty"`
+	Background string `json:"background,omitempty" bson:"background,omitempty"`
+	Logo       bool   `json:"logo,omitempty" bson:"logo,omitempty"`
+}
+
+// TableCode is a table of a venue. Code is the unguessable identifier in the URL of its QR
+// code, Label the name staff know the table by and Section the part of the venue it is in.
+// Retired tables are kept so their history still shows their label, but can't be ordered at.
 type TableCode struct {
 	Code    string `json:"code" bson:"code"`
+	Label   string `json:"label,omitempty" bson:"label,omitempty"`
+	Section string `json:"section,omitempty" bson:"section,omitempty"`
+	Retired bool   `json:"retired,omitempty" bson:"retired,omitempty"`
 	CodeUrl string `json:"code_url" bson:"code_url"`
-	Base64  string `json:"base64" bson:"-"`
+	// Layout is nil until the table is placed on the floor plan
+	Layout *TableLayout `json:"layout,omitempty" bson:"layout,omitempty"`
+}
+
+// Name returns the label of a table, tables created before labels existed go by their code.
+func (t TableCode) Name() string {
+	if t.Label != "" {
+		return t.Label
+	}
+	return t.Code
+}
+
+// Location returns the time zone of the venue. Venues that haven't set one use the time zone
+// of their reservations, which falls back to the one of the server.
+func (v Venue) Location() *time.Location {
+	if v.Timezone != "" {
+		if loc, err := time.LoadLocation(v.Timezone); err == nil {
+			return loc
+		}
+	}
+	return v.Reservations.Location()
+}
+
+// Table returns the table of the venue with a code.
+func (v Venue) Table(code string) (*TableCode, bool) {
+	for i := range v.TableCodes {
+		if v.TableCodes[i].Code == code {
+			return &v.TableCodes[i], true
+		}
+	}
+	return nil, false
+}
+
+// ItemOverride returns the override of the venue for an item of its menu, the zero value when the
+// venue offers it as the menu has it.
+func (v Venue) ItemOverride(category, item string) MenuOverride {
+	for _, override := range v.MenuOverrides {
+		if override.Category == category && override.Item == item {
+			return override
+		}
+	}
+	return MenuOverride{Category: category, Item: item}
 }
 
+// ActiveTables returns the tables of the venue that haven't been retired.
+func (v Venue) ActiveTables() []TableCode {
+	var tables []TableCode
+	for _, table := range v.TableCodes {
+		if !table.Retired {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+const ModePickup = "pickup"
+
+// ActiveTable is the open session of a table. ClientID is the guest that opened it,
+// AdditionalClients are the guests of sessions that were merged into it. Pickup sessions aren't
+// bound to a table of the venue, their TableCode is generated and they carry the name, order
+// number and ready time the order is collected with.
 type ActiveTable struct {
-	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	TableCode    string             `json:"table_code" bson:"table_code"`
-	ClientID     string             `json:"client_id" bson:"client_id"`
-	OrderHistory []OrderItem        `json:"order_history" bson:"order_history"`
-	PreOrder     []OrderItem        `json:"pre_order" bson:"pre_order"`
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TableCode         string             `json:"table_code" bson:"table_code"`
+	ClientID          string             `json:"client_id" bson:"client_id"`
+	AdditionalClients []string           `json:"additional_clients,omitempty" bson:"additional_clients,omitempty"`
+	OrderHistory      []OrderItem        `json:"order_history" bson:"order_history"`
+	PreOrder          []OrderItem        `json:"pre_order" bson:"pre_order"`
+	Payment           *Payment           `json:"payment,omitempty" bson:"payment,omitempty"`
+	Redemption        *Redemption        `json:"redemption,omitempty" bson:"redemption,omitempty"`
+	OpenedAt          time.Time          `json:"opened_at,omitempty" bson:"opened_at,omitempty"`
+
+	Mode         string             `json:"mode,omitempty" bson:"mode,omitempty"`
+	VenueID      primitive.ObjectID `json:"venue_id,omitempty" bson:"venue_id,omitempty"`
+	CustomerName string             `json:"customer_name,omitempty" bson:"customer_name,omitempty"`
+	OrderNumber  string             `json:"order_number,omitempty" bson:"order_number,omitempty"`
+	ReadyAt      time.Time          `json:"ready_at,omitempty" bson:"ready_at,omitempty"`
+
+	// OrderedAt is when the guests last placed an order and ServedAt when staff last brought
+	// food, BillRequestedAt is set while the guests are waiting for the bill
+	OrderedAt       time.Time `json:"ordered_at,omitempty" bson:"ordered_at,omitempty"`
+	ServedAt        time.Time `json:"served_at,omitempty" bson:"served_at,omitempty"`
+	BillRequestedAt time.Time `json:"bill_requested_at,omitempty" bson:"bill_requested_at,omitempty"`
+
+	// TableLabel is the label of the table for display, it isn't stored with the session
+	TableLabel string `json:"table_label,omitempty" bson:"-"`
+}
+
+func (t *ActiveTable) IsPickup() bool {
+	return t.Mode == ModePickup
 }
 
+// States of a table on the floor plan.
+const (
+	TableFree          = "free"
+	TableOrdering      = "ordering"
+	TableWaiting       = "waiting"
+	TableBillRequested = "bill_requested"
+)
+
+// State returns how far along its visit the table of the session is.
+func (t *ActiveTable) State() string {
+	switch {
+	case !t.BillRequestedAt.IsZero():
+		return TableBillRequested
+	case t.OrderedAt.After(t.ServedAt):
+		return TableWaiting
+	default:
+		return TableOrdering
+	}
+}
+
+// HasClient reports whether a guest can order on the session.
+func (t *ActiveTable) HasClient(clientID string) bool {
+	if clientID == "" {
+		return false
+	}
+	if t.ClientID == clientID {
+		return true
+	}
+	for _, id := range t.AdditionalClients {
+		if id == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Payment is an online checkout started by the guest of an ActiveTable. Charged is set when a
+// checkout completed without covering the bill, the session then stays open for staff to settle.
+type Payment struct {
+	CheckoutID string    `json:"checkout_id" bson:"checkout_id"`
+	Amount     float64   `json:"amount" bson:"amount"`
+	Tip        float64   `json:"tip" bson:"tip"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	Charged    float64   `json:"charged,omitempty" bson:"charged,omitempty"`
+}
+
+// NeedsReview reports whether the provider charged an amount that didn't match the bill.
+func (p *Payment) NeedsReview() bool {
+	return p != nil && p.Charged > 0
+}
+
+// Event records how an ActiveTable was closed. Paid events carry the receipt totals and the
+// TaxRate they were calculated with, ReceiptToken is the unguessable identifier guests use to
+// view their receipt. Sessions that are moved or merged record an event on each table involved,
+// RelatedTable being the other one.
 type Event struct {
 	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Status string             `json:"status" bson:"status"`
 	Order  ActiveTable        `json:"order" bson:"order"`
+
+	VenueID       primitive.ObjectID `json:"venue_id,omitempty" bson:"venue_id,omitempty"`
+	RelatedTable  string             `json:"related_table,omitempty" bson:"related_table,omitempty"`
+	ClosedAt      time.Time          `json:"closed_at" bson:"closed_at"`
+	ReceiptNumber string             `json:"receipt_number,omitempty" bson:"receipt_number,omitempty"`
+	ReceiptToken  string             `json:"receipt_token,omitempty" bson:"receipt_token,omitempty"`
+	PaymentMethod string             `json:"payment_method,omitempty" bson:"payment_method,omitempty"`
+	Subtotal      float64            `json:"subtotal" bson:"subtotal"`
+	Discount      float64            `json:"discount,omitempty" bson:"discount,omitempty"`
+	TaxRate       float64            `json:"tax_rate,omitempty" bson:"tax_rate,omitempty"`
+	Tax           float64            `json:"tax" bson:"tax"`
+	Tip           float64            `json:"tip" bson:"tip"`
+	Total         float64            `json:"total" bson:"total"`
 }
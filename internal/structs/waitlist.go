@@ -0,0 +1,28 @@
+package structs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WaitlistWaiting  = "waiting"
+	WaitlistSeated   = "seated"
+	WaitlistCanceled = "canceled"
+)
+
+// WaitlistEntry is a walk-in group waiting for a table of a venue. Once seated, TableCode is the
+// table their session was opened on.
+type WaitlistEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	VenueID   primitive.ObjectID `json:"venue_id" bson:"venue_id"`
+	ClientID  string             `json:"client_id" bson:"client_id"`
+	Name      string             `json:"name" bson:"name"`
+	PartySize int                `json:"party_size" bson:"party_size"`
+	Phone     string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	Status    string             `json:"status" bson:"status"`
+	TableCode string             `json:"table_code,omitempty" bson:"table_code,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	SeatedAt  time.Time          `json:"seated_at,omitempty" bson:"seated_at,omitempty"`
+}
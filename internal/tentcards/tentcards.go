@@ -0,0 +1,166 @@
+// Package tentcards renders the print pack of a venue: a PDF with a folding tent card for every
+// table and a ZIP with the QR code of every table as PNG and SVG.
+package tentcards
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/go-pdf/fpdf"
+	"vortex.studio/account/internal/qr"
+	"vortex.studio/account/internal/structs"
+)
+
+const (
+	// A4 portrait folded in half across the middle, each half is one side of the card
+	pageWidth  = 210.0
+	pageHeight = 297.0
+	panel      = pageHeight / 2
+
+	qrSize     = 70.0
+	logoHeight = 16.0
+	qrPixels   = 1024
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// WritePDF renders one tent card per active table of the venue. The logo is the venue image as
+// a JPEG or PNG, cards are printed without it when it is empty.
+func WritePDF(w io.Writer, venue *structs.Venue, logo []byte) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	var logoInfo *fpdf.ImageInfoType
+	if imageType := imageType(logo); imageType != "" {
+		logoInfo = pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(logo))
+		if pdf.Err() {
+			// A logo that can't be read shouldn't stop the cards from printing
+			pdf.ClearError()
+			logoInfo = nil
+		}
+	}
+
+	opts, err := qr.VenueOptions(venue.QR, logo)
+	if err != nil {
+		return err
+	}
+	opts.Size = qrPixels
+
+	for _, table := range venue.ActiveTables() {
+		png, err := qr.PNG(table.CodeUrl, opts)
+		if err != nil {
+			return fmt.Errorf("failed to render QR code of table %v: %w", table.Name(), err)
+		}
+		qrName := "qr-" + table.Code
+		pdf.RegisterImageOptionsReader(qrName, fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+
+		pdf.AddPage()
+		// The back is upside down on the top half so both sides read the right way up once folded
+		pdf.TransformBegin()
+		pdf.TransformRotate(180, pageWidth/2, panel/2)
+		drawPanel(pdf, tr, 0, venue, table, qrName, logoInfo)
+		pdf.TransformEnd()
+		drawPanel(pdf, tr, panel, venue, table, qrName, logoInfo)
+
+		pdf.SetDrawColor(160, 160, 160)
+		pdf.SetDashPattern([]float64{2, 2}, 0)
+		pdf.Line(0, panel, pageWidth, panel)
+		pdf.SetDashPattern([]float64{}, 0)
+	}
+
+	return pdf.Output(w)
+}
+
+// drawPanel draws one side of a tent card with its top at y.
+func drawPanel(pdf *fpdf.Fpdf, tr func(string) string, y float64, venue *structs.Venue, table structs.TableCode, qrName string, logo *fpdf.ImageInfoType) {
+	if logo != nil {
+		width := logoHeight * logo.Width() / logo.Height()
+		pdf.ImageOptions("logo", (pageWidth-width)/2, y+10, width, logoHeight, false, fpdf.ImageOptions{}, 0, "")
+	}
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.SetXY(0, y+30)
+	pdf.CellFormat(pageWidth, 8, tr(venue.Name), "", 0, "C", false, 0, "")
+
+	pdf.ImageOptions(qrName, (pageWidth-qrSize)/2, y+40, qrSize, qrSize, false, fpdf.ImageOptions{}, 0, "")
+
+	pdf.SetFont("Helvetica", "B", 22)
+	pdf.SetXY(0, y+113)
+	pdf.CellFormat(pageWidth, 10, tr("Table "+table.Name()), "", 0, "C", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 11)
+	if table.Section != "" {
+		pdf.SetXY(0, y+123)
+		pdf.CellFormat(pageWidth, 6, tr(table.Section), "", 0, "C", false, 0, "")
+	}
+	pdf.SetXY(0, y+131)
+	pdf.CellFormat(pageWidth, 6, "Scan to see the menu and order", "", 0, "C", false, 0, "")
+}
+
+// WriteZIP writes the QR code of every active table of the venue as a PNG and an SVG, named
+// after the table. The logo is the venue image, drawn on the codes when the venue chose to.
+func WriteZIP(w io.Writer, venue *structs.Venue, logo []byte) error {
+	opts, err := qr.VenueOptions(venue.QR, logo)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	seen := map[string]bool{}
+	for _, table := range venue.ActiveTables() {
+		name := fileName(table)
+		if seen[name] {
+			name += "-" + table.Code
+		}
+		seen[name] = true
+
+		png, err := qr.PNG(table.CodeUrl, opts)
+		if err != nil {
+			return fmt.Errorf("failed to render QR code of table %v: %w", table.Name(), err)
+		}
+		svg, err := qr.SVG(table.CodeUrl, opts)
+		if err != nil {
+			return fmt.Errorf("failed to render QR code of table %v: %w", table.Name(), err)
+		}
+		if err := addFile(archive, name+".png", png); err != nil {
+			return err
+		}
+		if err := addFile(archive, name+".svg", svg); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func addFile(archive *zip.Writer, name string, content []byte) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
+
+// fileName returns a file name for the QR code of a table, without extension.
+func fileName(table structs.TableCode) string {
+	return "table-" + unsafeFileChars.ReplaceAllString(table.Name(), "-")
+}
+
+// imageType returns the fpdf image type of a logo, or an empty string for unsupported images.
+func imageType(image []byte) string {
+	if len(image) == 0 {
+		return ""
+	}
+	switch http.DetectContentType(image) {
+	case "image/jpeg":
+		return "JPG"
+	case "image/png":
+		return "PNG"
+	}
+	return ""
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"encoding/base64"
 	"github.com/skip2/go-qrcode"
+	"os"
+	"strings"
 )
 
 func GenerateQRCodeBase64(content string) (string, error) {
@@ -18,3 +20,19 @@ func GenerateQRCodeBase64(content string) (string, error) {
 	// Return the data with a proper data URI scheme for embedding in HTML or other contexts
 	return base64Data, nil
 }
+
+// IsLocal reports whether the service runs on a development machine, ENVIRONMENT=local.
+func IsLocal() bool {
+	return os.Getenv("ENVIRONMENT") == "local"
+}
+
+// PublicBaseURL returns the URL guests reach this service at, PUBLIC_BASE_URL when it is set.
+func PublicBaseURL() string {
+	if baseURL := os.Getenv("PUBLIC_BASE_URL"); baseURL != "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+	if IsLocal() {
+		return "http://localhost:9090"
+	}
+	return "https://the-account.vortex.studio"
+}
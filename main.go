@@ -8,8 +8,16 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"vortex.studio/account/internal/auth"
+	"vortex.studio/account/internal/blobstore"
 	"vortex.studio/account/internal/handlers"
+	"vortex.studio/account/internal/keycloak"
+	"vortex.studio/account/internal/mailer"
+	"vortex.studio/account/internal/payments"
+	"vortex.studio/account/internal/printing"
+	"vortex.studio/account/internal/pubsub"
 	"vortex.studio/account/internal/repo"
+	"vortex.studio/account/internal/utils"
 )
 
 func main() {
@@ -31,21 +39,179 @@ func main() {
 	activeTablesRepo := repo.NewActiveTablesRepository(db)
 	eventsRepo := repo.NewEventsRepo(db)
 	menuRepo := repo.NewMenuRepository(db)
+	feedbackRepo := repo.NewFeedbackRepository(db)
+	guestsRepo := repo.NewGuestProfilesRepository(db)
+	loyaltyRepo := repo.NewLoyaltyRepository(db)
+	reservationsRepo := repo.NewReservationsRepository(db)
+	waitlistRepo := repo.NewWaitlistRepository(db)
+	printJobsRepo := repo.NewPrintJobsRepository(db)
+	auditRepo := repo.NewAuditRepository(db)
+	broker := pubsub.NewBroker()
 
-	adminHandler := handlers.NewAdminHandler(*venueRepository, activeTablesRepo, menuRepo)
-	tablesHandler := handlers.NewTablesHandler(venueRepository, activeTablesRepo, eventsRepo, menuRepo)
+	if err := activeTablesRepo.EnsureIndexes(context.TODO()); err != nil {
+		log.Fatalf("Failed to create session indexes: %v", err)
+	}
+	if err := reservationsRepo.EnsureIndexes(context.TODO()); err != nil {
+		log.Fatalf("Failed to create reservation indexes: %v", err)
+	}
+
+	blobStorePath := os.Getenv("BLOB_STORE_PATH")
+	if blobStorePath == "" {
+		blobStorePath = "data/blobs"
+	}
+	imageStore, err := blobstore.NewLocalStore(blobStorePath)
+	if err != nil {
+		log.Fatalf("Failed to create blob store: %v", err)
+	}
+
+	// Emails are optional, they stay disabled unless SMTP is configured
+	var emailSender mailer.Sender
+	if smtpSender := mailer.NewSMTPSenderFromEnv(); smtpSender != nil {
+		emailSender = smtpSender
+	}
+
+	paymentProvider, err := payments.NewProviderFromEnv(utils.PublicBaseURL())
+	if err != nil {
+		log.Fatalf("Failed to configure payments: %v", err)
+	}
+
+	// Staff sign in against the Keycloak realm of their tenant
+	authConfig := auth.ConfigFromEnv(utils.PublicBaseURL())
+	if !authConfig.Configured() {
+		log.Printf("KEYCLOAK_URL or OIDC_CLIENT_SECRET is not set, admin login is disabled")
+	}
+	authenticator := auth.NewAuthenticator(authConfig)
+
+	// Staff are managed in the tenant realms through the Keycloak admin API
+	keycloakClient, err := keycloak.NewAdminClientFromEnv()
+	if err != nil {
+		log.Printf("Staff management is disabled: %v", err)
+	}
+	handlers.RecheckSessionsWith(keycloakClient)
+
+	adminHandler := handlers.NewAdminHandler(*venueRepository, activeTablesRepo, menuRepo, reservationsRepo, imageStore, authenticator, broker)
+	tablesHandler := handlers.NewTablesHandler(venueRepository, activeTablesRepo, eventsRepo, menuRepo, guestsRepo, loyaltyRepo, reservationsRepo, printJobsRepo, emailSender, paymentProvider, broker)
+	receiptsHandler := handlers.NewReceiptsHandler(eventsRepo, venueRepository, emailSender)
+	feedbackHandler := handlers.NewFeedbackHandler(feedbackRepo, eventsRepo, venueRepository)
+	guestHandler := handlers.NewGuestHandler(guestsRepo, venueRepository, loyaltyRepo, emailSender)
+	loyaltyHandler := handlers.NewLoyaltyHandler(loyaltyRepo)
+	reservationsHandler := handlers.NewReservationsHandler(reservationsRepo, venueRepository, emailSender)
+	printingHandler := handlers.NewPrintingHandler(venueRepository, menuRepo, printJobsRepo)
+	waitlistHandler := handlers.NewWaitlistHandler(waitlistRepo, venueRepository, activeTablesRepo, eventsRepo, reservationsRepo, broker)
+	staffHandler := handlers.NewStaffHandler(keycloakClient, authConfig.ClientID)
+	imagesHandler := handlers.NewImagesHandler(imageStore)
+	qrHandler := handlers.NewQRHandler(venueRepository, imageStore)
+	auditHandler := handlers.NewAuditHandler(auditRepo)
+
+	go printing.NewWorker(printJobsRepo).Run(context.Background())
+
+	// Changes staff make are kept in the audit log
+	router.Use(handlers.Audit(auditRepo))
 
 	router.HandleFunc("/admin", adminHandler.AccountHandler).Methods("GET")
-	router.HandleFunc("/table", adminHandler.AddTableHandler).Methods("POST")
+	router.HandleFunc("/admin/audit", handlers.Require(auth.ViewReports, auditHandler.AuditLogHandler)).Methods("GET")
+	router.HandleFunc("/admin/audit/export", handlers.Require(auth.ViewReports, auditHandler.AuditExportHandler)).Methods("GET")
+	router.HandleFunc("/admin/feedback", handlers.Require(auth.ViewReports, feedbackHandler.AdminFeedbackHandler)).Methods("GET")
+	router.HandleFunc("/admin/loyalty", handlers.Require(auth.ManageVenues, loyaltyHandler.AdminLoyaltyHandler)).Methods("GET", "POST")
+	router.HandleFunc("/admin/loyalty/rewards", handlers.Require(auth.ManageVenues, loyaltyHandler.AddRewardHandler)).Methods("POST")
+	router.HandleFunc("/admin/loyalty/rewards/{id}/delete", handlers.Require(auth.ManageVenues, loyaltyHandler.RemoveRewardHandler)).Methods("POST")
+	router.HandleFunc("/admin/menus", handlers.Require(auth.ManageVenues, adminHandler.MenusHandler)).Methods("GET")
+	router.HandleFunc("/admin/menus/{menu}", handlers.Require(auth.ManageVenues, adminHandler.RenameMenuHandler)).Methods("POST")
+	router.HandleFunc("/admin/menus/{menu}/delete", handlers.Require(auth.ManageVenues, adminHandler.DeleteMenuHandler)).Methods("POST")
+	router.HandleFunc("/admin/reservations", handlers.Require(auth.ManageTables, reservationsHandler.CalendarHandler)).Methods("GET")
+	router.HandleFunc("/admin/reservations/{id}/table", handlers.Require(auth.ManageTables, reservationsHandler.AssignTableHandler)).Methods("POST")
+	router.HandleFunc("/admin/reservations/{id}/cancel", handlers.Require(auth.ManageTables, reservationsHandler.CancelReservationHandler)).Methods("POST")
+	router.HandleFunc("/admin/waitlist", handlers.Require(auth.ManageTables, waitlistHandler.AdminWaitlistHandler)).Methods("GET")
+	router.HandleFunc("/admin/waitlist/{id}/seat", handlers.Require(auth.ManageTables, waitlistHandler.SeatHandler)).Methods("POST")
+	router.HandleFunc("/admin/waitlist/{id}/remove", handlers.Require(auth.ManageTables, waitlistHandler.RemoveHandler)).Methods("POST")
+	router.HandleFunc("/admin/events", handlers.Require(auth.ViewDashboard, tablesHandler.SessionEventsHandler)).Methods("GET")
+	router.HandleFunc("/admin/sessions/{code}", handlers.Require(auth.ViewDashboard, tablesHandler.SessionCardHandler)).Methods("GET")
+	router.HandleFunc("/admin/floor", handlers.Require(auth.ManageTables, adminHandler.FloorHandler)).Methods("GET")
+	router.HandleFunc("/admin/floor/grid", handlers.Require(auth.ManageTables, adminHandler.FloorGridHandler)).Methods("GET")
+	router.HandleFunc("/admin/printing", handlers.Require(auth.PrintTickets, printingHandler.AdminPrintingHandler)).Methods("GET")
+	router.HandleFunc("/admin/printing/jobs/{id}/reprint", handlers.Require(auth.PrintTickets, printingHandler.ReprintHandler)).Methods("POST")
+	router.HandleFunc("/admin/staff", handlers.Require(auth.ManageStaff, staffHandler.AdminStaffHandler)).Methods("GET")
+	router.HandleFunc("/admin/staff", handlers.Require(auth.ManageStaff, staffHandler.InviteHandler)).Methods("POST")
+	router.HandleFunc("/admin/staff/{id}/role", handlers.Require(auth.ManageStaff, staffHandler.RoleHandler)).Methods("POST")
+	router.HandleFunc("/admin/staff/{id}/enabled", handlers.Require(auth.ManageStaff, staffHandler.EnabledHandler)).Methods("POST")
+	router.HandleFunc("/admin/staff/{id}/delete", handlers.Require(auth.ManageStaff, staffHandler.RemoveHandler)).Methods("POST")
+	router.HandleFunc("/table", handlers.Require(auth.ManageVenues, adminHandler.AddTableHandler)).Methods("POST")
 	router.HandleFunc("/login", adminHandler.LoginHandler).Methods("POST")
-	router.HandleFunc("/logout", adminHandler.LogoutHandler).Methods("GET")
-	router.HandleFunc("/venue", adminHandler.VenueHandler).Methods("POST")
+	router.HandleFunc("/auth/callback", adminHandler.CallbackHandler).Methods("GET")
+	router.HandleFunc("/logout", handlers.CheckCSRF(adminHandler.LogoutHandler)).Methods("POST")
+	router.HandleFunc("/venue", handlers.Require(auth.ManageVenues, adminHandler.VenueHandler)).Methods("POST")
+	router.HandleFunc("/venue/import", handlers.Require(auth.ManageVenues, adminHandler.ImportVenueHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}", handlers.Require(auth.DeleteVenues, adminHandler.DeleteVenueHandler)).Methods("DELETE")
+	router.HandleFunc("/venue/{id}/export.{format:json|yaml}", handlers.Require(auth.ManageVenues, adminHandler.ExportVenueHandler)).Methods("GET")
+	router.HandleFunc("/venue/{id}/menu", handlers.Require(auth.ManageVenues, adminHandler.VenueMenuHandler)).Methods("GET")
+	router.HandleFunc("/venue/{id}/menu.csv", handlers.Require(auth.ManageVenues, adminHandler.ExportMenuCSVHandler)).Methods("GET")
+	router.HandleFunc("/venue/{id}/menu.csv", handlers.Require(auth.ManageVenues, adminHandler.ImportMenuCSVHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/menu/link", handlers.Require(auth.ManageVenues, adminHandler.LinkMenuHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/printers", handlers.Require(auth.ManageVenues, printingHandler.AddPrinterHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/printers/{printer}/delete", handlers.Require(auth.ManageVenues, printingHandler.RemovePrinterHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/menu/{category}/station", handlers.Require(auth.ManageVenues, printingHandler.CategoryStationHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables", handlers.Require(auth.ManageVenues, adminHandler.AddTablesHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables/{code}", handlers.Require(auth.ManageVenues, adminHandler.RenameTableHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables/{code}/regenerate", handlers.Require(auth.ManageVenues, adminHandler.RegenerateTableHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables/{code}/retire", handlers.Require(auth.ManageVenues, adminHandler.RetireTableHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables/{code}/restore", handlers.Require(auth.ManageVenues, adminHandler.RestoreTableHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables/{code}/layout", handlers.Require(auth.ManageVenues, adminHandler.TableLayoutHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tables/{code}/layout/delete", handlers.Require(auth.ManageVenues, adminHandler.RemoveTableLayoutHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/floor", handlers.Require(auth.ManageVenues, adminHandler.FloorSizeHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/tent-cards.pdf", handlers.Require(auth.ManageVenues, adminHandler.TentCardsHandler)).Methods("GET")
+	router.HandleFunc("/venue/{id}/qr-codes.zip", handlers.Require(auth.ManageVenues, adminHandler.QRCodesHandler)).Methods("GET")
+	router.HandleFunc("/venue/{id}/qr", handlers.Require(auth.ManageVenues, adminHandler.QRSettingsHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/pickup", handlers.Require(auth.ManageVenues, adminHandler.PickupSettingsHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/reservations", handlers.Require(auth.ManageVenues, reservationsHandler.SettingsHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/image", handlers.Require(auth.ManageVenues, adminHandler.VenueImageHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/menu/{category}/{item}/image", handlers.Require(auth.ManageVenues, adminHandler.MenuItemImageHandler)).Methods("POST")
+	router.HandleFunc("/venue/{id}/menu/{category}/{item}/override", handlers.Require(auth.ManageVenues, adminHandler.ItemOverrideHandler)).Methods("POST")
+
+	router.HandleFunc("/images/{key:.+}", imagesHandler.ImageHandler).Methods("GET")
+	router.HandleFunc("/qr/{code}.{format:png|svg}", qrHandler.QRCodeHandler).Methods("GET")
 
 	router.HandleFunc("/table/{code}", tablesHandler.CodeHandler).Methods("GET", "POST")
+	router.HandleFunc("/pickup/{venue}", tablesHandler.PickupHandler).Methods("GET", "POST")
 	router.HandleFunc("/order/{code}", tablesHandler.OrderHandler).Methods("POST", "GET")
 	router.HandleFunc("/order/{code}/place", tablesHandler.PlaceOrderHandler).Methods("POST")
+	router.HandleFunc("/order/{code}/account", tablesHandler.BillRequestHandler).Methods("POST")
 	router.HandleFunc("/history/{code}", tablesHandler.OrderHistoryHandler).Methods("GET")
-	router.HandleFunc("/close/{code}", tablesHandler.CloseOrderHandler).Methods("POST")
+	router.HandleFunc("/close/{code}", handlers.Require(auth.ManageTables, tablesHandler.CloseOrderHandler)).Methods("POST")
+	router.HandleFunc("/session/{code}/served", handlers.Require(auth.ManageTables, adminHandler.ServedHandler)).Methods("POST")
+	router.HandleFunc("/session/{code}/move", handlers.Require(auth.ManageTables, tablesHandler.MoveSessionHandler)).Methods("POST")
+	router.HandleFunc("/session/{code}/merge", handlers.Require(auth.ManageTables, tablesHandler.MergeSessionHandler)).Methods("POST")
+	router.HandleFunc("/loyalty/{code}/redeem", tablesHandler.RedeemHandler).Methods("POST")
+
+	router.HandleFunc("/pay/{code}", tablesHandler.PayHandler).Methods("POST")
+	router.HandleFunc("/payments/webhook", tablesHandler.PaymentWebhookHandler).Methods("POST")
+	if fakeProvider, ok := paymentProvider.(*payments.FakeProvider); ok {
+		fakePaymentsHandler := handlers.NewFakePaymentsHandler(fakeProvider)
+		router.HandleFunc("/payments/fake/{id}", fakePaymentsHandler.CheckoutHandler).Methods("GET")
+		router.HandleFunc("/payments/fake/{id}", fakePaymentsHandler.CompleteHandler).Methods("POST")
+	}
+
+	router.HandleFunc("/receipt/{token}", receiptsHandler.ReceiptHandler).Methods("GET")
+	router.HandleFunc("/receipt/{token}/pdf", receiptsHandler.ReceiptPDFHandler).Methods("GET")
+	router.HandleFunc("/receipt/{token}/email", receiptsHandler.EmailReceiptHandler).Methods("POST")
+	router.HandleFunc("/feedback/{token}", feedbackHandler.FeedbackHandler).Methods("GET", "POST")
+
+	router.HandleFunc("/book/{venue}", reservationsHandler.BookingHandler).Methods("GET", "POST")
+	router.HandleFunc("/book/{venue}/{reference}", reservationsHandler.BookingDetailsHandler).Methods("GET")
+	router.HandleFunc("/book/{venue}/{reference}/cancel", reservationsHandler.CancelBookingHandler).Methods("POST")
+
+	router.HandleFunc("/waitlist/{venue}", waitlistHandler.WaitlistHandler).Methods("GET", "POST")
+	router.HandleFunc("/waitlist/{venue}/status", waitlistHandler.StatusHandler).Methods("GET")
+	router.HandleFunc("/waitlist/{venue}/leave", waitlistHandler.LeaveHandler).Methods("POST")
+	router.HandleFunc("/waitlist/{venue}/events", waitlistHandler.EventsHandler).Methods("GET")
+
+	router.HandleFunc("/guest/link/{token}", guestHandler.LinkHandler).Methods("GET", "POST")
+	router.HandleFunc("/guest/{venue}", guestHandler.ProfileHandler).Methods("GET")
+	router.HandleFunc("/guest/{venue}/account", guestHandler.UpgradeHandler).Methods("POST")
+	router.HandleFunc("/guest/{venue}/signin", guestHandler.SignInHandler).Methods("POST")
+	router.HandleFunc("/guest/{venue}/points", guestHandler.PointsHandler).Methods("GET")
+
+	router.HandleFunc("/api/staff", handlers.Require(auth.ManageStaff, staffHandler.APIStaffHandler)).Methods("GET", "POST")
+	router.HandleFunc("/api/staff/{id}", handlers.Require(auth.ManageStaff, staffHandler.APIStaffMemberHandler)).Methods("PATCH", "DELETE")
 
 	router.HandleFunc("/tenant", handlers.CreateTenantHandler).Methods("POST")
 